@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jorgeSader/devify-test-app/data"
+	"github.com/jorgeSader/devify-test-app/migrations"
+)
+
+// command is a command-line subcommand. run receives the arguments after the command name
+// and returns the process exit code.
+type command struct {
+	usage string
+	run   func(a *application, args []string) int
+}
+
+// Usage lines of the commands.
+const (
+	importUsersUsage  = "import-users [-format csv|json] [-batch n] [-workers n] [-json] FILE|-"
+	exportUsersUsage  = "export-users [-format csv|ndjson|xml] [-columns col,...] [-o FILE]"
+	seedUsage         = "seed [FILE...]"
+	migrateUsage      = "migrate up | down [n|all] | to VERSION | status | force VERSION"
+	schemaCheckUsage  = "schema-check"
+	unusedTokensUsage = "tokens:unused DAYS"
+)
+
+// defaultSeedFile holds the development fixtures seed loads when no file is given.
+const defaultSeedFile = "fixtures/seed.yml"
+
+// commands are the subcommands main runs instead of the web server when given arguments.
+var commands = map[string]command{
+	"import-users":  {importUsersUsage, (*application).importUsers},
+	"export-users":  {exportUsersUsage, (*application).exportUsers},
+	"seed":          {seedUsage, (*application).seed},
+	"migrate":       {migrateUsage, (*application).migrate},
+	"schema-check":  {schemaCheckUsage, (*application).schemaCheck},
+	"tokens:unused": {unusedTokensUsage, (*application).unusedTokens},
+}
+
+// runCommand runs the subcommand named by args[0] and returns the process exit code.
+func (a *application) runCommand(args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\ncommands:\n", args[0])
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+		}
+		return 2
+	}
+	return cmd.run(a, args[1:])
+}
+
+// importUsers bulk-loads users from a CSV or JSON file, or standard input if the file is "-", and prints
+// a per-row report. It exits with status 1 if any row failed.
+func (a *application) importUsers(args []string) int {
+	flags := flag.NewFlagSet("import-users", flag.ContinueOnError)
+	formatName := flags.String("format", "", "input format, csv or json; taken from the file name if empty")
+	batchSize := flags.Int("batch", data.DefaultImportBatchSize, "rows written per transaction")
+	workers := flags.Int("workers", 0, "passwords hashed concurrently; the number of CPUs if zero")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage:", importUsersUsage)
+		return 2
+	}
+
+	path := flags.Arg(0)
+	if *formatName == "" {
+		*formatName = filepath.Ext(path)
+	}
+	format, err := data.ParseImportFormat(*formatName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report, importErr := a.Models.Users.Import(ctx, in, data.ImportOptions{Format: format, BatchSize: *batchSize, Workers: *workers})
+
+	if report != nil {
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(report)
+		} else {
+			for _, rowErr := range report.Errors {
+				fields := make([]string, 0, len(rowErr.Errors))
+				for field := range rowErr.Errors {
+					fields = append(fields, field)
+				}
+				sort.Strings(fields)
+				for _, field := range fields {
+					fmt.Printf("row %d (%s): %s: %s\n", rowErr.Row, rowErr.Email, field, rowErr.Errors[field])
+				}
+			}
+			fmt.Printf("%d rows read: %d imported, %d failed\n", report.Rows, report.Imported, report.Failed)
+		}
+	}
+	if importErr != nil {
+		fmt.Fprintln(os.Stderr, "import stopped:", importErr)
+		return 1
+	}
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// exportUsers streams every live user to a file, or standard output, in CSV, NDJSON or XML.
+func (a *application) exportUsers(args []string) int {
+	flags := flag.NewFlagSet("export-users", flag.ContinueOnError)
+	formatName := flags.String("format", "", "output format, csv, ndjson or xml; taken from the output file name if empty")
+	columns := flags.String("columns", "", "comma-separated columns to export; all of "+strings.Join(data.UserExportColumns(), ",")+" if empty")
+	output := flags.String("o", "-", "output file, or - for standard output")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage:", exportUsersUsage)
+		return 2
+	}
+
+	opts := data.ExportOptions{Format: data.ExportCSV}
+	if *formatName == "" && *output != "-" {
+		*formatName = filepath.Ext(*output)
+	}
+	if *formatName != "" {
+		format, err := data.ParseExportFormat(*formatName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		opts.Format = format
+	}
+	if *columns != "" {
+		opts.Columns = strings.Split(*columns, ",")
+	}
+	if err := opts.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		out = f
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	n, err := a.Models.Users.Export(ctx, out, opts)
+	if out != os.Stdout {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export stopped:", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%d users exported\n", n)
+	return 0
+}
+
+// seed loads YAML or JSON fixtures, fixtures/seed.yml by default, into the database and prints the plain text
+// of the tokens it created, which cannot be recovered later.
+func (a *application) seed(args []string) int {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		fmt.Fprintln(os.Stderr, "usage:", seedUsage)
+		return 2
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{defaultSeedFile}
+	}
+
+	f, err := data.ReadFixtureFiles(paths...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := f.Load(a.Models); err != nil {
+		fmt.Fprintln(os.Stderr, "seed failed:", err)
+		// Remove whatever was loaded before the failure, so the seed can be fixed and run again.
+		if err := f.Unload(a.Models); err != nil {
+			fmt.Fprintln(os.Stderr, "removing partially seeded records failed:", err)
+		}
+		return 1
+	}
+
+	fmt.Printf("seeded %d users and %d tokens\n", len(f.Users), len(f.Tokens))
+	for _, tf := range f.Tokens {
+		if tf.Ref != "" {
+			fmt.Printf("  token %s: %s\n", tf.Ref, f.PlainText(tf.Ref))
+		}
+	}
+	return 0
+}
+
+// migrate applies or reverts the embedded migrations for DATABASE_TYPE and DATABASE_KEY_TYPE, or prints
+// the schema version and the applied and pending migrations. down reverts one migration unless told more.
+func (a *application) migrate(args []string) int {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "usage:", migrateUsage)
+		return 2
+	}
+	m, err := migrations.New(a.App.DB.Pool, os.Getenv("DATABASE_TYPE"), os.Getenv("DATABASE_KEY_TYPE"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer m.Close()
+
+	arg := ""
+	if len(args) == 2 {
+		arg = args[1]
+	}
+	switch {
+	case args[0] == "up" && arg == "":
+		err = m.Up()
+	case args[0] == "down" && arg == "all":
+		err = m.DownAll()
+	case args[0] == "down":
+		n := 1
+		if arg != "" {
+			if n, err = strconv.Atoi(arg); err != nil || n < 1 {
+				fmt.Fprintln(os.Stderr, "usage:", migrateUsage)
+				return 2
+			}
+		}
+		err = m.Down(n)
+	case args[0] == "to" && arg != "":
+		var v uint64
+		if v, err = strconv.ParseUint(arg, 10, 0); err != nil {
+			fmt.Fprintln(os.Stderr, "usage:", migrateUsage)
+			return 2
+		}
+		err = m.To(uint(v))
+	case args[0] == "force" && arg != "":
+		var v int
+		if v, err = strconv.Atoi(arg); err != nil {
+			fmt.Fprintln(os.Stderr, "usage:", migrateUsage)
+			return 2
+		}
+		err = m.Force(v)
+	case args[0] == "status" && arg == "":
+	default:
+		fmt.Fprintln(os.Stderr, "usage:", migrateUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "migrate failed:", err)
+		return 1
+	}
+
+	status, err := m.Status()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	printMigrationStatus(os.Stdout, status)
+	return 0
+}
+
+// printMigrationStatus writes the schema version followed by each migration and whether it is applied.
+func printMigrationStatus(w io.Writer, s *migrations.Status) {
+	dirty := ""
+	if s.Dirty {
+		dirty = " (dirty)"
+	}
+	fmt.Fprintf(w, "schema version %d%s, latest %d\n", s.Current, dirty, s.Latest)
+	for _, m := range s.Applied {
+		fmt.Fprintf(w, "  applied  %d_%s\n", m.Version, m.Name)
+	}
+	for _, m := range s.Pending {
+		fmt.Fprintf(w, "  pending  %d_%s\n", m.Version, m.Name)
+	}
+}
+
+// schemaCheck compares the models' db tags with the live tables and prints each difference.
+// It exits with status 1 if there are any.
+func (a *application) schemaCheck(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage:", schemaCheckUsage)
+		return 2
+	}
+	drifts, err := data.CheckSchema(context.Background(), data.SchemaModels()...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "schema check failed:", err)
+		return 1
+	}
+	for _, d := range drifts {
+		fmt.Println(d)
+	}
+	if len(drifts) > 0 {
+		fmt.Fprintf(os.Stderr, "%d schema differences found\n", len(drifts))
+		return 1
+	}
+	fmt.Println("schema matches the models")
+	return 0
+}
+
+// unusedTokens lists the unexpired tokens not used in the last DAYS days, least recently used first, so they
+// can be reviewed before they are revoked.
+func (a *application) unusedTokens(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage:", unusedTokensUsage)
+		return 2
+	}
+	days, err := strconv.Atoi(args[0])
+	if err != nil || days < 0 {
+		fmt.Fprintln(os.Stderr, "usage:", unusedTokensUsage)
+		return 2
+	}
+
+	tokens, err := a.Models.Tokens.GetUnused(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "listing tokens failed:", err)
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSER\tEMAIL\tCREATED\tLAST USED\tFROM\tUSES\tEXPIRES")
+	for _, t := range tokens {
+		lastUsed := "never"
+		if t.LastUsedAt != nil {
+			lastUsed = t.LastUsedAt.Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", t.ID, t.UserID, t.Email, t.CreatedAt.Format(time.DateTime),
+			lastUsed, t.LastUsedIP, t.UseCount, t.Expires.Format(time.DateTime))
+	}
+	if err := w.Flush(); err != nil {
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%d tokens unused for %d days\n", len(tokens), days)
+	return 0
+}
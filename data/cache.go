@@ -0,0 +1,308 @@
+package data
+
+import (
+	"container/list"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Cache is a byte-oriented key/value store with per-entry expiry, used as a read-through cache in front of
+// user-by-ID and token-by-hash lookups.
+type Cache interface {
+	// Get returns the value stored under key and whether it was found.
+	Get(key string) ([]byte, bool, error)
+	// Set stores value under key for ttl.
+	Set(key string, value []byte, ttl time.Duration) error
+	// Delete removes the given keys; missing keys are ignored.
+	Delete(keys ...string) error
+}
+
+// Default time-to-live of cached entries, configurable via the CACHE_USER_TTL and CACHE_TOKEN_TTL environment variables.
+const (
+	DefaultUserCacheTTL  = 5 * time.Minute
+	DefaultTokenCacheTTL = time.Minute
+)
+
+var (
+	// cache is the cache the models read through, or nil if caching is disabled.
+	cache Cache
+	// userCacheTTL and tokenCacheTTL are how long cached users and tokens are kept.
+	userCacheTTL  = DefaultUserCacheTTL
+	tokenCacheTTL = DefaultTokenCacheTTL
+)
+
+// SetCache replaces the cache used by the models and the TTLs of cached users and tokens.
+// A nil cache disables caching; a zero TTL keeps the current one.
+func SetCache(c Cache, userTTL, tokenTTL time.Duration) {
+	cache = c
+	if userTTL > 0 {
+		userCacheTTL = userTTL
+	}
+	if tokenTTL > 0 {
+		tokenCacheTTL = tokenTTL
+	}
+}
+
+// cacheFromEnv builds the cache configured by the CACHE environment variable: "" or "none" disables caching,
+// "memory" uses an in-process LRU holding CACHE_SIZE entries, and "redis" uses the server at REDIS_HOST,
+// authenticating with REDIS_PASSWORD and prefixing keys with REDIS_PREFIX.
+func cacheFromEnv() (Cache, time.Duration, time.Duration, error) {
+	userTTL, err := envDuration("CACHE_USER_TTL", DefaultUserCacheTTL)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	tokenTTL, err := envDuration("CACHE_TOKEN_TTL", DefaultTokenCacheTTL)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	switch driver := strings.ToLower(os.Getenv("CACHE")); driver {
+	case "", "none":
+		return nil, userTTL, tokenTTL, nil
+	case "memory", "lru":
+		size := 1000
+		if s := os.Getenv("CACHE_SIZE"); s != "" {
+			if size, err = strconv.Atoi(s); err != nil || size <= 0 {
+				return nil, 0, 0, fmt.Errorf("invalid CACHE_SIZE: %s", s)
+			}
+		}
+		return NewLRUCache(size), userTTL, tokenTTL, nil
+	case "redis":
+		host := os.Getenv("REDIS_HOST")
+		if host == "" {
+			return nil, 0, 0, errors.New("REDIS_HOST environment variable not set")
+		}
+		return NewRedisCache(NewRedisPool(host, os.Getenv("REDIS_PASSWORD")), os.Getenv("REDIS_PREFIX")), userTTL, tokenTTL, nil
+	default:
+		return nil, 0, 0, fmt.Errorf("unknown CACHE: %s", driver)
+	}
+}
+
+// envDuration reads a duration such as "90s" from the environment, falling back to def if it is unset.
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s: %s", name, v)
+	}
+	return d, nil
+}
+
+// userCacheKey and tokenCacheKey name the cache entries for a user ID and a token hash.
+func userCacheKey(id ID) string {
+	return "user:" + id.String()
+}
+
+func tokenCacheKey(hash []byte) string {
+	return "token:" + hex.EncodeToString(hash)
+}
+
+// cached returns the value stored under key, or calls load and caches its result for ttl.
+// Cache failures are not fatal: the value is then simply loaded from the database.
+func cached[T any](key string, ttl time.Duration, load func() (*T, error)) (*T, error) {
+	if cache == nil {
+		return load()
+	}
+	if b, ok, err := cache.Get(key); err == nil && ok {
+		var v T
+		if json.Unmarshal(b, &v) == nil {
+			return &v, nil
+		}
+	}
+
+	v, err := load()
+	if err != nil {
+		return nil, err
+	}
+	if b, err := json.Marshal(v); err == nil {
+		_ = cache.Set(key, b, ttl)
+	}
+	return v, nil
+}
+
+// invalidate removes the given keys from the cache. It is best effort: if the cache cannot be reached,
+// stale entries expire after their TTL.
+func invalidate(keys ...string) {
+	if cache == nil || len(keys) == 0 {
+		return
+	}
+	_ = cache.Delete(keys...)
+}
+
+// LRUCache is an in-process Cache that evicts the least recently used entry once it holds its capacity.
+// It is safe for concurrent use.
+type LRUCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // front is most recently used
+	entries  map[string]*list.Element
+}
+
+// lruEntry is the value held by each element of LRUCache.order.
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRUCache returns an empty LRUCache holding at most capacity entries.
+func NewLRUCache(capacity int) *LRUCache {
+	return &LRUCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value stored under key, unless it is missing or expired.
+func (c *LRUCache) Get(key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := el.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(el)
+	return entry.value, true, nil
+}
+
+// Set stores value under key for ttl, evicting the least recently used entry if the cache is full.
+func (c *LRUCache) Set(key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(ttl)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.value = value
+		entry.expires = expires
+		c.order.MoveToFront(el)
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+	return nil
+}
+
+// Delete removes the given keys.
+func (c *LRUCache) Delete(keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.order.Remove(el)
+			delete(c.entries, key)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of entries in the cache, including expired ones not yet evicted.
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// RedisCache is a Cache backed by Redis. Keys are namespaced with a prefix so several apps can share a server.
+type RedisCache struct {
+	Pool   *redis.Pool
+	Prefix string
+}
+
+// NewRedisPool returns a connection pool for the Redis server at host (host:port).
+func NewRedisPool(host, password string) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     10,
+		MaxActive:   100,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", host, redis.DialPassword(password))
+		},
+		TestOnBorrow: func(conn redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := conn.Do("PING")
+			return err
+		},
+	}
+}
+
+// NewRedisCache returns a RedisCache using the given pool and key prefix.
+func NewRedisCache(pool *redis.Pool, prefix string) *RedisCache {
+	return &RedisCache{Pool: pool, Prefix: prefix}
+}
+
+// key returns the namespaced Redis key.
+func (c *RedisCache) key(key string) string {
+	if c.Prefix == "" {
+		return key
+	}
+	return c.Prefix + ":" + key
+}
+
+// Get returns the value stored under key and whether it was found.
+func (c *RedisCache) Get(key string) ([]byte, bool, error) {
+	conn := c.Pool.Get()
+	defer conn.Close()
+
+	b, err := redis.Bytes(conn.Do("GET", c.key(key)))
+	if errors.Is(err, redis.ErrNil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Set stores value under key for ttl.
+func (c *RedisCache) Set(key string, value []byte, ttl time.Duration) error {
+	conn := c.Pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("SET", c.key(key), value, "PX", ttl.Milliseconds())
+	return err
+}
+
+// Delete removes the given keys.
+func (c *RedisCache) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	conn := c.Pool.Get()
+	defer conn.Close()
+
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = c.key(key)
+	}
+	_, err := conn.Do("DEL", args...)
+	return err
+}
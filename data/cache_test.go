@@ -0,0 +1,165 @@
+package data
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestLRUCache tests storing, expiring, evicting and deleting entries.
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache(2)
+
+	_ = c.Set("a", []byte("1"), time.Minute)
+	_ = c.Set("b", []byte("2"), time.Minute)
+	if v, ok, _ := c.Get("a"); !ok || string(v) != "1" {
+		t.Fatalf("Get(a) = %q, %v; want 1, true", v, ok)
+	}
+
+	// "b" is now the least recently used entry, so adding "c" evicts it.
+	_ = c.Set("c", []byte("3"), time.Minute)
+	if _, ok, _ := c.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("got %d entries, want 2", c.Len())
+	}
+
+	_ = c.Set("a", []byte("updated"), -time.Second)
+	if _, ok, _ := c.Get("a"); ok {
+		t.Error("expected expired entry to be a miss")
+	}
+
+	_ = c.Delete("c", "missing")
+	if _, ok, _ := c.Get("c"); ok {
+		t.Error("expected c to be deleted")
+	}
+}
+
+// withCache installs a cache for the duration of a test.
+func withCache(t *testing.T, c Cache) {
+	t.Helper()
+	old := cache
+	cache = c
+	t.Cleanup(func() { cache = old })
+}
+
+// TestCached tests that cached loads hit the loader once and that invalidation forces a reload.
+func TestCached(t *testing.T) {
+	withCache(t, NewLRUCache(10))
+
+	loads := 0
+	load := func() (*User, error) {
+		loads++
+		return &User{ID: "1", FirstName: "Ann"}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		u, err := cached("user:1", time.Minute, load)
+		if err != nil || u.FirstName != "Ann" {
+			t.Fatalf("cached() = %v, %v", u, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("loader called %d times, want 1", loads)
+	}
+
+	invalidate(userCacheKey("1"))
+	if _, err := cached("user:1", time.Minute, load); err != nil || loads != 2 {
+		t.Errorf("expected a reload after invalidation, loads = %d, err = %v", loads, err)
+	}
+
+	failing := func() (*User, error) { return nil, errors.New("boom") }
+	if _, err := cached("user:2", time.Minute, failing); err == nil {
+		t.Error("expected the loader's error")
+	}
+	if _, ok, _ := cache.Get("user:2"); ok {
+		t.Error("expected failed loads not to be cached")
+	}
+}
+
+// TestCached_UserPassword tests that a user's password hash is never stored in the cache.
+func TestCached_UserPassword(t *testing.T) {
+	withCache(t, NewLRUCache(10))
+	load := func() (*User, error) {
+		return &User{ID: "1", FirstName: "Ann", Password: "$2a$04$hash"}, nil
+	}
+
+	if _, err := cached(userCacheKey("1"), time.Minute, load); err != nil {
+		t.Fatalf("cached() error = %v", err)
+	}
+	if b, _, _ := cache.Get(userCacheKey("1")); bytes.Contains(b, []byte("$2a$04$hash")) {
+		t.Errorf("cached user %s holds the password hash", b)
+	}
+	if u, err := cached(userCacheKey("1"), time.Minute, load); err != nil || u.FirstName != "Ann" || u.Password != "" {
+		t.Errorf("cached() = %+v, %v; want Ann without a password hash", u, err)
+	}
+}
+
+// TestToken_AuthenticateCached tests that a user authenticated through the cache carries the token they
+// presented rather than their latest one.
+func TestToken_AuthenticateCached(t *testing.T) {
+	withCache(t, NewLRUCache(10))
+	plainText, err := newTokenText(TokenLength)
+	if err != nil {
+		t.Fatalf("newTokenText() error = %v", err)
+	}
+	hash := sha256.Sum256([]byte(plainText))
+	put := func(key string, v interface{}) {
+		b, _ := json.Marshal(v)
+		_ = cache.Set(key, b, time.Minute)
+	}
+	put(tokenCacheKey(hash[:]), Token{ID: "1", UserID: "7", Hash: hash[:], Expires: time.Now().Add(time.Hour)})
+	put(userCacheKey("7"), User{ID: "7", Token: Token{ID: "2", UserID: "7", Expires: time.Now().Add(2 * time.Hour)}})
+
+	token, user, err := (&Token{}).authenticate(plainText)
+	if err != nil {
+		t.Fatalf("authenticate() error = %v", err)
+	}
+	if token.ID != "1" || user.Token.ID != "1" {
+		t.Errorf("authenticate() = token %s, user token %s; want the presented token 1 for both", token.ID, user.Token.ID)
+	}
+}
+
+// TestCacheFromEnv tests selecting the cache driver and TTLs from the environment.
+func TestCacheFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"Disabled", map[string]string{}, "<nil>", false},
+		{"Memory", map[string]string{"CACHE": "memory", "CACHE_SIZE": "5"}, "*data.LRUCache", false},
+		{"Redis", map[string]string{"CACHE": "redis", "REDIS_HOST": "localhost:6379"}, "*data.RedisCache", false},
+		{"RedisWithoutHost", map[string]string{"CACHE": "redis"}, "", true},
+		{"BadSize", map[string]string{"CACHE": "memory", "CACHE_SIZE": "lots"}, "", true},
+		{"BadTTL", map[string]string{"CACHE_USER_TTL": "soon"}, "", true},
+		{"Unknown", map[string]string{"CACHE": "memcached"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"CACHE", "CACHE_SIZE", "REDIS_HOST", "CACHE_USER_TTL", "CACHE_TOKEN_TTL"} {
+				t.Setenv(name, tt.env[name])
+			}
+			c, userTTL, tokenTTL, err := cacheFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cacheFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := fmt.Sprintf("%T", c); c == nil && tt.want != "<nil>" || c != nil && got != tt.want {
+				t.Errorf("got cache %s, want %s", got, tt.want)
+			}
+			if userTTL != DefaultUserCacheTTL || tokenTTL != DefaultTokenCacheTTL {
+				t.Errorf("got TTLs %v, %v; want defaults", userTTL, tokenTTL)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+// Package datatest provides helpers for tests that use the data package.
+package datatest
+
+import (
+	"testing"
+
+	"github.com/jorgeSader/devify-test-app/data"
+)
+
+// LoadFixtures reads and loads the fixtures in the given files for the duration of a test,
+// and deletes the loaded users and their tokens when it finishes.
+func LoadFixtures(tb testing.TB, m data.Models, paths ...string) *data.Fixtures {
+	tb.Helper()
+	f, err := data.ReadFixtureFiles(paths...)
+	if err != nil {
+		tb.Fatalf("reading fixtures: %v", err)
+	}
+	tb.Cleanup(func() {
+		if err := f.Unload(m); err != nil {
+			tb.Errorf("unloading fixtures: %v", err)
+		}
+	})
+	if err := f.Load(m); err != nil {
+		tb.Fatalf("loading fixtures: %v", err)
+	}
+	return f
+}
@@ -0,0 +1,26 @@
+package datatest
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/jorgeSader/devify-test-app/data"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestLoadFixtures tests that fixtures are loaded for a test and removed when it finishes.
+func TestLoadFixtures(t *testing.T) {
+	t.Setenv("BCRYPT_COST", strconv.Itoa(bcrypt.MinCost))
+	m := data.NewMemory()
+
+	t.Run("Load", func(t *testing.T) {
+		f := LoadFixtures(t, m, "../testdata/fixtures.yml")
+		if _, err := m.Users.Get(f.UserID("jane")); err != nil {
+			t.Errorf("failed to get fixture user: %v", err)
+		}
+	})
+
+	if all, _ := m.Users.GetAll(); len(all) != 0 {
+		t.Errorf("got %d users after the test finished, want 0", len(all))
+	}
+}
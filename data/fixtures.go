@@ -0,0 +1,401 @@
+package data
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/upper/db/v4"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Fixtures is a declarative set of users and tokens, read from YAML or JSON:
+//
+//	users:
+//	  - ref: jane                 # name other records use to refer to this one
+//	    first_name: Jane
+//	    last_name: Doe
+//	    email: jane@example.com
+//	    password: secret          # hashed with bcrypt on load
+//	    active: true              # defaults to true
+//	    created_at: 30d ago       # relative to load time, or RFC 3339; defaults to now
+//	    deleted_at: 1d ago        # soft-deletes the user; optional
+//	tokens:
+//	  - ref: jane-api
+//	    user: "@jane"             # a reference to a user, or their email
+//	    token: dvf_FixtureJaneApiToken0000000000000_3NQPpN   # plain text in the dvf_ format; generated if empty
+//	    scopes: users:read        # space-separated; optional
+//	    type: api                 # selects the expiry policy; defaults to api
+//	    expires: expires in 1h    # defaults to in 24h
+//
+// Relative times are Go durations, optionally in days such as "7d", written as "in 1h", "expires in 1h", "1h"
+// or "+1h" for the future and "1h ago" or "-1h" for the past; "now" is the load time.
+// After Load, the IDs of the records and the plain text of the tokens can be looked up by their refs.
+type Fixtures struct {
+	Users  []UserFixture  `yaml:"users"`
+	Tokens []TokenFixture `yaml:"tokens"`
+
+	users  map[string]ID
+	tokens map[string]*Token
+	loaded []ID
+}
+
+// UserFixture declares one user.
+type UserFixture struct {
+	Ref       string `yaml:"ref"`
+	FirstName string `yaml:"first_name"`
+	LastName  string `yaml:"last_name"`
+	Email     string `yaml:"email"`
+	Password  string `yaml:"password"`
+	Active    *bool  `yaml:"active"`
+	CreatedAt string `yaml:"created_at"`
+	DeletedAt string `yaml:"deleted_at"`
+}
+
+// TokenFixture declares one token for a user.
+type TokenFixture struct {
+	Ref       string `yaml:"ref"`
+	User      string `yaml:"user"`
+	Token     string `yaml:"token"`
+	Scopes    string `yaml:"scopes"`
+	Type      string `yaml:"type"` // defaults to api
+	Expires   string `yaml:"expires"`
+	CreatedAt string `yaml:"created_at"`
+}
+
+// ReadFixtures parses fixtures from YAML or JSON.
+func ReadFixtures(r io.Reader) (*Fixtures, error) {
+	var f Fixtures
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing fixtures: %w", err)
+	}
+	return &f, nil
+}
+
+// ReadFixtureFiles parses and merges the fixtures in the given files.
+func ReadFixtureFiles(paths ...string) (*Fixtures, error) {
+	all := &Fixtures{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		f, err := ReadFixtures(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		all.Users = append(all.Users, f.Users...)
+		all.Tokens = append(all.Tokens, f.Tokens...)
+	}
+	return all, nil
+}
+
+// UserID returns the ID of the loaded user with the given ref.
+func (f *Fixtures) UserID(ref string) ID {
+	return f.users[ref]
+}
+
+// Token returns the loaded token with the given ref, including its plain text.
+func (f *Fixtures) Token(ref string) *Token {
+	return f.tokens[ref]
+}
+
+// PlainText returns the plain text of the loaded token with the given ref.
+func (f *Fixtures) PlainText(ref string) string {
+	if t := f.tokens[ref]; t != nil {
+		return t.plainText
+	}
+	return ""
+}
+
+// Load inserts the fixtures into the models' stores: the SQL database of any supported dialect, or the in-memory
+// store. Users are inserted before tokens so tokens can refer to them. Unlike Insert, it keeps the declared
+// timestamps and lets a user have several tokens.
+func (f *Fixtures) Load(m Models) error {
+	now := time.Now()
+	w := fixtureWriterFor(m)
+	f.users = make(map[string]ID)
+	f.tokens = make(map[string]*Token)
+	byName := make(map[string]User) // by email and by "@" + ref
+	cost := bcryptCost()
+
+	for i, uf := range f.Users {
+		user, err := uf.user(now, cost)
+		if err != nil {
+			return fmt.Errorf("user %d (%s): %w", i+1, uf.Email, err)
+		}
+		if user.ID, err = w.insertUser(user); err != nil {
+			return fmt.Errorf("user %d (%s): %w", i+1, uf.Email, err)
+		}
+		f.loaded = append(f.loaded, user.ID)
+		byName[user.Email] = user
+		if uf.Ref != "" {
+			f.users[uf.Ref] = user.ID
+			byName["@"+uf.Ref] = user
+		}
+	}
+
+	for i, tf := range f.Tokens {
+		user, ok := byName[tf.User]
+		if !ok {
+			return fmt.Errorf("token %d: unknown user %q", i+1, tf.User)
+		}
+		token, err := tf.token(user, now)
+		if err != nil {
+			return fmt.Errorf("token %d: %w", i+1, err)
+		}
+		if token.ID, err = w.insertToken(*token); err != nil {
+			return fmt.Errorf("token %d: %w", i+1, err)
+		}
+		if tf.Ref != "" {
+			f.tokens[tf.Ref] = token
+		}
+	}
+	return nil
+}
+
+// Unload permanently deletes the users loaded by Load, along with their tokens.
+func (f *Fixtures) Unload(m Models) error {
+	err := fixtureWriterFor(m).deleteUsers(f.loaded)
+	if err == nil {
+		f.loaded = nil
+	}
+	return err
+}
+
+// user builds the user the fixture declares.
+func (uf UserFixture) user(now time.Time, cost int) (User, error) {
+	if uf.Email == "" || uf.Password == "" {
+		return User{}, errors.New("email and password are required")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(uf.Password), cost)
+	if err != nil {
+		return User{}, err
+	}
+	created, err := fixtureTime(uf.CreatedAt, now, now)
+	if err != nil {
+		return User{}, fmt.Errorf("created_at: %w", err)
+	}
+
+	user := User{
+		FirstName: uf.FirstName,
+		LastName:  uf.LastName,
+		Email:     uf.Email,
+		Password:  string(hash),
+		Active:    1,
+		CreatedAt: created,
+		UpdatedAt: created,
+		Version:   1,
+	}
+	if uf.Active != nil && !*uf.Active {
+		user.Active = 0
+	}
+	if uf.DeletedAt != "" {
+		deleted, err := fixtureTime(uf.DeletedAt, now, now)
+		if err != nil {
+			return User{}, fmt.Errorf("deleted_at: %w", err)
+		}
+		user.DeletedAt = &deleted
+	}
+	return user, nil
+}
+
+// token builds the token the fixture declares for user.
+func (tf TokenFixture) token(user User, now time.Time) (*Token, error) {
+	token := &Token{plainText: tf.Token}
+	if token.plainText == "" {
+		generated, err := token.GenerateToken(user.ID, 0)
+		if err != nil {
+			return nil, err
+		}
+		token.plainText = generated.plainText
+	} else if err := checkTokenFormat(token.plainText); err != nil {
+		return nil, fmt.Errorf("token: %w", err)
+	}
+	expires, err := fixtureTime(tf.Expires, now, now.Add(24*time.Hour))
+	if err != nil {
+		return nil, fmt.Errorf("expires: %w", err)
+	}
+	created, err := fixtureTime(tf.CreatedAt, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("created_at: %w", err)
+	}
+
+	hash := sha256.Sum256([]byte(token.plainText))
+	token.Hash = hash[:]
+	token.UserID = user.ID
+	token.FirstName = user.FirstName
+	token.Email = user.Email
+	token.Scopes = tf.Scopes
+	token.Type = tf.Type
+	if token.Type == "" {
+		token.Type = TokenTypeAPI
+	}
+	token.Expires = expires
+	token.CreatedAt = created
+	token.UpdatedAt = created
+	return token, nil
+}
+
+// fixtureTime parses a fixture timestamp: empty for def, "now", an absolute RFC 3339 time or date,
+// or a duration relative to now as described on Fixtures.
+func fixtureTime(s string, now, def time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	s = strings.ToLower(s)
+	switch s {
+	case "":
+		return def, nil
+	case "now":
+		return now, nil
+	}
+
+	s = strings.TrimSpace(strings.TrimPrefix(s, "expires"))
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(s, "in "):
+		s = strings.TrimPrefix(s, "in ")
+	case strings.HasSuffix(s, " ago"):
+		s, sign = strings.TrimSuffix(s, " ago"), -1
+	}
+	d, err := fixtureDuration(strings.TrimSpace(s))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	return now.Add(sign * d), nil
+}
+
+// fixtureDuration parses a Go duration, also accepting a whole number of days such as "7d".
+func fixtureDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// fixtureWriter writes fixture records to a store as they are, bypassing the stores' insert rules.
+type fixtureWriter interface {
+	insertUser(user User) (ID, error)
+	insertToken(token Token) (ID, error)
+	deleteUsers(ids []ID) error
+}
+
+// fixtureWriterFor returns the fixture writer for the models' stores.
+func fixtureWriterFor(m Models) fixtureWriter {
+	if mu, ok := m.Users.(*MemoryUsers); ok {
+		return memoryFixtureWriter{mu.store}
+	}
+	return sqlFixtureWriter{}
+}
+
+// sqlFixtureWriter writes fixtures to the database through upper, which handles the dialect.
+type sqlFixtureWriter struct{}
+
+func (sqlFixtureWriter) insertUser(user User) (ID, error) {
+	user.ID = NewID()
+	res, err := upper.Collection(user.Table()).Insert(user)
+	if err != nil {
+		return "", err
+	}
+	if !user.ID.IsZero() {
+		return user.ID, nil
+	}
+	return InsertID(res.ID())
+}
+
+func (sqlFixtureWriter) insertToken(token Token) (ID, error) {
+	token.ID = NewID()
+	res, err := upper.Collection(token.Table()).Insert(token)
+	if err != nil {
+		return "", err
+	}
+	invalidate(userCacheKey(token.UserID))
+	if !token.ID.IsZero() {
+		return token.ID, nil
+	}
+	return InsertID(res.ID())
+}
+
+func (sqlFixtureWriter) deleteUsers(ids []ID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	in := make([]interface{}, len(ids))
+	keys := make([]string, 0, len(ids))
+	for i, id := range ids {
+		in[i] = id
+		keys = append(keys, userCacheKey(id))
+	}
+
+	var tokens []*Token
+	if err := upper.Collection("tokens").Find(db.Cond{"user_id IN": in}).All(&tokens); err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		keys = append(keys, tokenCacheKey(token.Hash))
+	}
+	if err := upper.Collection("tokens").Find(db.Cond{"user_id IN": in}).Delete(); err != nil {
+		return err
+	}
+	if err := upper.Collection("users").Find(db.Cond{"id IN": in}).Delete(); err != nil {
+		return err
+	}
+	invalidate(keys...)
+	return nil
+}
+
+// memoryFixtureWriter writes fixtures to the in-memory store.
+type memoryFixtureWriter struct {
+	store *memoryStore
+}
+
+func (w memoryFixtureWriter) insertUser(user User) (ID, error) {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+	if !user.IsDeleted() && w.store.emailTaken(user.Email, "") {
+		return "", fmt.Errorf("%w: %s", ErrDuplicateEmail, user.Email)
+	}
+	user.ID = w.store.nextID(&w.store.nextUserID)
+	w.store.users[user.ID] = user
+	return user.ID, nil
+}
+
+func (w memoryFixtureWriter) insertToken(token Token) (ID, error) {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+	token.ID = w.store.nextID(&w.store.nextTokenID)
+	w.store.tokens[token.ID] = token
+	return token.ID, nil
+}
+
+func (w memoryFixtureWriter) deleteUsers(ids []ID) error {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+	for _, id := range ids {
+		delete(w.store.users, id)
+		for tokenID, token := range w.store.tokens {
+			if token.UserID == id {
+				delete(w.store.tokens, tokenID)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package data_test
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jorgeSader/devify-test-app/data"
+	"github.com/jorgeSader/devify-test-app/data/datatest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestLoadFixtures tests that fixtures resolve references, hash passwords, apply relative timestamps
+// and are removed again when the test finishes.
+func TestLoadFixtures(t *testing.T) {
+	t.Setenv("BCRYPT_COST", strconv.Itoa(bcrypt.MinCost))
+	m := data.NewMemory()
+
+	t.Run("Load", func(t *testing.T) {
+		f := datatest.LoadFixtures(t, m, "testdata/fixtures.yml")
+
+		jane, err := m.Users.Get(f.UserID("jane"))
+		if err != nil {
+			t.Fatalf("failed to get fixture user: %v", err)
+		}
+		if ok, _ := jane.PasswordMatches("Test@123"); !ok {
+			t.Error("expected the fixture password to be hashed")
+		}
+		if age := time.Since(jane.CreatedAt); age < 29*24*time.Hour || age > 31*24*time.Hour {
+			t.Errorf("got created_at %v ago, want about 30 days", age)
+		}
+		if _, err := m.Users.Get(f.UserID("gone")); err == nil {
+			t.Error("expected the soft-deleted fixture user to be hidden")
+		}
+
+		user, err := m.Tokens.GetUserForToken(f.PlainText("jane-api"))
+		if err != nil || user.ID != f.UserID("jane") {
+			t.Errorf("jane-api authenticates %v, %v; want jane", user.ID, err)
+		}
+		if tok, _ := m.Tokens.GetByToken(f.PlainText("jane-api")); tok == nil || tok.Scopes != "users:read" {
+			t.Errorf("expected jane-api to grant users:read, got %+v", tok)
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+f.PlainText("jane-api"))
+		if user, err := m.Tokens.AuthenticateToken(r); err != nil || !user.Token.HasScope(data.ScopeUsersRead) {
+			t.Errorf("expected jane-api to authenticate with users:read, got %v", err)
+		}
+		if valid, _ := m.Tokens.ValidToken(f.PlainText("jane-expired")); valid {
+			t.Error("expected the expired fixture token to be invalid")
+		}
+		if tok := f.Token("bob-api"); tok == nil || tok.UserID != f.UserID("bob") {
+			t.Errorf("expected a generated token for bob, got %+v", tok)
+		}
+		if tok, err := m.Tokens.GetByToken(f.PlainText("bob-api")); err != nil || tok.UserID != f.UserID("bob") {
+			t.Errorf("expected bob's generated token to be stored, got %v", err)
+		}
+		if tokens, _ := m.Tokens.GetTokensForUser(f.UserID("jane")); len(tokens) != 2 {
+			t.Errorf("got %d tokens for jane, want 2", len(tokens))
+		}
+	})
+
+	if all, _ := m.Users.GetAll(); len(all) != 0 {
+		t.Errorf("got %d users after the test finished, want 0", len(all))
+	}
+}
@@ -0,0 +1,56 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestReadFixtures_Errors tests that malformed fixtures and dangling references are rejected.
+func TestReadFixtures_Errors(t *testing.T) {
+	m := newMemoryModels(t)
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"UnknownField", "users:\n  - emial: jane@example.com\n"},
+		{"MissingPassword", `{"users": [{"email": "jane@example.com"}]}`},
+		{"DanglingRef", `{"tokens": [{"user": "@nobody"}]}`},
+		{"BadTime", "users:\n  - {email: jane@example.com, password: x, created_at: yesterday-ish}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ReadFixtures(strings.NewReader(tt.input))
+			if err == nil {
+				err = f.Load(m)
+				_ = f.Unload(m)
+			}
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// TestFixtureTime tests the absolute and relative timestamp forms.
+func TestFixtureTime(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		"":                     now.Add(time.Minute),
+		"now":                  now,
+		"in 1h":                now.Add(time.Hour),
+		"expires in 90m":       now.Add(90 * time.Minute),
+		"+2h":                  now.Add(2 * time.Hour),
+		"1h ago":               now.Add(-time.Hour),
+		"-7d":                  now.Add(-7 * 24 * time.Hour),
+		"2d ago":               now.Add(-48 * time.Hour),
+		"2024-01-02":           time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		"2024-01-02T03:04:05Z": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	for input, want := range tests {
+		got, err := fixtureTime(input, now, now.Add(time.Minute))
+		if err != nil || !got.Equal(want) {
+			t.Errorf("fixtureTime(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+}
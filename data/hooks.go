@@ -0,0 +1,121 @@
+package data
+
+import (
+	"errors"
+
+	"github.com/upper/db/v4"
+)
+
+// Lifecycle hooks. A model opts in by implementing any of these interfaces on its pointer type, for example
+//
+//	func (u *User) AfterUpdate(tx db.Session) error {
+//		_, err := tx.Collection("audit_log").Insert(AuditEntry{Table: "users", RecordID: u.ID, Action: "update"})
+//		return err
+//	}
+//
+// Hooks are called on the record being written, so before hooks can change its fields. They run in the same
+// transaction as the write, which they receive as tx and must use for any queries of their own; an error from
+// a hook aborts the operation and rolls the transaction back. Models without hooks are written without a
+// transaction. Writes that change many users, Import and PurgeDeleted, run the hooks once per user when the
+// model has any.
+//
+// Hooks run for the SQL stores only, not the in-memory ones. Bookkeeping writes to tokens also skip them: sliding
+// expiry and usage counts, the reaper removing expired tokens, replaced login tokens, and the tokens removed
+// along with their user by PurgeDeleted. Fixtures are loaded and unloaded without hooks.
+type (
+	// BeforeInserter is called before a record is inserted.
+	BeforeInserter interface{ BeforeInsert(tx db.Session) error }
+	// AfterInserter is called after a record is inserted, with its ID set.
+	AfterInserter interface{ AfterInsert(tx db.Session) error }
+	// BeforeUpdater is called before a record is updated.
+	BeforeUpdater interface{ BeforeUpdate(tx db.Session) error }
+	// AfterUpdater is called after a record is updated, with its new version and timestamp.
+	AfterUpdater interface{ AfterUpdate(tx db.Session) error }
+	// BeforeDeleter is called before a record is deleted, with the record as stored.
+	BeforeDeleter interface{ BeforeDelete(tx db.Session) error }
+	// AfterDeleter is called after a record is deleted.
+	AfterDeleter interface{ AfterDelete(tx db.Session) error }
+)
+
+// hookOp is the kind of write hooks are run for.
+type hookOp int
+
+const (
+	hookInsert hookOp = iota
+	hookUpdate
+	hookDelete
+)
+
+// hooksFor returns the before and after hooks record m implements for op; either may be nil.
+func hooksFor(m interface{}, op hookOp) (before, after func(tx db.Session) error) {
+	switch op {
+	case hookInsert:
+		if h, ok := m.(BeforeInserter); ok {
+			before = h.BeforeInsert
+		}
+		if h, ok := m.(AfterInserter); ok {
+			after = h.AfterInsert
+		}
+	case hookUpdate:
+		if h, ok := m.(BeforeUpdater); ok {
+			before = h.BeforeUpdate
+		}
+		if h, ok := m.(AfterUpdater); ok {
+			after = h.AfterUpdate
+		}
+	case hookDelete:
+		if h, ok := m.(BeforeDeleter); ok {
+			before = h.BeforeDelete
+		}
+		if h, ok := m.(AfterDeleter); ok {
+			after = h.AfterDelete
+		}
+	}
+	return before, after
+}
+
+// hasHooks reports whether record m implements a before or after hook for op.
+func hasHooks(m interface{}, op hookOp) bool {
+	before, after := hooksFor(m, op)
+	return before != nil || after != nil
+}
+
+// withHooks runs write for record m, a pointer to a model. If m has hooks for op, write runs between them in
+// a transaction whose session it is given, and any error rolls all three back; otherwise write is given the
+// primary session. If load is given, it is called first in the transaction to read the stored record into m;
+// for deletes, a record that does not exist is left alone without running any hook.
+func withHooks(m interface{}, op hookOp, load func(tx db.Session) error, write func(sess db.Session) error) error {
+	if !hasHooks(m, op) {
+		return write(upper)
+	}
+	return upper.Tx(func(tx db.Session) error {
+		return withHooksTx(tx, m, op, load, write)
+	})
+}
+
+// withHooksTx is withHooks within the caller's transaction tx, for writes that are part of a larger one.
+func withHooksTx(tx db.Session, m interface{}, op hookOp, load func(tx db.Session) error, write func(sess db.Session) error) error {
+	before, after := hooksFor(m, op)
+	if before == nil && after == nil {
+		return write(tx)
+	}
+	if load != nil {
+		if err := load(tx); errors.Is(err, db.ErrNoMoreRows) && op == hookDelete {
+			return nil // nothing to delete
+		} else if err != nil {
+			return err
+		}
+	}
+	if before != nil {
+		if err := before(tx); err != nil {
+			return err
+		}
+	}
+	if err := write(tx); err != nil {
+		return err
+	}
+	if after != nil {
+		return after(tx)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package data
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/upper/db/v4"
+)
+
+// hookedModel records the hooks run on it in hookCalls, and fails the hook named in hookFail.
+type hookedModel struct {
+	Repository[hookedModel] `db:"-"`
+	ID                      ID     `db:"id,omitempty"`
+	Name                    string `db:"name"`
+	Version                 int    `db:"version"`
+}
+
+var (
+	hookCalls []string
+	hookFail  string
+)
+
+func (m *hookedModel) Table() string { return "hooked" }
+
+func (m *hookedModel) hook(name string, tx db.Session) error {
+	if tx == nil {
+		return errors.New("no transaction")
+	}
+	hookCalls = append(hookCalls, name+":"+m.Name)
+	if hookFail == name {
+		return errors.New(name + " failed")
+	}
+	return nil
+}
+
+func (m *hookedModel) BeforeUpdate(tx db.Session) error {
+	m.Name += "!" // before hooks can change the record
+	return m.hook("BeforeUpdate", tx)
+}
+
+func (m *hookedModel) AfterUpdate(tx db.Session) error { return m.hook("AfterUpdate", tx) }
+
+// newMockSession installs a sqlmock-backed Postgres session for the duration of a test.
+func newMockSession(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	pool, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	mock.ExpectPing()
+	mock.ExpectQuery(`SELECT CURRENT_DATABASE\(\) AS name`).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("testdb"))
+	session, err := newSession("postgres", pool)
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	oldUpper, oldDialect := upper, dialect
+	upper, dialect = session, "postgres"
+	t.Cleanup(func() { upper, dialect = oldUpper, oldDialect })
+	return mock
+}
+
+// TestHooks tests that update hooks run in order inside the write's transaction, and that a failing hook
+// rolls the write back.
+func TestHooks(t *testing.T) {
+	tests := []struct {
+		name      string
+		fail      string
+		wantCalls []string
+		mock      func(sqlmock.Sqlmock)
+	}{
+		{"ok", "", []string{"BeforeUpdate:a!", "AfterUpdate:a!"}, func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectExec(`UPDATE "hooked"`).WithArgs(sqlmock.AnyArg(), "a!", 2, sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}},
+		{"before fails", "BeforeUpdate", []string{"BeforeUpdate:a!"}, func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+		}},
+		{"after fails", "AfterUpdate", []string{"BeforeUpdate:a!", "AfterUpdate:a!"}, func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectExec(`UPDATE "hooked"`).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectRollback()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := newMockSession(t)
+			tt.mock(mock)
+			hookCalls, hookFail = nil, tt.fail
+			defer func() { hookCalls, hookFail = nil, "" }()
+
+			err := hookedModel{}.Update(hookedModel{ID: "1", Name: "a", Version: 1})
+			if (err != nil) != (tt.fail != "") {
+				t.Errorf("Update() error = %v", err)
+			}
+			if len(hookCalls) != len(tt.wantCalls) {
+				t.Fatalf("hooks called %v, want %v", hookCalls, tt.wantCalls)
+			}
+			for i := range hookCalls {
+				if hookCalls[i] != tt.wantCalls[i] {
+					t.Errorf("hooks called %v, want %v", hookCalls, tt.wantCalls)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestHooks_None tests that a model without hooks is written without a transaction.
+func TestHooks_None(t *testing.T) {
+	mock := newMockSession(t)
+	mock.ExpectExec(`UPDATE "test_models"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := (TestModel{}).Update(TestModel{ID: "1", Version: 1}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestWithHooksTx tests that hooks run in the caller's transaction without opening one, and that a record an
+// update loads but cannot find is reported rather than skipped as a delete's would be.
+func TestWithHooksTx(t *testing.T) {
+	mock := newMockSession(t)
+	hookCalls = nil
+	defer func() { hookCalls = nil }()
+
+	m := &hookedModel{Name: "a"}
+	wrote := false
+	err := withHooksTx(upper, m, hookUpdate, nil, func(sess db.Session) error {
+		wrote = sess == upper
+		return nil
+	})
+	if err != nil || !wrote {
+		t.Fatalf("withHooksTx() error = %v, wrote in the transaction = %v", err, wrote)
+	}
+	if len(hookCalls) != 2 || hookCalls[0] != "BeforeUpdate:a!" || hookCalls[1] != "AfterUpdate:a!" {
+		t.Errorf("hooks called %v", hookCalls)
+	}
+
+	hookCalls = nil
+	missing := func(tx db.Session) error { return db.ErrNoMoreRows }
+	err = withHooksTx(upper, m, hookUpdate, missing, func(db.Session) error {
+		t.Error("expected no write for a missing record")
+		return nil
+	})
+	if !errors.Is(err, db.ErrNoMoreRows) || len(hookCalls) != 0 {
+		t.Errorf("got %v after hooks %v, want db.ErrNoMoreRows and no hooks", err, hookCalls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,215 @@
+package data
+
+import (
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/upper/db/v4"
+)
+
+// KeyType selects how primary keys are generated and stored.
+type KeyType string
+
+const (
+	// KeySerial uses database-assigned auto-incrementing integers.
+	KeySerial KeyType = "serial"
+	// KeyUUID uses time-ordered UUIDs (version 7) generated in Go.
+	KeyUUID KeyType = "uuid"
+	// KeyULID uses ULIDs generated in Go.
+	KeyULID KeyType = "ulid"
+)
+
+// keyType is the key type the models were initialized with, configured via the DATABASE_KEY_TYPE environment variable.
+var keyType = KeySerial
+
+// parseKeyType converts a DATABASE_KEY_TYPE value into a KeyType, defaulting to KeySerial.
+func parseKeyType(s string) (KeyType, error) {
+	switch KeyType(strings.ToLower(s)) {
+	case "", KeySerial:
+		return KeySerial, nil
+	case KeyUUID:
+		return KeyUUID, nil
+	case KeyULID:
+		return KeyULID, nil
+	default:
+		return "", fmt.Errorf("unknown DATABASE_KEY_TYPE: %s", s)
+	}
+}
+
+// CurrentKeyType returns the key type the models were initialized with.
+func CurrentKeyType() KeyType {
+	return keyType
+}
+
+// ID identifies a record. It holds the decimal form of a serial key or the canonical text form of a UUID or ULID,
+// so the same models work with any key type. The zero value means "no ID yet".
+type ID string
+
+// String returns the ID in its text form.
+func (id ID) String() string {
+	return string(id)
+}
+
+// IsZero reports whether the ID is unset.
+func (id ID) IsZero() bool {
+	return id == ""
+}
+
+// Value implements driver.Valuer. Serial keys are sent to the database as integers, other keys as text.
+func (id ID) Value() (driver.Value, error) {
+	if id == "" {
+		return nil, nil
+	}
+	if keyType == KeySerial {
+		n, err := strconv.ParseInt(string(id), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid serial id %q", string(id))
+		}
+		return n, nil
+	}
+	return string(id), nil
+}
+
+// Scan implements sql.Scanner, accepting integer, text and 16-byte binary UUID columns.
+func (id *ID) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*id = ""
+	case int64:
+		*id = ID(strconv.FormatInt(v, 10))
+	case string:
+		*id = ID(v)
+	case []byte:
+		if len(v) == 16 && keyType == KeyUUID {
+			u, err := uuid.FromBytes(v)
+			if err != nil {
+				return err
+			}
+			*id = ID(u.String())
+			return nil
+		}
+		*id = ID(v)
+	default:
+		return fmt.Errorf("cannot scan %T into data.ID", src)
+	}
+	return nil
+}
+
+// Less orders IDs the way the database does: numerically for serial keys and lexically otherwise.
+func (id ID) Less(other ID) bool {
+	if keyType == KeySerial {
+		a, errA := strconv.ParseInt(string(id), 10, 64)
+		b, errB := strconv.ParseInt(string(other), 10, 64)
+		if errA == nil && errB == nil {
+			return a < b
+		}
+	}
+	return id < other
+}
+
+// IntID returns the ID for a serial key.
+func IntID(n int) ID {
+	return ID(strconv.Itoa(n))
+}
+
+// ParseID validates s against the configured key type and returns it in canonical form.
+func ParseID(s string) (ID, error) {
+	switch keyType {
+	case KeyUUID:
+		u, err := uuid.Parse(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid uuid %q", s)
+		}
+		return ID(u.String()), nil
+	case KeyULID:
+		s = strings.ToUpper(s)
+		if !validULID(s) {
+			return "", fmt.Errorf("invalid ulid %q", s)
+		}
+		return ID(s), nil
+	default:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 {
+			return "", fmt.Errorf("invalid id %q", s)
+		}
+		return ID(strconv.FormatInt(n, 10)), nil
+	}
+}
+
+// NewID generates a new primary key for the configured key type.
+// Serial keys are assigned by the database, so for KeySerial it returns the zero ID.
+func NewID() ID {
+	switch keyType {
+	case KeyUUID:
+		u, err := uuid.NewV7()
+		if err != nil {
+			u = uuid.New()
+		}
+		return ID(u.String())
+	case KeyULID:
+		return ID(newULID(time.Now()))
+	default:
+		return ""
+	}
+}
+
+// InsertID converts the db.ID returned by an insert into an ID.
+// Unlike GetInsertID it keeps string keys such as UUIDs and reports unsupported types as an error.
+func InsertID(i db.ID) (ID, error) {
+	switch v := i.(type) {
+	case int:
+		return ID(strconv.Itoa(v)), nil
+	case int64:
+		return ID(strconv.FormatInt(v, 10)), nil
+	case uint64:
+		return ID(strconv.FormatUint(v, 10)), nil
+	case string:
+		return ID(v), nil
+	case []byte:
+		return ID(v), nil
+	case ID:
+		return v, nil
+	default:
+		return "", fmt.Errorf("unsupported insert id type %T", i)
+	}
+}
+
+// crockford is the Crockford base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID: a 48-bit millisecond timestamp followed by 80 random bits, encoded as 26 characters.
+func newULID(t time.Time) string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(t.UnixMilli())<<16)
+	_, _ = rand.Read(b[6:])
+
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	out := make([]byte, 26)
+	// The 128 bits are encoded as 130 bits with two leading zero bits, five bits per character.
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out)
+}
+
+// validULID reports whether s is a canonical, upper-case ULID.
+func validULID(s string) bool {
+	if len(s) != 26 || s[0] > '7' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !strings.ContainsRune(crockford, rune(s[i])) {
+			return false
+		}
+	}
+	return true
+}
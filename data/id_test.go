@@ -0,0 +1,161 @@
+package data
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/upper/db/v4"
+)
+
+// withKeyType switches the package key type for the duration of a test.
+func withKeyType(t *testing.T, kt KeyType) {
+	t.Helper()
+	old := keyType
+	keyType = kt
+	t.Cleanup(func() { keyType = old })
+}
+
+// TestInsertID tests converting insert results into typed IDs without dropping string keys.
+func TestInsertID(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      db.ID
+		want    ID
+		wantErr bool
+	}{
+		{"Int", 1, "1", false},
+		{"Int64", int64(2), "2", false},
+		{"StringUUID", "550e8400-e29b-41d4-a716-446655440000", "550e8400-e29b-41d4-a716-446655440000", false},
+		{"Bytes", []byte("01ARZ3NDEKTSV4RRFFQ69G5FAV"), "01ARZ3NDEKTSV4RRFFQ69G5FAV", false},
+		{"FloatUnsupported", 3.14, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := InsertID(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InsertID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("InsertID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNewID tests that each key type generates IDs that parse back to themselves.
+func TestNewID(t *testing.T) {
+	withKeyType(t, KeySerial)
+	if id := NewID(); !id.IsZero() {
+		t.Errorf("serial NewID() = %q, want zero ID", id)
+	}
+
+	for _, kt := range []KeyType{KeyUUID, KeyULID} {
+		t.Run(string(kt), func(t *testing.T) {
+			withKeyType(t, kt)
+			a, b := NewID(), NewID()
+			if a == b {
+				t.Fatalf("NewID() returned the same id twice: %s", a)
+			}
+			parsed, err := ParseID(string(a))
+			if err != nil || parsed != a {
+				t.Errorf("ParseID(%q) = %q, %v", a, parsed, err)
+			}
+		})
+	}
+}
+
+// TestNewULID tests that ULIDs sort by creation time.
+func TestNewULID(t *testing.T) {
+	earlier := newULID(time.UnixMilli(1_000_000))
+	later := newULID(time.UnixMilli(2_000_000))
+	if !validULID(earlier) || !validULID(later) {
+		t.Fatalf("invalid ulids %q, %q", earlier, later)
+	}
+	if earlier >= later {
+		t.Errorf("expected %q to sort before %q", earlier, later)
+	}
+	if got := newULID(time.UnixMilli(0))[:10]; got != "0000000000" {
+		t.Errorf("zero timestamp encoded as %q", got)
+	}
+}
+
+// TestParseID tests validating IDs against each key type.
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		keyType KeyType
+		in      string
+		want    ID
+		wantErr bool
+	}{
+		{KeySerial, "42", "42", false},
+		{KeySerial, "0", "", true},
+		{KeySerial, "abc", "", true},
+		{KeyUUID, "550E8400-E29B-41D4-A716-446655440000", "550e8400-e29b-41d4-a716-446655440000", false},
+		{KeyUUID, "42", "", true},
+		{KeyULID, "01arz3ndektsv4rrffq69g5fav", "01ARZ3NDEKTSV4RRFFQ69G5FAV", false},
+		{KeyULID, "81ARZ3NDEKTSV4RRFFQ69G5FAV", "", true},
+		{KeyULID, "01ARZ3NDEKTSV4RRFFQ69G5FAU", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.keyType)+"/"+tt.in, func(t *testing.T) {
+			withKeyType(t, tt.keyType)
+			got, err := ParseID(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestID_ValueScan tests the database round trip of IDs for each key type.
+func TestID_ValueScan(t *testing.T) {
+	withKeyType(t, KeySerial)
+	v, err := ID("7").Value()
+	if err != nil || v != driver.Value(int64(7)) {
+		t.Errorf("serial Value() = %v, %v; want int64 7", v, err)
+	}
+	if _, err := ID("seven").Value(); err == nil {
+		t.Error("expected error for non-numeric serial id")
+	}
+	var id ID
+	if err := id.Scan(int64(7)); err != nil || id != "7" {
+		t.Errorf("Scan(int64) = %q, %v", id, err)
+	}
+
+	withKeyType(t, KeyUUID)
+	v, _ = ID("550e8400-e29b-41d4-a716-446655440000").Value()
+	if v != driver.Value("550e8400-e29b-41d4-a716-446655440000") {
+		t.Errorf("uuid Value() = %v", v)
+	}
+	raw := []byte{0x55, 0x0e, 0x84, 0x00, 0xe2, 0x9b, 0x41, 0xd4, 0xa7, 0x16, 0x44, 0x66, 0x55, 0x44, 0x00, 0x00}
+	if err := id.Scan(raw); err != nil || id != "550e8400-e29b-41d4-a716-446655440000" {
+		t.Errorf("Scan(binary uuid) = %q, %v", id, err)
+	}
+	if v, _ := ID("").Value(); v != nil {
+		t.Errorf("zero ID Value() = %v, want nil", v)
+	}
+}
+
+// TestNewWithError_KeyTypeNeedsPostgres tests that uuid and ulid keys are refused for databases without
+// migrations for them, rather than failing later when migrating.
+func TestNewWithError_KeyTypeNeedsPostgres(t *testing.T) {
+	withKeyType(t, KeySerial)
+	for _, dbType := range []string{"mysql", "sqlite"} {
+		t.Setenv("DATABASE_TYPE", dbType)
+		t.Setenv("DATABASE_KEY_TYPE", "uuid")
+		_, err := NewWithError(sqlmockDB())
+		if err == nil || !strings.Contains(err.Error(), "only supported with PostgreSQL") {
+			t.Errorf("NewWithError() with %s and uuid keys error = %v, want it refused", dbType, err)
+		}
+		if keyType != KeySerial {
+			t.Errorf("key type = %s after a refused configuration, want it unchanged", keyType)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -18,6 +19,7 @@ import (
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"github.com/upper/db/v4"
 )
 
 const (
@@ -145,11 +147,20 @@ func setupTables(db *sql.DB) error {
 			first_name VARCHAR(255) NOT NULL,
 			last_name VARCHAR(255) NOT NULL,
 			user_active INTEGER NOT NULL DEFAULT 0,
-			email VARCHAR(255) NOT NULL UNIQUE,
+			email VARCHAR(255) NOT NULL,
 			password VARCHAR(60) NOT NULL,
 			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
-			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			deleted_at TIMESTAMP,
+			version INTEGER NOT NULL DEFAULT 1,
+			search tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('simple', coalesce(first_name, '')), 'A') ||
+				setweight(to_tsvector('simple', coalesce(last_name, '')), 'A') ||
+				setweight(to_tsvector('simple', regexp_replace(coalesce(email, ''), '[@.]', ' ', 'g')), 'B')
+			) STORED
 		);
+		CREATE UNIQUE INDEX users_email_live_idx ON users (email) WHERE deleted_at IS NULL;
+		CREATE INDEX users_search_idx ON users USING GIN (search);
 		CREATE TRIGGER set_timestamp
 			BEFORE UPDATE ON users
 			FOR EACH ROW
@@ -165,12 +176,40 @@ func setupTables(db *sql.DB) error {
 			token_hash BYTEA NOT NULL,
 			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
 			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
-			expiry TIMESTAMP NOT NULL
+			expiry TIMESTAMP NOT NULL,
+			scopes TEXT NOT NULL DEFAULT '',
+			last_used_at TIMESTAMP,
+			last_used_ip VARCHAR(45) NOT NULL DEFAULT '',
+			use_count BIGINT NOT NULL DEFAULT 0,
+			token_type VARCHAR(32) NOT NULL DEFAULT 'api',
+			name VARCHAR(255) NOT NULL DEFAULT ''
 		);
+		CREATE UNIQUE INDEX tokens_token_hash_idx ON tokens (token_hash);
 		CREATE TRIGGER set_timestamp
 			BEFORE UPDATE ON tokens
 			FOR EACH ROW
 			EXECUTE FUNCTION trigger_set_timestamp();
+
+		DROP TABLE IF EXISTS one_time_tokens;
+		CREATE TABLE one_time_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			email VARCHAR(255) NOT NULL,
+			purpose VARCHAR(32) NOT NULL,
+			token_hash BYTEA NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			expiry TIMESTAMP NOT NULL,
+			used_at TIMESTAMP
+		);
+		CREATE UNIQUE INDEX one_time_tokens_token_hash_idx ON one_time_tokens (token_hash);
+
+		DROP TABLE IF EXISTS test_models;
+		CREATE TABLE test_models (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			version INTEGER NOT NULL DEFAULT 1
+		);
 	`)
 	return err
 }
@@ -196,7 +235,7 @@ func TestUser_Insert(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to insert user: %v", err)
 	}
-	if id == 0 {
+	if id.IsZero() {
 		t.Fatal("failed to insert user: id should not be zero")
 	}
 }
@@ -249,6 +288,66 @@ func TestUser_GetAll(t *testing.T) {
 	}
 }
 
+// TestUser_List tests filtering, offset pagination and cursor pagination of users.
+func TestUser_List(t *testing.T) {
+	var ids []ID
+	for i, first := range []string{"Ann", "Bob", "Cid", "Dee", "Eve"} {
+		id, err := models.Users.Insert(User{
+			FirstName: first,
+			LastName:  "Listtest",
+			Active:    i % 2,
+			Email:     fmt.Sprintf("list%d@example.com", i),
+			Password:  "Test@123",
+		})
+		if err != nil {
+			t.Fatalf("failed to insert user: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	defer func() {
+		for _, id := range ids {
+			if err := models.Users.Delete(id); err != nil {
+				t.Errorf("cleanup failed: %v", err)
+			}
+		}
+	}()
+
+	opts := ListOptions{Filter: UserFilter{NamePrefix: "Listtest"}, Sort: "first_name", PerPage: 2}
+	var names []string
+	for {
+		page, err := models.Users.List(opts)
+		if err != nil {
+			t.Fatalf("failed to list users: %v", err)
+		}
+		if page.Total != 5 {
+			t.Fatalf("got total %d, want 5", page.Total)
+		}
+		for _, u := range page.Users {
+			names = append(names, u.FirstName)
+		}
+		if !page.HasNext() {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	if got := fmt.Sprint(names); got != "[Ann Bob Cid Dee Eve]" {
+		t.Errorf("got %s, want [Ann Bob Cid Dee Eve]", got)
+	}
+
+	active := true
+	page, err := models.Users.List(ListOptions{Filter: UserFilter{NamePrefix: "Listtest", Active: &active}, Sort: "-first_name", Page: 2, PerPage: 1})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if page.Total != 2 || len(page.Users) != 1 || page.Users[0].FirstName != "Bob" {
+		t.Errorf("unexpected page: total %d, users %v", page.Total, page.Users)
+	}
+
+	if _, err := models.Users.List(ListOptions{Sort: "password"}); !errors.Is(err, ErrInvalidSort) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidSort)
+	}
+}
+
 // TestUser_GetByEmail tests retrieving a user by their email.
 func TestUser_GetByEmail(t *testing.T) {
 	user := User{
@@ -292,7 +391,7 @@ func TestUser_Get(t *testing.T) {
 		t.Fatalf("failed to get user by id: %v", err)
 	}
 	if u.ID != id {
-		t.Fatalf("expected id %d, got %d", id, u.ID)
+		t.Fatalf("expected id %s, got %s", id, u.ID)
 	}
 	if err := models.Users.Delete(id); err != nil {
 		t.Errorf("cleanup failed: %v", err)
@@ -333,6 +432,281 @@ func TestUser_Update(t *testing.T) {
 	}
 }
 
+// TestUser_Update_Stale tests that an update based on an outdated version is rejected.
+func TestUser_Update_Stale(t *testing.T) {
+	id, err := models.Users.Insert(User{
+		FirstName: "Stale",
+		LastName:  "User",
+		Active:    1,
+		Email:     "stale@example.com",
+		Password:  "Test@123",
+	})
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	first, err := models.Users.Get(id)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	second := *first
+
+	first.LastName = "First"
+	if err := models.Users.Update(*first); err != nil {
+		t.Fatalf("first update failed: %v", err)
+	}
+	second.LastName = "Second"
+	if err := models.Users.Update(second); !errors.Is(err, ErrStaleObject) {
+		t.Fatalf("expected ErrStaleObject, got %v", err)
+	}
+
+	u, err := models.Users.Get(id)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if u.LastName != "First" || u.Version != first.Version+1 {
+		t.Errorf("got last name %q version %d, want %q version %d", u.LastName, u.Version, "First", first.Version+1)
+	}
+
+	u.ID = IntID(999999)
+	if err := models.Users.Update(*u); !errors.Is(err, db.ErrNoMoreRows) {
+		t.Errorf("expected ErrNoMoreRows for a missing user, got %v", err)
+	}
+	if err := models.Users.Delete(id); err != nil {
+		t.Errorf("cleanup failed: %v", err)
+	}
+}
+
+// TestRepository tests the generic repository through the TestModel that embeds it.
+func TestRepository(t *testing.T) {
+	var repo TestModel
+
+	id, err := repo.Insert(TestModel{})
+	if err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	m, err := repo.Get(id)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if m.Version != 1 || m.CreatedAt.IsZero() {
+		t.Errorf("expected version 1 and a creation time, got %+v", m)
+	}
+
+	if n, err := repo.Count(db.Cond{"id": id}); err != nil || n != 1 {
+		t.Errorf("Count() = %d, %v; want 1", n, err)
+	}
+	if ok, err := repo.Exists(db.Cond{"id": id}); err != nil || !ok {
+		t.Errorf("Exists() = %v, %v; want true", ok, err)
+	}
+
+	if err := repo.Update(*m); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if err := repo.Update(*m); !errors.Is(err, ErrStaleObject) {
+		t.Errorf("expected ErrStaleObject, got %v", err)
+	}
+	all, err := repo.GetAll(db.Cond{"version": 2})
+	if err != nil || len(all) != 1 || all[0].ID != id {
+		t.Errorf("GetAll(version 2) = %v, %v", all, err)
+	}
+
+	if err := repo.Delete(id); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, err := repo.Get(id); !errors.Is(err, db.ErrNoMoreRows) {
+		t.Errorf("expected ErrNoMoreRows after delete, got %v", err)
+	}
+}
+
+// TestUser_Cache tests that cached users and tokens are invalidated by writes.
+func TestUser_Cache(t *testing.T) {
+	SetCache(NewLRUCache(100), 0, 0)
+	defer SetCache(nil, 0, 0)
+
+	id, err := models.Users.Insert(User{
+		FirstName: "Cached",
+		LastName:  "User",
+		Active:    1,
+		Email:     "cached@example.com",
+		Password:  "Test@123",
+	})
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	u, err := models.Users.Get(id)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	u.LastName = "Changed"
+	if err := models.Users.Update(*u); err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+	if u, _ = models.Users.Get(id); u.LastName != "Changed" {
+		t.Errorf("expected the cached user to be invalidated, got last name %q", u.LastName)
+	}
+
+	token, err := models.Tokens.GenerateToken(id, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	if err := models.Tokens.Insert(*token, *u); err != nil {
+		t.Fatalf("failed to insert token: %v", err)
+	}
+	if _, err := models.Tokens.GetUserForToken(token.plainText); err != nil {
+		t.Fatalf("failed to get user for token: %v", err)
+	}
+	if err := models.Tokens.DeleteByToken(token.plainText); err != nil {
+		t.Fatalf("failed to delete token: %v", err)
+	}
+	if _, err := models.Tokens.GetByToken(token.plainText); err == nil {
+		t.Error("expected the cached token to be invalidated")
+	}
+
+	if err := models.Users.Delete(id); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	if _, err := models.Users.Get(id); err == nil {
+		t.Error("expected the cached user to be invalidated on delete")
+	}
+}
+
+// TestUser_Search tests full-text search across names and email.
+func TestUser_Search(t *testing.T) {
+	var ids []ID
+	for _, u := range []User{
+		{FirstName: "Searchable", LastName: "Person", Email: "findme@search.test"},
+		{FirstName: "Other", LastName: "Searchington", Email: "other@search.test"},
+	} {
+		u.Active = 1
+		u.Password = "Test@123"
+		id, err := models.Users.Insert(u)
+		if err != nil {
+			t.Fatalf("failed to insert user: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	defer func() {
+		for _, id := range ids {
+			_ = models.Users.Delete(id)
+		}
+	}()
+
+	page, err := models.Users.Search("search", 1)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if page.Total != 2 || len(page.Users) != 2 {
+		t.Fatalf("got %d results, want 2", page.Total)
+	}
+
+	page, err = models.Users.Search("findme", 1)
+	if err != nil || page.Total != 1 || page.Users[0].ID != ids[0] {
+		t.Errorf("expected an email match for findme, got %+v, %v", page, err)
+	}
+
+	if err := models.Users.Delete(ids[0]); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	page, err = models.Users.Search("findme", 1)
+	if err != nil || page.Total != 0 {
+		t.Errorf("expected soft-deleted users to be excluded, got %d results, %v", page.Total, err)
+	}
+}
+
+// TestUser_Import tests that a bulk import inserts new users and upserts live users by email in the database.
+func TestUser_Import(t *testing.T) {
+	id, err := models.Users.Insert(User{FirstName: "Existing", LastName: "Importee", Email: "existing@import.test", Active: 0, Password: "Test@123"})
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	defer func() { _ = models.Users.Delete(id) }()
+
+	input := "first_name,last_name,email,active,password\n" +
+		"Renamed,Importee,existing@import.test,true,New@1234\n" +
+		"Fresh,Importee,fresh@import.test,true,Test@123\n" +
+		"Fresh,Importee,fresh@import.test,false,Test@123\n" +
+		"Broken,Importee,broken@import.test,true,\n"
+	report, err := models.Users.Import(context.Background(), bytes.NewBufferString(input), ImportOptions{Format: ImportCSV, BatchSize: 2})
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if report.Rows != 4 || report.Imported != 3 || report.Failed != 1 || report.Errors[0].Row != 4 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	existing, err := models.Users.Get(id)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if existing.FirstName != "Renamed" || existing.Active != 1 || existing.Version != 2 {
+		t.Errorf("existing user not updated: %+v", existing)
+	}
+	if ok, _ := existing.PasswordMatches("New@1234"); !ok {
+		t.Error("expected the imported password to replace the old one")
+	}
+
+	fresh, err := models.Users.GetByEmail("fresh@import.test")
+	if err != nil {
+		t.Fatalf("imported user not found: %v", err)
+	}
+	defer func() { _ = models.Users.Delete(fresh.ID) }()
+	if fresh.Active != 0 || fresh.Version != 2 {
+		t.Errorf("expected the later duplicate row to win, got %+v", fresh)
+	}
+}
+
+// TestUser_Export tests that an export streams the selected columns of matching users from the database.
+func TestUser_Export(t *testing.T) {
+	id, err := models.Users.Insert(User{FirstName: "Exported", LastName: "Person", Email: "exported@export.test", Active: 1, Password: "Test@123"})
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	defer func() { _ = models.Users.Delete(id) }()
+
+	var buf bytes.Buffer
+	opts := ExportOptions{Format: ExportCSV, Columns: []string{"id", "email"}, Filter: UserFilter{Email: "exported@export.test"}}
+	n, err := models.Users.Export(context.Background(), &buf, opts)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	want := fmt.Sprintf("id,email\n%s,exported@export.test\n", id)
+	if n != 1 || buf.String() != want {
+		t.Errorf("got %d users:\n%s\nwant:\n%s", n, buf.String(), want)
+	}
+}
+
+// TestFixtures_Postgres tests that fixtures load into and are removed from the database.
+func TestFixtures_Postgres(t *testing.T) {
+	f, err := ReadFixtureFiles("testdata/fixtures.yml")
+	if err != nil {
+		t.Fatalf("reading fixtures: %v", err)
+	}
+	t.Run("Load", func(t *testing.T) {
+		if err := f.Load(models); err != nil {
+			t.Fatalf("loading fixtures: %v", err)
+		}
+
+		user, err := models.Tokens.GetUserForToken(f.PlainText("jane-api"))
+		if err != nil || user.ID != f.UserID("jane") {
+			t.Errorf("jane-api authenticates %v, %v; want jane", user.ID, err)
+		}
+		if valid, _ := models.Tokens.ValidToken(f.PlainText("jane-expired")); valid {
+			t.Error("expected the expired fixture token to be invalid")
+		}
+		if _, err := models.Users.Get(f.UserID("gone")); !errors.Is(err, db.ErrNoMoreRows) {
+			t.Errorf("expected the soft-deleted fixture user to be hidden, got %v", err)
+		}
+	})
+	if err := f.Unload(models); err != nil {
+		t.Fatalf("unloading fixtures: %v", err)
+	}
+
+	var n int
+	if err := testDB.QueryRow("SELECT COUNT(*) FROM users WHERE email LIKE '%@fixtures.test'").Scan(&n); err != nil || n != 0 {
+		t.Errorf("got %d fixture users after the test finished, %v; want 0", n, err)
+	}
+}
+
 // TestUser_PasswordMatches tests the password matching functionality.
 func TestUser_PasswordMatches(t *testing.T) {
 	user := User{
@@ -397,7 +771,7 @@ func TestUser_ResetPassword(t *testing.T) {
 	if !matches {
 		t.Fatal("expected new password to match")
 	}
-	err = models.Users.ResetPassword(999, "New@123")
+	err = models.Users.ResetPassword(IntID(999), "New@123")
 	if err == nil {
 		t.Fatal("expected error for non-existent user")
 	}
@@ -429,6 +803,70 @@ func TestUser_Delete(t *testing.T) {
 	}
 }
 
+// TestUser_SoftDelete tests that deleted users are hidden, keep their tokens but cannot use them,
+// and can be restored or purged.
+func TestUser_SoftDelete(t *testing.T) {
+	user := User{FirstName: "Soft", LastName: "Delete", Active: 1, Email: "softdelete@example.com", Password: "Test@123"}
+	id, err := models.Users.Insert(user)
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	user.ID = id
+
+	token, err := models.Tokens.GenerateToken(id, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	if err := models.Tokens.Insert(*token, user); err != nil {
+		t.Fatalf("failed to insert token: %v", err)
+	}
+
+	if err := models.Users.Delete(id); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	if _, err := models.Users.GetByEmail(user.Email); err == nil {
+		t.Error("expected soft-deleted user to be hidden from GetByEmail")
+	}
+	if ok, _ := models.Tokens.ValidToken(token.plainText); ok {
+		t.Error("expected token of a soft-deleted user to be invalid")
+	}
+	if tokens, _ := models.Tokens.GetTokensForUser(id); len(tokens) != 1 {
+		t.Errorf("expected soft delete to keep the token, got %d tokens", len(tokens))
+	}
+
+	// The email is free again while the old row is soft-deleted.
+	otherID, err := models.Users.Insert(user)
+	if err != nil {
+		t.Fatalf("failed to reuse email of a soft-deleted user: %v", err)
+	}
+	if err := models.Users.Restore(id); err == nil {
+		t.Error("expected restore to fail while the email is taken")
+	}
+	_ = models.Users.Delete(otherID)
+
+	if err := models.Users.Restore(id); err != nil {
+		t.Fatalf("failed to restore user: %v", err)
+	}
+	if ok, err := models.Tokens.ValidToken(token.plainText); !ok {
+		t.Errorf("expected token to be valid again after restore: %v", err)
+	}
+
+	_ = models.Users.Delete(id)
+	n, err := models.Users.PurgeDeleted(0)
+	if err != nil {
+		t.Fatalf("failed to purge users: %v", err)
+	}
+	if n < 2 {
+		t.Errorf("purged %d users, want at least 2", n)
+	}
+	if err := models.Users.Restore(id); err == nil {
+		t.Error("expected restore of a purged user to fail")
+	}
+	if tokens, _ := models.Tokens.GetTokensForUser(id); len(tokens) != 0 {
+		t.Errorf("expected purge to remove the token, got %d tokens", len(tokens))
+	}
+}
+
 // TestToken_Table checks if the Token model returns the correct table name.
 func TestToken_Table(t *testing.T) {
 	s := models.Tokens.Table()
@@ -455,10 +893,13 @@ func TestToken_GenerateToken(t *testing.T) {
 		t.Fatalf("failed to generate token: %v", err)
 	}
 	if token.UserID != userID {
-		t.Errorf("got user ID %d, want %d", token.UserID, userID)
+		t.Errorf("got user ID %s, want %s", token.UserID, userID)
+	}
+	if want := len(TokenPrefix) + TokenLength + 1 + tokenChecksumLength; len(token.plainText) != want {
+		t.Fatalf("expected token length %d, got %d", want, len(token.plainText))
 	}
-	if len(token.plainText) != TokenLength {
-		t.Fatalf("expected token length %d, got %d", TokenLength, len(token.plainText))
+	if err := checkTokenFormat(token.plainText); err != nil {
+		t.Fatalf("generated token %q fails its format check: %v", token.plainText, err)
 	}
 	if err := models.Users.Delete(userID); err != nil {
 		t.Errorf("cleanup failed: %v", err)
@@ -503,7 +944,7 @@ func TestToken_Insert(t *testing.T) {
 		t.Errorf("retrieved hash doesn’t match expected hash\nretrieved: %x\nexpected: %x", tok.Hash, expectedHash[:])
 	}
 	if tok.UserID != id {
-		t.Fatalf("expected user ID %d, got %d", id, tok.UserID)
+		t.Fatalf("expected user ID %s, got %s", id, tok.UserID)
 	}
 
 	if err := models.Users.Delete(id); err != nil {
@@ -539,7 +980,7 @@ func TestToken_GetUserForToken(t *testing.T) {
 		t.Fatalf("failed to get user for token: %v", err)
 	}
 	if u.ID != id {
-		t.Fatalf("expected user ID %d, got %d", id, u.ID)
+		t.Fatalf("expected user ID %s, got %s", id, u.ID)
 	}
 	_, err = models.Tokens.GetUserForToken("invalid")
 	if err == nil {
@@ -580,7 +1021,7 @@ func TestToken_GetTokensForUser(t *testing.T) {
 	if len(tokens) != 1 {
 		t.Fatalf("expected 1 token, got %d", len(tokens))
 	}
-	tokens, err = models.Tokens.GetTokensForUser(999)
+	tokens, err = models.Tokens.GetTokensForUser(IntID(999))
 	if err != nil {
 		t.Fatalf("unexpected error for non-existent user: %v", err)
 	}
@@ -620,7 +1061,7 @@ func TestToken_GetByToken(t *testing.T) {
 		t.Fatalf("failed to get token: %v", err)
 	}
 	if tok.UserID != id {
-		t.Fatalf("expected user ID %d, got %d", id, tok.UserID)
+		t.Fatalf("expected user ID %s, got %s", id, tok.UserID)
 	}
 	_, err = models.Tokens.GetByToken("invalid")
 	if err == nil {
@@ -663,9 +1104,9 @@ func TestToken_Get(t *testing.T) {
 		t.Fatalf("failed to get token by ID: %v", err)
 	}
 	if tokByID.ID != tok.ID {
-		t.Fatalf("expected token ID %d, got %d", tok.ID, tokByID.ID)
+		t.Fatalf("expected token ID %s, got %s", tok.ID, tokByID.ID)
 	}
-	_, err = models.Tokens.Get(999)
+	_, err = models.Tokens.Get(IntID(999))
 	if err == nil {
 		t.Fatal("expected error for non-existent token ID")
 	}
@@ -777,8 +1218,9 @@ func TestToken_ValidToken(t *testing.T) {
 //   - Valid token: Successfully authenticates a non-expired token and returns the associated user.
 //   - No Authorization header: Fails with "no authorization header received".
 //   - Invalid header format: Fails with "invalid authorization header format" for missing "Bearer" or malformed headers.
-//   - Invalid token length: Fails with "invalid token length" for tokens not matching TokenLength.
-//   - Non-existent token: Fails with "no matching token found" for valid-length but unmatched tokens.
+//   - Malformed token: Fails with "malformed token" for tokens that are not in the dvf_ format.
+//   - Invalid checksum: Fails with "invalid token checksum" for tokens whose checksum does not match.
+//   - Non-existent token: Fails with "no matching token found" for well-formed but unmatched tokens.
 //   - Expired token: Fails with "token has expired" for tokens past their expiry.
 //
 // The test creates separate users for valid and expired tokens to avoid overwriting, inserts tokens into the database,
@@ -797,7 +1239,7 @@ func TestToken_AuthenticateToken(t *testing.T) {
 		t.Fatalf("failed to insert user: %v", err)
 	}
 	user.ID = userID
-	t.Logf("Inserted user ID: %d", userID)
+	t.Logf("Inserted user ID: %s", userID)
 
 	// Generate and insert a valid token
 	validToken, err := models.Tokens.GenerateToken(userID, 24*time.Hour)
@@ -818,14 +1260,14 @@ func TestToken_AuthenticateToken(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to verify token insertion: %v", err)
 	}
-	t.Logf("Verified token ID: %d, Hash: %x", tok.ID, tok.Hash)
+	t.Logf("Verified token ID: %s, Hash: %x", tok.ID, tok.Hash)
 
 	// Check all tokens for user
 	tokens, err := models.Tokens.GetTokensForUser(userID)
 	if err != nil {
 		t.Fatalf("failed to get tokens for user: %v", err)
 	}
-	t.Logf("Tokens for user %d after valid insert: %d", userID, len(tokens))
+	t.Logf("Tokens for user %s after valid insert: %d", userID, len(tokens))
 
 	// Generate and insert an expired token (separate user to avoid overwrite)
 	expiredUser := User{
@@ -840,7 +1282,7 @@ func TestToken_AuthenticateToken(t *testing.T) {
 		t.Fatalf("failed to insert expired user: %v", err)
 	}
 	expiredUser.ID = expiredUserID
-	t.Logf("Inserted expired user ID: %d", expiredUserID)
+	t.Logf("Inserted expired user ID: %s", expiredUserID)
 
 	expiredToken, err := models.Tokens.GenerateToken(expiredUserID, -24*time.Hour)
 	if err != nil {
@@ -859,55 +1301,67 @@ func TestToken_AuthenticateToken(t *testing.T) {
 	if err != nil {
 		t.Fatalf("valid token no longer found after expired insert: %v", err)
 	}
-	t.Logf("Re-verified valid token ID: %d, Hash: %x", tok.ID, tok.Hash)
+	t.Logf("Re-verified valid token ID: %s, Hash: %x", tok.ID, tok.Hash)
+
+	unknownToken, err := models.Tokens.GenerateToken(userID, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to generate unknown token: %v", err)
+	}
+	unknownPlainText := unknownToken.plainText
 
 	tests := []struct {
 		name       string
 		authHeader string
 		wantErr    string
-		wantUserID int
+		wantUserID ID
 	}{
 		{
 			name:       "Valid token",
 			authHeader: "Bearer " + validPlainText,
 			wantErr:    "",
-			wantUserID: int(userID),
+			wantUserID: userID,
 		},
 		{
 			name:       "No Authorization header",
 			authHeader: "",
 			wantErr:    "no authorization header received",
-			wantUserID: 0,
+			wantUserID: "",
 		},
 		{
 			name:       "Invalid header format - no Bearer",
 			authHeader: validPlainText,
 			wantErr:    "invalid authorization header format",
-			wantUserID: 0,
+			wantUserID: "",
 		},
 		{
 			name:       "Invalid header format - malformed",
 			authHeader: "Bearer",
 			wantErr:    "invalid authorization header format",
-			wantUserID: 0,
+			wantUserID: "",
+		},
+		{
+			name:       "Malformed token",
+			authHeader: "Bearer dvf_short",
+			wantErr:    "malformed token",
+			wantUserID: "",
 		},
 		{
-			name:       "Invalid token length",
-			authHeader: "Bearer short",
-			wantErr:    "invalid token length",
-			wantUserID: 0,
+			name:       "Invalid checksum",
+			authHeader: "Bearer " + validPlainText[:len(validPlainText)-tokenChecksumLength] + "000000",
+			wantErr:    "invalid token checksum",
+			wantUserID: "",
 		},
 		{
 			name:       "Non-existent token",
-			authHeader: "Bearer abcdefghijklmnopqrstuvwxyz",
+			authHeader: "Bearer " + unknownPlainText,
 			wantErr:    "no matching token found",
-			wantUserID: 0,
+			wantUserID: "",
 		},
 		{
 			name:       "Expired token",
 			authHeader: "Bearer " + expiredPlainText,
 			wantErr:    "token has expired",
-			wantUserID: 0,
+			wantUserID: "",
 		},
 	}
 
@@ -940,7 +1394,7 @@ func TestToken_AuthenticateToken(t *testing.T) {
 					t.Fatal("expected non-nil user, got nil")
 				}
 				if user.ID != tt.wantUserID {
-					t.Fatalf("expected user ID %d, got %d", tt.wantUserID, user.ID)
+					t.Fatalf("expected user ID %s, got %s", tt.wantUserID, user.ID)
 				}
 			}
 		})
@@ -948,10 +1402,10 @@ func TestToken_AuthenticateToken(t *testing.T) {
 
 	// Cleanup
 	if err := models.Users.Delete(userID); err != nil {
-		t.Errorf("cleanup failed for user %d: %v", userID, err)
+		t.Errorf("cleanup failed for user %s: %v", userID, err)
 	}
 	if err := models.Users.Delete(expiredUserID); err != nil {
-		t.Errorf("cleanup failed for expired user %d: %v", expiredUserID, err)
+		t.Errorf("cleanup failed for expired user %s: %v", expiredUserID, err)
 	}
 }
 
@@ -976,7 +1430,7 @@ func TestToken_Delete(t *testing.T) {
 		t.Fatalf("failed to insert user: %v", err)
 	}
 	user.ID = userID
-	t.Logf("Inserted user ID: %d", userID)
+	t.Logf("Inserted user ID: %s", userID)
 
 	// Generate and insert a token
 	token, err := models.Tokens.GenerateToken(userID, 24*time.Hour)
@@ -995,11 +1449,11 @@ func TestToken_Delete(t *testing.T) {
 		t.Fatalf("failed to verify token insertion: %v", err)
 	}
 	tokenID := tok.ID
-	t.Logf("Token ID: %d", tokenID)
+	t.Logf("Token ID: %s", tokenID)
 
 	tests := []struct {
 		name    string
-		id      int
+		id      ID
 		wantErr bool
 	}{
 		{
@@ -1009,7 +1463,7 @@ func TestToken_Delete(t *testing.T) {
 		},
 		{
 			name:    "Delete non-existent token",
-			id:      999,
+			id:      IntID(999),
 			wantErr: false,
 		},
 	}
@@ -1017,7 +1471,7 @@ func TestToken_Delete(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			err := models.Tokens.Delete(tt.id)
-			t.Logf("Deleting token ID %d, result: %v", tt.id, err)
+			t.Logf("Deleting token ID %s, result: %v", tt.id, err)
 
 			if tt.wantErr {
 				if err == nil {
@@ -1033,20 +1487,80 @@ func TestToken_Delete(t *testing.T) {
 			if tt.name == "Delete existing token" {
 				_, err := models.Tokens.Get(tt.id)
 				if err == nil {
-					t.Fatalf("expected token ID %d to be deleted, but it was still found", tt.id)
+					t.Fatalf("expected token ID %s to be deleted, but it was still found", tt.id)
 				}
 				// Check if GetByToken also fails
 				_, err = models.Tokens.GetByToken(token.plainText)
 				if err == nil {
 					t.Fatalf("expected token with plaintext %s to be deleted, but it was still found", token.plainText)
 				}
-				t.Logf("Confirmed token ID %d deleted", tt.id)
+				t.Logf("Confirmed token ID %s deleted", tt.id)
 			}
 		})
 	}
 
 	// Cleanup
 	if err := models.Users.Delete(userID); err != nil {
-		t.Errorf("cleanup failed for user %d: %v", userID, err)
+		t.Errorf("cleanup failed for user %s: %v", userID, err)
+	}
+}
+
+// BenchmarkToken_AuthenticateToken measures resolving a bearer token to its user under concurrent load,
+// comparing the single indexed join with the token lookup followed by the user lookup it replaced. Both sides
+// only query, without a cache, so the header parsing, expiry and usage tracking around them are not measured.
+// Run it with
+//
+//	go test -tags integration -run '^$' -bench AuthenticateToken -cpu 1,4,16 ./data
+func BenchmarkToken_AuthenticateToken(b *testing.B) {
+	oldCache := cache
+	SetCache(nil, 0, 0)
+	b.Cleanup(func() { cache = oldCache })
+
+	user := User{FirstName: "Bench", LastName: "Mark", Active: 1, Email: "bench@example.com", Password: "Bench@123"}
+	userID, err := models.Users.Insert(user)
+	if err != nil {
+		b.Fatalf("failed to insert user: %v", err)
+	}
+	user.ID = userID
+	b.Cleanup(func() { _ = models.Users.Delete(userID) })
+	token, err := models.Tokens.GenerateToken(userID, time.Hour)
+	if err != nil {
+		b.Fatalf("failed to generate token: %v", err)
 	}
+	if err := models.Tokens.Insert(*token, user); err != nil {
+		b.Fatalf("failed to insert token: %v", err)
+	}
+	// More tokens, so the lookup searches a table of realistic size.
+	for i := 0; i < 1000; i++ {
+		other, _ := models.Tokens.GenerateToken(userID, time.Hour)
+		if _, err := upper.Collection("tokens").Insert(other); err != nil {
+			b.Fatalf("failed to insert token: %v", err)
+		}
+	}
+
+	b.Run("join", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, _, err := (&Token{}).authenticate(token.plainText); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+	b.Run("separate queries", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				tok, err := (&Token{}).GetByToken(token.plainText)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				if _, err := (&User{}).Get(tok.UserID); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
 }
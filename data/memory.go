@@ -0,0 +1,758 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/upper/db/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// memoryStore holds the users and tokens shared by the in-memory user and token stores.
+// All access is guarded by mu, so the stores are safe for concurrent use.
+type memoryStore struct {
+	mu                 sync.RWMutex
+	users              map[ID]User
+	tokens             map[ID]Token
+	oneTimeTokens      map[ID]OneTimeToken
+	nextUserID         int
+	nextTokenID        int
+	nextOneTimeTokenID int
+}
+
+// MemoryUsers is a thread-safe, in-memory UserStore intended for tests and demos.
+type MemoryUsers struct {
+	store *memoryStore
+}
+
+// MemoryTokens is a thread-safe, in-memory TokenStore intended for tests and demos.
+type MemoryTokens struct {
+	store *memoryStore
+}
+
+// MemoryOneTimeTokens is a thread-safe, in-memory OneTimeTokenStore intended for tests and demos.
+type MemoryOneTimeTokens struct {
+	store *memoryStore
+}
+
+// Compile-time checks that the in-memory stores satisfy the store interfaces.
+var (
+	_ UserStore         = (*MemoryUsers)(nil)
+	_ TokenStore        = (*MemoryTokens)(nil)
+	_ OneTimeTokenStore = (*MemoryOneTimeTokens)(nil)
+)
+
+// NewMemory returns Models backed by an empty in-memory store.
+// The user and token stores share the same data, so purging a user also removes their tokens.
+func NewMemory() Models {
+	store := &memoryStore{
+		users:         make(map[ID]User),
+		tokens:        make(map[ID]Token),
+		oneTimeTokens: make(map[ID]OneTimeToken),
+	}
+	tokens := &MemoryTokens{store: store}
+	return Models{
+		Users:         &MemoryUsers{store: store},
+		Tokens:        tokens,
+		OneTimeTokens: &MemoryOneTimeTokens{store: store},
+		Issuer:        OpaqueIssuer{Tokens: tokens},
+	}
+}
+
+// nextID returns a new primary key, counting up from *counter for serial keys
+// and generating one for UUID and ULID keys. The caller must hold the write lock.
+func (s *memoryStore) nextID(counter *int) ID {
+	if keyType == KeySerial {
+		*counter++
+		return IntID(*counter)
+	}
+	return NewID()
+}
+
+// latestToken returns the most recent non-expired token for a user, or a zero Token if there is none.
+// The caller must hold at least a read lock.
+func (s *memoryStore) latestToken(userID ID) Token {
+	var latest Token
+	now := time.Now()
+	for _, token := range s.tokens {
+		if token.UserID != userID || !token.Expires.After(now) {
+			continue
+		}
+		if latest.ID.IsZero() || token.CreatedAt.After(latest.CreatedAt) {
+			latest = token
+		}
+	}
+	return latest
+}
+
+// liveUser returns the user with the given ID unless it does not exist or has been soft-deleted.
+// The caller must hold at least a read lock.
+func (s *memoryStore) liveUser(id ID) (User, bool) {
+	user, ok := s.users[id]
+	if !ok || user.IsDeleted() {
+		return User{}, false
+	}
+	return user, true
+}
+
+// emailTaken reports whether a live user other than exceptID already uses the email.
+// The caller must hold at least a read lock.
+func (s *memoryStore) emailTaken(email string, exceptID ID) bool {
+	for id, existing := range s.users {
+		if id != exceptID && !existing.IsDeleted() && existing.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenByHash returns the token with the given hash. The caller must hold at least a read lock.
+func (s *memoryStore) tokenByHash(hash []byte) (Token, bool) {
+	for _, token := range s.tokens {
+		if string(token.Hash) == string(hash) {
+			return token, true
+		}
+	}
+	return Token{}, false
+}
+
+// Table returns the database table name for the User model.
+func (m *MemoryUsers) Table() string {
+	return "users"
+}
+
+// GetAll retrieves all users that have not been soft-deleted, ordered by last name.
+func (m *MemoryUsers) GetAll() ([]*User, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	all := make([]*User, 0, len(m.store.users))
+	for _, user := range m.store.users {
+		if user.IsDeleted() {
+			continue
+		}
+		u := user
+		all = append(all, &u)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].LastName == all[j].LastName {
+			return all[i].ID.Less(all[j].ID)
+		}
+		return all[i].LastName < all[j].LastName
+	})
+	return all, nil
+}
+
+// List retrieves one page of users matching the options' filter, in the requested order.
+// It follows the same filtering, ordering and cursor rules as the SQL-backed User.List.
+func (m *MemoryUsers) List(opts ListOptions) (*UserPage, error) {
+	column, desc, err := opts.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	var matched []*User
+	for _, user := range m.store.users {
+		u := user
+		if opts.Filter.matches(&u) {
+			matched = append(matched, &u)
+		}
+	}
+	m.store.mu.RUnlock()
+
+	// less orders a before b by the sort column, breaking ties by ID.
+	less := func(a interface{}, aID ID, b interface{}, bID ID) bool {
+		c := compareSortValues(a, b)
+		if c == 0 {
+			c = compareSortValues(aID, bID)
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return less(userSortValue(matched[i], column), matched[i].ID, userSortValue(matched[j], column), matched[j].ID)
+	})
+
+	rows := matched
+	if opts.Cursor != "" {
+		value, id, err := decodeCursor(opts.Cursor, opts.Sort)
+		if err != nil {
+			return nil, err
+		}
+		start := sort.Search(len(matched), func(i int) bool {
+			return less(value, id, userSortValue(matched[i], column), matched[i].ID)
+		})
+		rows = matched[start:]
+	} else if offset := (opts.Page - 1) * opts.PerPage; offset < len(matched) {
+		rows = matched[offset:]
+	} else {
+		rows = nil
+	}
+	if len(rows) > opts.PerPage+1 {
+		rows = rows[:opts.PerPage+1]
+	}
+
+	return newUserPage(rows, len(matched), opts), nil
+}
+
+// Search finds live users whose first name, last name or email match every word of the query, best matches
+// first, and returns the given page of results. Words are matched as prefixes, like the SQL full-text search.
+func (m *MemoryUsers) Search(query string, page int) (*UserPage, error) {
+	opts := ListOptions{Page: page}
+	if _, _, err := opts.normalize(); err != nil {
+		return nil, err
+	}
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return newSearchPage(nil, 0, opts), nil
+	}
+
+	m.store.mu.RLock()
+	var matched []*User
+	scores := make(map[ID]int)
+	for _, user := range m.store.users {
+		u := user
+		if u.IsDeleted() {
+			continue
+		}
+		if score := searchScore(&u, terms); score > 0 {
+			matched = append(matched, &u)
+			scores[u.ID] = score
+		}
+	}
+	m.store.mu.RUnlock()
+
+	rankSearch(matched, scores)
+	offset := (opts.Page - 1) * opts.PerPage
+	if offset >= len(matched) {
+		return newSearchPage(nil, len(matched), opts), nil
+	}
+	end := min(offset+opts.PerPage, len(matched))
+	return newSearchPage(matched[offset:end], len(matched), opts), nil
+}
+
+// GetByEmail retrieves a user by their email address, ignoring soft-deleted users.
+// It includes the most recent non-expired token, if available.
+func (m *MemoryUsers) GetByEmail(email string) (*User, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	for _, user := range m.store.users {
+		if user.Email == email && !user.IsDeleted() {
+			user.Token = m.store.latestToken(user.ID)
+			return &user, nil
+		}
+	}
+	return nil, db.ErrNoMoreRows
+}
+
+// Get retrieves a user by their ID, ignoring soft-deleted users.
+// It includes the most recent non-expired token, if available.
+func (m *MemoryUsers) Get(id ID) (*User, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	user, ok := m.store.liveUser(id)
+	if !ok {
+		return nil, db.ErrNoMoreRows
+	}
+	user.Token = m.store.latestToken(user.ID)
+	return &user, nil
+}
+
+// Update modifies an existing user; soft-deleted users are left untouched.
+// It returns ErrStaleObject unless user.Version matches the stored version, which it then increments.
+// It updates the UpdatedAt timestamp to the current time, and leaves the password alone.
+func (m *MemoryUsers) Update(user User) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	existing, ok := m.store.liveUser(user.ID)
+	if !ok {
+		return db.ErrNoMoreRows
+	}
+	if existing.Version != user.Version {
+		return ErrStaleObject
+	}
+	if m.store.emailTaken(user.Email, user.ID) {
+		return fmt.Errorf("%w: %s", ErrDuplicateEmail, user.Email)
+	}
+
+	user.Password = existing.Password
+	user.DeletedAt = nil
+	user.Version++
+	user.UpdatedAt = time.Now()
+	user.Token = Token{}
+	m.store.users[user.ID] = user
+	return nil
+}
+
+// Delete soft-deletes a user by their ID. The user's tokens are kept but no longer authenticate.
+func (m *MemoryUsers) Delete(id ID) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if user, ok := m.store.liveUser(id); ok {
+		now := time.Now()
+		user.DeletedAt = &now
+		user.Version++
+		m.store.users[id] = user
+	}
+	return nil
+}
+
+// Restore undoes a soft delete.
+// It returns db.ErrNoMoreRows if there is no soft-deleted user with the given ID.
+func (m *MemoryUsers) Restore(id ID) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	user, ok := m.store.users[id]
+	if !ok || !user.IsDeleted() {
+		return db.ErrNoMoreRows
+	}
+	if m.store.emailTaken(user.Email, id) {
+		return fmt.Errorf("%w: %s", ErrDuplicateEmail, user.Email)
+	}
+	user.DeletedAt = nil
+	user.Version++
+	m.store.users[id] = user
+	return nil
+}
+
+// PurgeDeleted permanently removes users that were soft-deleted more than olderThan ago,
+// along with their tokens. It returns the number of users removed.
+func (m *MemoryUsers) PurgeDeleted(olderThan time.Duration) (int, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	cutoff := time.Now().Add(-olderThan)
+	purged := 0
+	for id, user := range m.store.users {
+		if !user.IsDeleted() || !user.DeletedAt.Before(cutoff) {
+			continue
+		}
+		delete(m.store.users, id)
+		for tokenID, token := range m.store.tokens {
+			if token.UserID == id {
+				delete(m.store.tokens, tokenID)
+			}
+		}
+		for tokenID, token := range m.store.oneTimeTokens {
+			if token.UserID == id {
+				delete(m.store.oneTimeTokens, tokenID)
+			}
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// Insert adds a new user.
+// It hashes the password with bcrypt, sets timestamps, and returns the new user’s ID.
+func (m *MemoryUsers) Insert(user User) (ID, error) {
+	newHash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcryptCost())
+	if err != nil {
+		return "", err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.store.emailTaken(user.Email, "") {
+		return "", fmt.Errorf("%w: %s", ErrDuplicateEmail, user.Email)
+	}
+
+	user.ID = m.store.nextID(&m.store.nextUserID)
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = time.Now()
+	user.Password = string(newHash)
+	user.Version = 1
+	user.DeletedAt = nil
+	user.Token = Token{}
+	m.store.users[user.ID] = user
+	return user.ID, nil
+}
+
+// Import bulk-loads users from r like User.Import: rows whose email belongs to a live user update that user,
+// other rows insert a new one.
+func (m *MemoryUsers) Import(ctx context.Context, r io.Reader, opts ImportOptions) (*ImportReport, error) {
+	return runImport(ctx, r, opts, func(ctx context.Context, batch []*importRow) error {
+		m.store.mu.Lock()
+		defer m.store.mu.Unlock()
+
+		now := time.Now()
+		for _, row := range batch {
+			user := row.user
+			user.CreatedAt = now
+			user.Version = 1
+			for id, existing := range m.store.users {
+				if !existing.IsDeleted() && existing.Email == user.Email {
+					user.ID = id
+					user.CreatedAt = existing.CreatedAt
+					user.Version = existing.Version + 1
+					break
+				}
+			}
+			if user.ID.IsZero() {
+				user.ID = m.store.nextID(&m.store.nextUserID)
+			}
+			user.UpdatedAt = now
+			m.store.users[user.ID] = user
+		}
+		return nil
+	})
+}
+
+// Export writes the users matching the options' filter to w in ID order, like User.Export.
+func (m *MemoryUsers) Export(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	if err := opts.normalize(); err != nil {
+		return 0, err
+	}
+	ew, err := newExportWriter(w, opts.Format, opts.Columns)
+	if err != nil {
+		return 0, err
+	}
+
+	m.store.mu.RLock()
+	var matched []*User
+	for _, user := range m.store.users {
+		u := user
+		if opts.Filter.matches(&u) {
+			matched = append(matched, &u)
+		}
+	}
+	m.store.mu.RUnlock()
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID.Less(matched[j].ID) })
+
+	for n, user := range matched {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		if err := ew.write(user); err != nil {
+			return n, err
+		}
+	}
+	return len(matched), ew.close()
+}
+
+// ResetPassword updates a user’s password by their ID.
+func (m *MemoryUsers) ResetPassword(id ID, newPassword string) error {
+	newHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcryptCost())
+	if err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	user, ok := m.store.liveUser(id)
+	if !ok {
+		return db.ErrNoMoreRows
+	}
+	user.Password = string(newHash)
+	user.UpdatedAt = time.Now()
+	user.Version++
+	m.store.users[id] = user
+	return nil
+}
+
+// Table returns the database table name for the Token model.
+func (m *MemoryTokens) Table() string {
+	return "tokens"
+}
+
+// GetUserForToken retrieves the user associated with a given plaintext token.
+// Soft-deleted users are never returned.
+func (m *MemoryTokens) GetUserForToken(plainText string) (User, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	hash := sha256.Sum256([]byte(plainText))
+	token, ok := m.store.tokenByHash(hash[:])
+	if !ok {
+		return User{}, fmt.Errorf("no matching user found")
+	}
+	user, ok := m.store.liveUser(token.UserID)
+	if !ok {
+		return User{}, fmt.Errorf("no matching user found")
+	}
+	return user, nil
+}
+
+// GetTokensForUser retrieves all tokens associated with a given user ID, ordered by ID.
+func (m *MemoryTokens) GetTokensForUser(id ID) ([]*Token, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	var tokens []*Token
+	for _, token := range m.store.tokens {
+		if token.UserID == id {
+			t := token
+			tokens = append(tokens, &t)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID.Less(tokens[j].ID) })
+	return tokens, nil
+}
+
+// GetUnused retrieves the unexpired tokens not used since the given time, least recently used first.
+func (m *MemoryTokens) GetUnused(since time.Time) ([]*Token, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	var tokens []*Token
+	now := time.Now()
+	for _, token := range m.store.tokens {
+		if token.Expires.After(now) && token.lastActive().Before(since) {
+			t := token
+			tokens = append(tokens, &t)
+		}
+	}
+	sortByLastActive(tokens)
+	return tokens, nil
+}
+
+// Get retrieves a token by its ID.
+func (m *MemoryTokens) Get(id ID) (*Token, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	token, ok := m.store.tokens[id]
+	if !ok {
+		return nil, db.ErrNoMoreRows
+	}
+	return &token, nil
+}
+
+// GetByToken retrieves a token by its plaintext value.
+func (m *MemoryTokens) GetByToken(plainText string) (*Token, error) {
+	if checkTokenFormat(plainText) != nil {
+		return nil, sql.ErrNoRows
+	}
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	hash := sha256.Sum256([]byte(plainText))
+	token, ok := m.store.tokenByHash(hash[:])
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &token, nil
+}
+
+// Delete removes a token by its ID.
+func (m *MemoryTokens) Delete(id ID) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	delete(m.store.tokens, id)
+	return nil
+}
+
+// DeleteByToken removes a token based on its plaintext value.
+func (m *MemoryTokens) DeleteByToken(plainText string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	hash := sha256.Sum256([]byte(plainText))
+	if token, ok := m.store.tokenByHash(hash[:]); ok {
+		delete(m.store.tokens, token.ID)
+	}
+	return nil
+}
+
+// Insert adds a new token for a user.
+// It deletes the user's existing tokens first, apart from their personal access tokens, then stores the new
+// one using the provided plaintext.
+func (m *MemoryTokens) Insert(token Token, user User) error {
+	return m.insert(token, user, true)
+}
+
+// Add adds a new token for a user, keeping their existing tokens.
+func (m *MemoryTokens) Add(token Token, user User) error {
+	return m.insert(token, user, false)
+}
+
+// insert stores token for user, first deleting the user's tokens other than personal access tokens if replace
+// is set.
+func (m *MemoryTokens) insert(token Token, user User, replace bool) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.liveUser(user.ID); !ok {
+		return fmt.Errorf("no user with id %s", user.ID)
+	}
+	if replace {
+		for id, existing := range m.store.tokens {
+			if existing.UserID == user.ID && existing.Type != TokenTypePersonal {
+				delete(m.store.tokens, id)
+			}
+		}
+	}
+
+	token.ID = m.store.nextID(&m.store.nextTokenID)
+	token.CreatedAt = time.Now()
+	token.UpdatedAt = time.Now()
+	token.UserID = user.ID
+	token.FirstName = user.FirstName
+	token.Email = user.Email
+	if token.Type == "" {
+		token.Type = TokenTypeAPI
+	}
+	hash := sha256.Sum256([]byte(token.plainText))
+	token.Hash = hash[:]
+	m.store.tokens[token.ID] = token
+	return nil
+}
+
+// GenerateToken creates a new token for a user with a specified time-to-live (TTL).
+// Generation does not touch the store, so it behaves exactly like the SQL model.
+func (m *MemoryTokens) GenerateToken(userID ID, ttl time.Duration) (*Token, error) {
+	var t Token
+	return t.GenerateToken(userID, ttl)
+}
+
+// AuthenticateToken validates a token from an HTTP request’s Authorization header.
+// It returns the associated user if the token is valid and not expired, and records the use of opaque tokens.
+func (m *MemoryTokens) AuthenticateToken(r *http.Request) (*User, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+	if DetectTokenFormat(token) != TokenOpaque {
+		return authenticateSigned(token)
+	}
+
+	tok, err := m.GetByToken(token)
+	if err != nil {
+		return nil, errors.New("no matching token found")
+	}
+
+	if err := m.use(tok); err != nil {
+		return nil, err
+	}
+
+	user, err := m.GetUserForToken(token)
+	if err != nil {
+		return nil, errors.New("no matching user found for token")
+	}
+	user.Token = *tok
+
+	m.recordUse(tok.ID, clientIP(r))
+	return &user, nil
+}
+
+// use checks that token has not expired and slides its stored expiry, under the same rules as the SQL model.
+func (m *MemoryTokens) use(token *Token) error {
+	now := time.Now()
+	if !token.ExpiresAt().After(now) {
+		return errTokenExpired
+	}
+	expires, ok := token.slidTo(now)
+	if !ok {
+		return nil
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+	if stored, found := m.store.tokens[token.ID]; found && stored.Expires.Before(expires) {
+		stored.Expires = expires
+		m.store.tokens[token.ID] = stored
+	}
+	token.Expires = expires
+	return nil
+}
+
+// recordUse records a use of a token straight away; there is no database write to coalesce.
+func (m *MemoryTokens) recordUse(id ID, ip string) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if token, ok := m.store.tokens[id]; ok {
+		now := time.Now()
+		token.LastUsedAt = &now
+		token.LastUsedIP = ip
+		token.UseCount++
+		m.store.tokens[id] = token
+	}
+}
+
+// ValidToken checks if a token is valid and not expired.
+func (m *MemoryTokens) ValidToken(plainText string) (bool, error) {
+	token, err := m.GetByToken(plainText)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := m.GetUserForToken(plainText); err != nil {
+		return false, err
+	}
+
+	if err := m.use(token); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Issue stores a new one-time token for purpose and returns its plain text, replacing the user's unused
+// tokens for the same purpose.
+func (m *MemoryOneTimeTokens) Issue(purpose Purpose, user User, email string, ttl time.Duration) (string, error) {
+	token, err := newOneTimeToken(purpose, user, email, ttl)
+	if err != nil {
+		return "", err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.liveUser(user.ID); !ok {
+		return "", fmt.Errorf("no user with id %s", user.ID)
+	}
+	for id, existing := range m.store.oneTimeTokens {
+		if existing.UserID == user.ID && existing.Purpose == purpose && existing.UsedAt == nil {
+			delete(m.store.oneTimeTokens, id)
+		}
+	}
+	token.ID = m.store.nextID(&m.store.nextOneTimeTokenID)
+	m.store.oneTimeTokens[token.ID] = *token
+	return token.plainText, nil
+}
+
+// Consume marks a one-time token as used and returns it, under the same rules as the SQL model.
+func (m *MemoryOneTimeTokens) Consume(purpose Purpose, plainText string) (*OneTimeToken, error) {
+	if checkSecretFormat(OneTimeTokenPrefix, plainText) != nil {
+		return nil, ErrOneTimeToken
+	}
+	hash := sha256.Sum256([]byte(plainText))
+	now := time.Now()
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for id, token := range m.store.oneTimeTokens {
+		if !bytes.Equal(token.Hash, hash[:]) {
+			continue
+		}
+		if token.Purpose != purpose || token.UsedAt != nil || !token.Expires.After(now) {
+			return nil, ErrOneTimeToken
+		}
+		token.UsedAt = &now
+		token.plainText = ""
+		m.store.oneTimeTokens[id] = token
+		return &token, nil
+	}
+	return nil, ErrOneTimeToken
+}
@@ -0,0 +1,271 @@
+package data
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/upper/db/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newMemoryModels returns in-memory Models with the cheapest bcrypt cost so tests stay fast.
+func newMemoryModels(t *testing.T) Models {
+	t.Helper()
+	t.Setenv("BCRYPT_COST", strconv.Itoa(bcrypt.MinCost))
+	return NewMemory()
+}
+
+// TestMemoryUsers_CRUD tests inserting, reading, updating and deleting users in the in-memory store.
+func TestMemoryUsers_CRUD(t *testing.T) {
+	m := newMemoryModels(t)
+
+	id, err := m.Users.Insert(User{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Active: 1, Password: "secret"})
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	if id.IsZero() {
+		t.Fatal("expected non-zero id")
+	}
+
+	if _, err := m.Users.Insert(User{Email: "jane@example.com", Password: "secret"}); !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("got %v, want ErrDuplicateEmail", err)
+	}
+
+	u, err := m.Users.Get(id)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if u.Password == "secret" {
+		t.Error("expected password to be hashed")
+	}
+	if ok, _ := u.PasswordMatches("secret"); !ok {
+		t.Error("expected password to match")
+	}
+
+	stale := *u
+	u.LastName = "Smith"
+	if err := m.Users.Update(*u); err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+	stale.LastName = "Jones"
+	if err := m.Users.Update(stale); !errors.Is(err, ErrStaleObject) {
+		t.Errorf("expected ErrStaleObject for an outdated version, got %v", err)
+	}
+	u, err = m.Users.GetByEmail("jane@example.com")
+	if err != nil {
+		t.Fatalf("failed to get user by email: %v", err)
+	}
+	if u.LastName != "Smith" {
+		t.Errorf("got last name %q, want %q", u.LastName, "Smith")
+	}
+
+	if err := m.Users.ResetPassword(id, "changed"); err != nil {
+		t.Fatalf("failed to reset password: %v", err)
+	}
+	u, _ = m.Users.Get(id)
+	if ok, _ := u.PasswordMatches("changed"); !ok {
+		t.Error("expected new password to match")
+	}
+
+	if err := m.Users.Delete(id); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	if _, err := m.Users.Get(id); !errors.Is(err, db.ErrNoMoreRows) {
+		t.Errorf("got error %v, want %v", err, db.ErrNoMoreRows)
+	}
+}
+
+// TestMemoryTokens_AuthenticateToken tests token insertion and authentication against the in-memory store.
+func TestMemoryTokens_AuthenticateToken(t *testing.T) {
+	m := newMemoryModels(t)
+
+	id, err := m.Users.Insert(User{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Active: 1, Password: "secret"})
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	user, _ := m.Users.Get(id)
+
+	valid, _ := m.Tokens.GenerateToken(id, time.Hour)
+	if err := m.Tokens.Insert(*valid, *user); err != nil {
+		t.Fatalf("failed to insert token: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		header  string
+		wantErr bool
+	}{
+		{"Valid", "Bearer " + valid.plainText, false},
+		{"NoHeader", "", true},
+		{"BadFormat", "Token " + valid.plainText, true},
+		{"WrongLength", "Bearer short", true},
+		{"Unknown", "Bearer " + strings.Repeat("A", TokenLength), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			got, err := m.Tokens.AuthenticateToken(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AuthenticateToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.ID != id {
+				t.Errorf("got user %s, want %s", got.ID, id)
+			}
+		})
+	}
+
+	expired, _ := m.Tokens.GenerateToken(id, -time.Hour)
+	if err := m.Tokens.Insert(*expired, *user); err != nil {
+		t.Fatalf("failed to insert token: %v", err)
+	}
+	if ok, _ := m.Tokens.ValidToken(valid.plainText); ok {
+		t.Error("expected previous token to be replaced on insert")
+	}
+	if ok, err := m.Tokens.ValidToken(expired.plainText); ok || err == nil {
+		t.Error("expected expired token to be invalid")
+	}
+
+	if err := m.Users.Delete(id); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	if tokens, _ := m.Tokens.GetTokensForUser(id); len(tokens) != 1 {
+		t.Errorf("expected soft delete to keep the user's token, got %d", len(tokens))
+	}
+	if _, err := m.Tokens.GetUserForToken(expired.plainText); err == nil {
+		t.Error("expected token of a soft-deleted user not to resolve to a user")
+	}
+	if _, err := m.Users.PurgeDeleted(0); err != nil {
+		t.Fatalf("failed to purge users: %v", err)
+	}
+	if tokens, _ := m.Tokens.GetTokensForUser(id); len(tokens) != 0 {
+		t.Errorf("expected tokens to be purged with the user, got %d", len(tokens))
+	}
+}
+
+// TestMemoryTokens_Add tests that personal access tokens are added alongside a user's other tokens, and
+// survive Insert replacing their API token.
+func TestMemoryTokens_Add(t *testing.T) {
+	m := newMemoryModels(t)
+
+	id, err := m.Users.Insert(User{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Active: 1, Password: "secret"})
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	user, _ := m.Users.Get(id)
+
+	api, _ := m.Tokens.GenerateToken(id, time.Hour)
+	if err := m.Tokens.Insert(*api, *user); err != nil {
+		t.Fatalf("failed to insert token: %v", err)
+	}
+	for _, name := range []string{"ci", "laptop"} {
+		token, _ := m.Tokens.GenerateToken(id, time.Hour)
+		token.Name = name
+		token.Type = TokenTypePersonal
+		if err := m.Tokens.Add(*token, *user); err != nil {
+			t.Fatalf("failed to add token: %v", err)
+		}
+	}
+	if tokens, _ := m.Tokens.GetTokensForUser(id); len(tokens) != 3 {
+		t.Errorf("expected Add to keep existing tokens, got %d tokens", len(tokens))
+	}
+
+	replacement, _ := m.Tokens.GenerateToken(id, time.Hour)
+	if err := m.Tokens.Insert(*replacement, *user); err != nil {
+		t.Fatalf("failed to insert token: %v", err)
+	}
+	tokens, _ := m.Tokens.GetTokensForUser(id)
+	personal := 0
+	for _, token := range tokens {
+		if token.Type == TokenTypePersonal {
+			personal++
+		}
+	}
+	if len(tokens) != 3 || personal != 2 {
+		t.Errorf("expected Insert to replace only the API token, got %d tokens, %d personal", len(tokens), personal)
+	}
+	if ok, _ := m.Tokens.ValidToken(api.PlainText()); ok {
+		t.Error("expected the replaced API token to be invalid")
+	}
+}
+
+// TestMemoryUsers_SoftDelete tests soft deleting, restoring and purging users in the in-memory store.
+func TestMemoryUsers_SoftDelete(t *testing.T) {
+	m := newMemoryModels(t)
+
+	id, err := m.Users.Insert(User{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Active: 1, Password: "secret"})
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	if err := m.Users.Delete(id); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	if _, err := m.Users.Get(id); !errors.Is(err, db.ErrNoMoreRows) {
+		t.Errorf("Get() on deleted user: got error %v, want %v", err, db.ErrNoMoreRows)
+	}
+	if _, err := m.Users.GetByEmail("jane@example.com"); !errors.Is(err, db.ErrNoMoreRows) {
+		t.Errorf("GetByEmail() on deleted user: got error %v, want %v", err, db.ErrNoMoreRows)
+	}
+	if all, _ := m.Users.GetAll(); len(all) != 0 {
+		t.Errorf("GetAll() returned %d users, want 0", len(all))
+	}
+	page, _ := m.Users.List(ListOptions{Filter: UserFilter{Deleted: true}})
+	if page.Total != 1 {
+		t.Errorf("listing deleted users returned %d, want 1", page.Total)
+	}
+
+	if err := m.Users.Restore(id); err != nil {
+		t.Fatalf("failed to restore user: %v", err)
+	}
+	if _, err := m.Users.Get(id); err != nil {
+		t.Errorf("Get() after restore: %v", err)
+	}
+	if err := m.Users.Restore(id); !errors.Is(err, db.ErrNoMoreRows) {
+		t.Errorf("Restore() on live user: got error %v, want %v", err, db.ErrNoMoreRows)
+	}
+
+	_ = m.Users.Delete(id)
+	if n, _ := m.Users.PurgeDeleted(time.Hour); n != 0 {
+		t.Errorf("PurgeDeleted(1h) removed %d users, want 0", n)
+	}
+	if n, _ := m.Users.PurgeDeleted(0); n != 1 {
+		t.Errorf("PurgeDeleted(0) removed %d users, want 1", n)
+	}
+	if err := m.Users.Restore(id); !errors.Is(err, db.ErrNoMoreRows) {
+		t.Errorf("Restore() on purged user: got error %v, want %v", err, db.ErrNoMoreRows)
+	}
+}
+
+// TestMemoryStore_Concurrent exercises the in-memory store from several goroutines; run with -race.
+func TestMemoryStore_Concurrent(t *testing.T) {
+	m := newMemoryModels(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id, err := m.Users.Insert(User{Email: string(rune('a'+i)) + "@example.com", Password: "secret"})
+			if err != nil {
+				t.Errorf("failed to insert user: %v", err)
+				return
+			}
+			_, _ = m.Users.Get(id)
+			_, _ = m.Users.GetAll()
+		}(i)
+	}
+	wg.Wait()
+
+	all, _ := m.Users.GetAll()
+	if len(all) != 8 {
+		t.Errorf("got %d users, want 8", len(all))
+	}
+}
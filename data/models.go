@@ -18,16 +18,21 @@ var database *sql.DB
 // upper is the global upper.io database session.
 var upper db.Session
 
-// Models encapsulates the User and Token models for database operations.
+// dialect is the SQL dialect of the database: "postgres", "mysql" or "sqlite".
+var dialect string
+
+// Models encapsulates the user and token stores used by handlers and middleware.
 type Models struct {
-	Users  User
-	Tokens Token
+	Users         UserStore
+	Tokens        TokenStore
+	OneTimeTokens OneTimeTokenStore // single-use tokens for email links, never accepted as bearer tokens
+	Issuer        TokenIssuer       // issues new bearer tokens in the configured format
 }
 
-// New initializes the models with the provided database pool.
+// New initializes the models with the provided database pool and optional read-replica pools.
 // It panics if initialization fails, suitable for development. For production, consider graceful error handling.
-func New(databasePool *sql.DB) Models {
-	m, err := NewWithError(databasePool)
+func New(databasePool *sql.DB, replicaPools ...*sql.DB) Models {
+	m, err := NewWithError(databasePool, replicaPools...)
 	if err != nil {
 		panic(fmt.Sprintf("failed to initialize models: %v", err))
 	}
@@ -35,8 +40,14 @@ func New(databasePool *sql.DB) Models {
 }
 
 // NewWithError initializes the models with the provided database pool and returns an error if it fails.
-// It configures the upper.io session based on the DATABASE_TYPE environment variable.
-func NewWithError(databasePool *sql.DB) (Models, error) {
+// It configures the upper.io session based on the DATABASE_TYPE environment variable, and the primary key
+// type based on DATABASE_KEY_TYPE (serial, uuid or ulid; serial if unset, and uuid and ulid need PostgreSQL). Lookups are cached if CACHE is set.
+//
+// Reads are spread across any replica pools given that pass health checks, which run every
+// DATABASE_REPLICA_HEALTH_INTERVAL, and a read a replica fails is retried on the primary. Writes always go to
+// the primary, and so do a client's reads for DATABASE_REPLICA_STICKY after it writes through models bound to
+// its ReadScope, so it reads its own writes despite replication lag; see WithReadScope.
+func NewWithError(databasePool *sql.DB, replicaPools ...*sql.DB) (Models, error) {
 	if databasePool == nil {
 		return Models{}, fmt.Errorf("database pool is nil")
 	}
@@ -46,40 +57,124 @@ func NewWithError(databasePool *sql.DB) (Models, error) {
 		return Models{}, fmt.Errorf("DATABASE_TYPE environment variable not set")
 	}
 
-	switch dbType {
-	case "mysql", "mariadb":
-		session, err := mysql.New(databasePool)
+	kt, err := parseKeyType(os.Getenv("DATABASE_KEY_TYPE"))
+	if err != nil {
+		return Models{}, err
+	}
+	if kt != KeySerial && dialectOf(dbType) != "postgres" {
+		// Only the PostgreSQL migrations create uuid and ulid key columns.
+		return Models{}, fmt.Errorf("DATABASE_KEY_TYPE %s is only supported with PostgreSQL; use serial keys with %s", kt, dbType)
+	}
+	keyType = kt
+
+	c, userTTL, tokenTTL, err := cacheFromEnv()
+	if err != nil {
+		return Models{}, err
+	}
+	SetCache(c, userTTL, tokenTTL)
+
+	session, err := newSession(dbType, databasePool)
+	if err != nil {
+		return Models{}, err
+	}
+	upper = session
+	dialect = dialectOf(dbType)
+
+	if replicas != nil {
+		replicas.close()
+		replicas = nil
+	}
+	if len(replicaPools) > 0 {
+		sticky, err := envDuration("DATABASE_REPLICA_STICKY", DefaultReplicaSticky)
 		if err != nil {
 			return Models{}, err
 		}
-		upper = session
-	case "postgresql", "postgres":
-		session, err := postgresql.New(databasePool)
+		interval, err := envDuration("DATABASE_REPLICA_HEALTH_INTERVAL", DefaultReplicaHealthInterval)
 		if err != nil {
 			return Models{}, err
 		}
-		upper = session
-	case "sqlite", "turso", "libsql":
-		session, err := sqlite.New(databasePool)
+		rs, err := newReplicaSet(dbType, replicaPools, sticky)
 		if err != nil {
 			return Models{}, err
 		}
-		upper = session
-	case "mongo", "mongodb":
-		return Models{}, fmt.Errorf("mongo not implemented")
-	default:
-		return Models{}, fmt.Errorf("unknown DATABASE_TYPE: %s", dbType)
+		rs.start(interval)
+		replicas = rs
+	}
+
+	tokens := &Token{}
+	issuer, signed, err := tokenIssuerFromEnv(tokens)
+	if err != nil {
+		return Models{}, err
+	}
+	SetSignedIssuer(signed)
+
+	policies, err := expiryPoliciesFromEnv()
+	if err != nil {
+		return Models{}, err
 	}
+	SetExpiryPolicies(policies)
 
 	return Models{
-		Users:  User{},
-		Tokens: Token{},
+		Users:         &User{},
+		Tokens:        tokens,
+		OneTimeTokens: &OneTimeToken{},
+		Issuer:        issuer,
 	}, nil
 }
 
+// WithReadScope returns a copy of the models whose SQL stores route their reads through scope, and pin it to the
+// primary when they write. Handlers bind the models to the request's scope, so each client reads its own writes
+// without sending everyone else's reads to the primary. In-memory stores have no replicas and are kept as is.
+func (m Models) WithReadScope(scope *ReadScope) Models {
+	if _, ok := m.Users.(*User); ok {
+		m.Users = &User{scope: scope}
+	}
+	if _, ok := m.Tokens.(*Token); ok {
+		m.Tokens = &Token{scope: scope}
+		if o, ok := m.Issuer.(OpaqueIssuer); ok {
+			o.Tokens = m.Tokens
+			m.Issuer = o
+		}
+	}
+	if _, ok := m.OneTimeTokens.(*OneTimeToken); ok {
+		m.OneTimeTokens = &OneTimeToken{scope: scope}
+	}
+	return m
+}
+
+// dialectOf maps a DATABASE_TYPE to the SQL dialect it speaks.
+func dialectOf(dbType string) string {
+	switch dbType {
+	case "mysql", "mariadb":
+		return "mysql"
+	case "sqlite", "turso", "libsql":
+		return "sqlite"
+	default:
+		return "postgres"
+	}
+}
+
+// newSession opens an upper.io session of the given database type on the pool.
+func newSession(dbType string, pool *sql.DB) (db.Session, error) {
+	switch dbType {
+	case "mysql", "mariadb":
+		return mysql.New(pool)
+	case "postgresql", "postgres":
+		return postgresql.New(pool)
+	case "sqlite", "turso", "libsql":
+		return sqlite.New(pool)
+	case "mongo", "mongodb":
+		return nil, fmt.Errorf("mongo not implemented")
+	default:
+		return nil, fmt.Errorf("unknown DATABASE_TYPE: %s", dbType)
+	}
+}
+
 // GetInsertID converts a db.ID to an integer for use as a record identifier.
 // It supports int and int64 types, returning the value as an int. For unsupported
 // types like strings (e.g., UUIDs), it returns 0, assuming the caller handles such cases.
+//
+// Deprecated: use InsertID, which keeps UUID and ULID keys and reports unsupported types.
 func GetInsertID(i db.ID) int {
 	switch v := i.(type) {
 	case int:
@@ -0,0 +1,159 @@
+package data
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/upper/db/v4"
+)
+
+// Purpose is what a one-time token is for. A token is only accepted for the purpose it was issued for.
+type Purpose string
+
+// Purposes of the one-time tokens sent in email links. Invitations are issued for an invited user created
+// inactive beforehand.
+const (
+	PurposeEmailVerification Purpose = "email_verification"
+	PurposePasswordReset     Purpose = "password_reset"
+	PurposeEmailChange       Purpose = "email_change"
+	PurposeInvitation        Purpose = "invitation"
+)
+
+// maxPurposeLength is the length of the one_time_tokens.purpose column.
+const maxPurposeLength = 32
+
+// DefaultTTL returns how long tokens for the purpose stay valid when issued without a TTL of their own.
+func (p Purpose) DefaultTTL() time.Duration {
+	switch p {
+	case PurposePasswordReset, PurposeEmailChange:
+		return time.Hour
+	case PurposeInvitation:
+		return 7 * 24 * time.Hour
+	default:
+		return 24 * time.Hour
+	}
+}
+
+// OneTimeTokenPrefix starts every one-time token. It differs from TokenPrefix, so a one-time token fails the
+// bearer token format check without a query, and a bearer token can never be consumed as a one-time token.
+const OneTimeTokenPrefix = "dvo_"
+
+// ErrOneTimeToken is returned when consuming a one-time token that does not exist, was issued for another
+// purpose, has expired or was already used. The cases are not told apart, so links cannot be probed.
+var ErrOneTimeToken = errors.New("invalid or expired one-time token")
+
+// OneTimeToken is a short-lived, single-use secret for a purpose such as a password reset, sent to a user in
+// an email link. Only its hash is stored, in the one_time_tokens table, apart from bearer tokens.
+type OneTimeToken struct {
+	ID        ID         `db:"id,omitempty"`
+	UserID    ID         `db:"user_id"`
+	Email     string     `db:"email"` // where the link was sent: the new address, for an email change
+	Purpose   Purpose    `db:"purpose"`
+	plainText string     `db:"-"`
+	Hash      []byte     `db:"token_hash"`
+	CreatedAt time.Time  `db:"created_at"`
+	Expires   time.Time  `db:"expiry"`
+	UsedAt    *time.Time `db:"used_at"` // nil until consumed
+	scope     *ReadScope `db:"-"`       // routes the reads of the store, see Models.WithReadScope
+}
+
+// Table returns the database table name for the OneTimeToken model.
+func (o *OneTimeToken) Table() string {
+	return "one_time_tokens"
+}
+
+// newOneTimeToken returns an unsaved token for user and purpose, sent to email or else the user's address,
+// valid for ttl or else the purpose's default.
+func newOneTimeToken(purpose Purpose, user User, email string, ttl time.Duration) (*OneTimeToken, error) {
+	if purpose == "" || len(purpose) > maxPurposeLength {
+		return nil, fmt.Errorf("invalid one-time token purpose %q", purpose)
+	}
+	if ttl <= 0 {
+		ttl = purpose.DefaultTTL()
+	}
+	if email == "" {
+		email = user.Email
+	}
+	plainText, err := newSecretText(OneTimeTokenPrefix, TokenLength)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(plainText))
+	now := time.Now()
+	return &OneTimeToken{
+		UserID:    user.ID,
+		Email:     email,
+		Purpose:   purpose,
+		plainText: plainText,
+		Hash:      hash[:],
+		CreatedAt: now,
+		Expires:   now.Add(ttl),
+	}, nil
+}
+
+// Issue stores a new token for purpose and returns its plain text, to be sent to email, or to the user's own
+// address if email is empty. The token expires after ttl, or the purpose's DefaultTTL if ttl is not positive.
+// Unused tokens the user holds for the same purpose are deleted, so only the latest link works.
+func (o *OneTimeToken) Issue(purpose Purpose, user User, email string, ttl time.Duration) (string, error) {
+	token, err := newOneTimeToken(purpose, user, email, ttl)
+	if err != nil {
+		return "", err
+	}
+	token.ID = NewID()
+
+	defer o.scope.wrote()
+	err = upper.Tx(func(tx db.Session) error {
+		collection := tx.Collection(o.Table())
+		err := collection.Find(db.Cond{"user_id": user.ID, "purpose": purpose, "used_at IS": nil}).Delete()
+		if err != nil {
+			return err
+		}
+		_, err = collection.Insert(token)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return token.plainText, nil
+}
+
+// consumeQuery marks a usable token as used. The hash is bound as a raw parameter, as in GetByToken.
+const consumeQuery = `UPDATE one_time_tokens SET used_at = ?
+	WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expiry > ?`
+
+// Consume marks the token as used and returns it, provided it was issued for purpose, has not expired and
+// was not used before. The check and the marking are one conditional update, so of several concurrent
+// attempts only one succeeds. Otherwise it returns ErrOneTimeToken, and a token presented for the wrong
+// purpose stays usable for its own. Callers should still load the user, who may have been deleted since.
+func (o *OneTimeToken) Consume(purpose Purpose, plainText string) (*OneTimeToken, error) {
+	if checkSecretFormat(OneTimeTokenPrefix, plainText) != nil {
+		return nil, ErrOneTimeToken
+	}
+	hash := sha256.Sum256([]byte(plainText))
+	now := time.Now()
+
+	defer o.scope.wrote()
+	var token OneTimeToken
+	err := upper.Tx(func(tx db.Session) error {
+		res, err := tx.SQL().Exec(consumeQuery, now, hash[:], purpose, now)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n != 1 {
+			return ErrOneTimeToken
+		}
+		row, err := tx.SQL().QueryRow("SELECT id, user_id, email, purpose, token_hash, created_at, expiry, used_at FROM one_time_tokens WHERE token_hash = ?", hash[:])
+		if err != nil {
+			return err
+		}
+		return row.Scan(&token.ID, &token.UserID, &token.Email, &token.Purpose, &token.Hash, &token.CreatedAt, &token.Expires, &token.UsedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
@@ -0,0 +1,135 @@
+package data
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newOneTimeTokenUser returns in-memory models holding one user.
+func newOneTimeTokenUser(t *testing.T) (Models, User) {
+	t.Helper()
+	m := newMemoryModels(t)
+	id, err := m.Users.Insert(User{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Active: 1, Password: "secret"})
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	user, err := m.Users.Get(id)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	return m, *user
+}
+
+// TestMemoryOneTimeTokens_Consume tests that a one-time token is consumed once, and only for its purpose.
+func TestMemoryOneTimeTokens_Consume(t *testing.T) {
+	m, user := newOneTimeTokenUser(t)
+
+	plainText, err := m.OneTimeTokens.Issue(PurposePasswordReset, user, "", 0)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	if !strings.HasPrefix(plainText, OneTimeTokenPrefix) {
+		t.Errorf("Issue() = %q, want the %s prefix", plainText, OneTimeTokenPrefix)
+	}
+
+	if _, err := m.OneTimeTokens.Consume(PurposeEmailVerification, plainText); !errors.Is(err, ErrOneTimeToken) {
+		t.Errorf("Consume() for another purpose error = %v, want ErrOneTimeToken", err)
+	}
+	token, err := m.OneTimeTokens.Consume(PurposePasswordReset, plainText)
+	if err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+	if token.UserID != user.ID || token.Email != "jane@example.com" || token.UsedAt == nil {
+		t.Errorf("Consume() = %+v", token)
+	}
+	if d := time.Until(token.Expires); d <= 59*time.Minute || d > time.Hour {
+		t.Errorf("password reset token expires in %s, want an hour", d)
+	}
+	if _, err := m.OneTimeTokens.Consume(PurposePasswordReset, plainText); !errors.Is(err, ErrOneTimeToken) {
+		t.Errorf("second Consume() error = %v, want ErrOneTimeToken", err)
+	}
+}
+
+// TestMemoryOneTimeTokens_Issue tests that issuing a token replaces unused ones for the same purpose only,
+// and that expired tokens cannot be consumed.
+func TestMemoryOneTimeTokens_Issue(t *testing.T) {
+	m, user := newOneTimeTokenUser(t)
+
+	first, _ := m.OneTimeTokens.Issue(PurposeEmailChange, user, "new@example.com", time.Hour)
+	verify, _ := m.OneTimeTokens.Issue(PurposeEmailVerification, user, "", time.Hour)
+	second, _ := m.OneTimeTokens.Issue(PurposeEmailChange, user, "newer@example.com", time.Hour)
+
+	if _, err := m.OneTimeTokens.Consume(PurposeEmailChange, first); !errors.Is(err, ErrOneTimeToken) {
+		t.Errorf("Consume() of a replaced token error = %v, want ErrOneTimeToken", err)
+	}
+	if token, err := m.OneTimeTokens.Consume(PurposeEmailChange, second); err != nil || token.Email != "newer@example.com" {
+		t.Errorf("Consume() = %+v, %v, want the token sent to newer@example.com", token, err)
+	}
+	if _, err := m.OneTimeTokens.Consume(PurposeEmailVerification, verify); err != nil {
+		t.Errorf("Consume() of a token for another purpose error = %v, want it kept", err)
+	}
+
+	expired, _ := m.OneTimeTokens.Issue(PurposeInvitation, user, "", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, err := m.OneTimeTokens.Consume(PurposeInvitation, expired); !errors.Is(err, ErrOneTimeToken) {
+		t.Errorf("Consume() of an expired token error = %v, want ErrOneTimeToken", err)
+	}
+	if _, err := m.OneTimeTokens.Issue("", user, "", 0); err == nil {
+		t.Error("Issue() with no purpose succeeded, want an error")
+	}
+}
+
+// TestOneTimeTokens_NotBearerTokens tests that one-time tokens are never accepted as bearer tokens, or the
+// other way round.
+func TestOneTimeTokens_NotBearerTokens(t *testing.T) {
+	m, user := newOneTimeTokenUser(t)
+
+	oneTime, _ := m.OneTimeTokens.Issue(PurposePasswordReset, user, "", 0)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+oneTime)
+	if _, err := m.Tokens.AuthenticateToken(r); err == nil {
+		t.Error("AuthenticateToken() accepted a one-time token")
+	}
+	if ok, _ := m.Tokens.ValidToken(oneTime); ok {
+		t.Error("ValidToken() accepted a one-time token")
+	}
+
+	bearer, _ := m.Tokens.GenerateToken(user.ID, time.Hour)
+	if err := m.Tokens.Insert(*bearer, user); err != nil {
+		t.Fatalf("failed to insert token: %v", err)
+	}
+	if _, err := m.OneTimeTokens.Consume(PurposePasswordReset, bearer.PlainText()); !errors.Is(err, ErrOneTimeToken) {
+		t.Errorf("Consume() of a bearer token error = %v, want ErrOneTimeToken", err)
+	}
+}
+
+// TestOneTimeToken_Consume tests that consuming checks and marks the token in one conditional update, and
+// that a token the update did not match is reported without reading it.
+func TestOneTimeToken_Consume(t *testing.T) {
+	mock := newMockSession(t)
+	plainText, err := newSecretText(OneTimeTokenPrefix, TokenLength)
+	if err != nil {
+		t.Fatalf("newSecretText() error = %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE one_time_tokens SET used_at = \$1\s+WHERE token_hash = \$2 AND purpose = \$3 AND used_at IS NULL AND expiry > \$4`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), PurposePasswordReset, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if _, err := (&OneTimeToken{}).Consume(PurposePasswordReset, plainText); !errors.Is(err, ErrOneTimeToken) {
+		t.Errorf("Consume() error = %v, want ErrOneTimeToken", err)
+	}
+	if _, err := (&OneTimeToken{}).Consume(PurposePasswordReset, "dvo_garbage"); !errors.Is(err, ErrOneTimeToken) {
+		t.Errorf("Consume() of a malformed token error = %v, want ErrOneTimeToken", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,214 @@
+package data
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/upper/db/v4"
+)
+
+// Defaults for the reaper, configurable via the REAPER_INTERVAL, REAPER_BATCH_SIZE and REMEMBER_TOKEN_TTL
+// environment variables. Remember tokens have no expiry of their own, so they are reaped once they are older
+// than the remember-me cookie lifetime.
+const (
+	DefaultReaperInterval   = time.Hour
+	DefaultReaperBatchSize  = 1000
+	DefaultRememberTokenTTL = 365 * 24 * time.Hour
+)
+
+// reaperMetrics publishes the reaper's counters under "reaper" in expvar, which the app serves at
+// /admin/debug/vars.
+var reaperMetrics = expvar.NewMap("reaper")
+
+// ReaperConfig configures a Reaper.
+type ReaperConfig struct {
+	Interval         time.Duration // time between passes
+	BatchSize        int           // rows deleted per statement, so no pass holds long locks
+	RememberTokenTTL time.Duration // age after which remember tokens are deleted
+	Sessions         bool          // also reap the sessions table, when sessions are stored in the database
+	ErrorLog         *log.Logger   // where failed passes are reported; the standard logger if nil
+}
+
+// ReaperConfigFromEnv reads the reaper configuration from the environment. Sessions are reaped when
+// SESSION_TYPE names the database the models use. It returns a zero Interval if REAPER_INTERVAL is "off".
+func ReaperConfigFromEnv() (ReaperConfig, error) {
+	cfg := ReaperConfig{BatchSize: DefaultReaperBatchSize}
+	if strings.ToLower(os.Getenv("REAPER_INTERVAL")) != "off" {
+		var err error
+		if cfg.Interval, err = envDuration("REAPER_INTERVAL", DefaultReaperInterval); err != nil {
+			return cfg, err
+		}
+	}
+	if v := os.Getenv("REAPER_BATCH_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid REAPER_BATCH_SIZE: %s", v)
+		}
+		cfg.BatchSize = n
+	}
+	var err error
+	if cfg.RememberTokenTTL, err = envDuration("REMEMBER_TOKEN_TTL", DefaultRememberTokenTTL); err != nil {
+		return cfg, err
+	}
+	switch strings.ToLower(os.Getenv("SESSION_TYPE")) {
+	case "postgres", "postgresql", "mysql", "mariadb", "sqlite", "turso", "libsql":
+		cfg.Sessions = true
+	}
+	return cfg, nil
+}
+
+// ReapResult counts the rows a reaper pass deleted.
+type ReapResult struct {
+	Tokens         int
+	OneTimeTokens  int
+	Sessions       int
+	RememberTokens int
+}
+
+// Reaper periodically deletes expired tokens and one-time tokens, expired sessions and stale remember tokens from the primary
+// database, in batches of at most BatchSize rows so no statement holds locks for long.
+type Reaper struct {
+	cfg    ReaperConfig
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewReaper returns a Reaper with the given configuration. Call Start to run it.
+func NewReaper(cfg ReaperConfig) *Reaper {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultReaperBatchSize
+	}
+	if cfg.RememberTokenTTL <= 0 {
+		cfg.RememberTokenTTL = DefaultRememberTokenTTL
+	}
+	if cfg.ErrorLog == nil {
+		cfg.ErrorLog = log.Default()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Reaper{cfg: cfg, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+}
+
+// Start runs a pass every Interval until Stop is called. It does nothing if Interval is not positive.
+func (r *Reaper) Start() {
+	if r.cfg.Interval <= 0 {
+		close(r.done)
+		return
+	}
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := r.RunOnce(r.ctx); err != nil && r.ctx.Err() == nil {
+					r.cfg.ErrorLog.Println("reaper:", err)
+				}
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop cancels any pass in progress, between batches, and waits for the reaper to finish.
+// It must only be called after Start, and may be called more than once.
+func (r *Reaper) Stop() {
+	r.cancel()
+	<-r.done
+}
+
+// RunOnce deletes everything currently due, batch by batch, and records the outcome in the metrics.
+func (r *Reaper) RunOnce(ctx context.Context) (ReapResult, error) {
+	start := time.Now()
+	var res ReapResult
+	var err error
+	defer func() {
+		reaperMetrics.Add("runs", 1)
+		reaperMetrics.Add("tokens_deleted", int64(res.Tokens))
+		reaperMetrics.Add("one_time_tokens_deleted", int64(res.OneTimeTokens))
+		reaperMetrics.Add("sessions_deleted", int64(res.Sessions))
+		reaperMetrics.Add("remember_tokens_deleted", int64(res.RememberTokens))
+		if err != nil {
+			reaperMetrics.Add("errors", 1)
+		}
+		last := new(expvar.Int)
+		last.Set(start.Unix())
+		reaperMetrics.Set("last_run_unix", last)
+		took := new(expvar.Float)
+		took.Set(time.Since(start).Seconds())
+		reaperMetrics.Set("last_run_seconds", took)
+	}()
+
+	now := time.Now()
+	if res.Tokens, err = r.reap(ctx, "tokens", "id", db.Cond{"expiry <": now}); err != nil {
+		return res, fmt.Errorf("reaping tokens: %w", err)
+	}
+	if res.OneTimeTokens, err = r.reap(ctx, "one_time_tokens", "id", db.Cond{"expiry <": now}); err != nil {
+		return res, fmt.Errorf("reaping one-time tokens: %w", err)
+	}
+	if res.RememberTokens, err = r.reap(ctx, "remember_tokens", "id", db.Cond{"created_at <": now.Add(-r.cfg.RememberTokenTTL)}); err != nil {
+		return res, fmt.Errorf("reaping remember tokens: %w", err)
+	}
+	if r.cfg.Sessions {
+		if res.Sessions, err = r.reap(ctx, "sessions", "token", sessionExpired()); err != nil {
+			return res, fmt.Errorf("reaping sessions: %w", err)
+		}
+	}
+	return res, nil
+}
+
+// reap deletes the rows of table matching cond in batches until none are left, and returns how many it deleted.
+// key is the table's primary key column.
+func (r *Reaper) reap(ctx context.Context, table, key string, cond interface{}) (int, error) {
+	total := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		n, err := r.deleteBatch(ctx, table, key, cond)
+		total += n
+		if err != nil || n < r.cfg.BatchSize {
+			return total, err
+		}
+	}
+}
+
+// deleteBatch deletes up to BatchSize rows of table matching cond.
+func (r *Reaper) deleteBatch(ctx context.Context, table, key string, cond interface{}) (int, error) {
+	sess := upper.WithContext(ctx).SQL()
+	q := sess.DeleteFrom(table)
+	if dialect == "mysql" {
+		// MySQL cannot delete from a table it selects from in a subquery, but supports DELETE ... LIMIT.
+		q = q.Where(cond).Limit(r.cfg.BatchSize)
+	} else {
+		batch := sess.Select(key).From(table).Where(cond).Limit(r.cfg.BatchSize)
+		q = q.Where(db.Cond{key + " IN": batch})
+	}
+	res, err := q.Exec()
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// sessionExpired matches expired rows of the sessions table, whose expiry is stored the way the session
+// store for each database writes it: a Julian day number in SQLite and a UTC timestamp in MySQL.
+func sessionExpired() interface{} {
+	switch dialect {
+	case "sqlite":
+		return db.Raw("expiry < julianday('now')")
+	case "mysql":
+		return db.Raw("expiry < UTC_TIMESTAMP(6)")
+	default:
+		return db.Raw("expiry < current_timestamp")
+	}
+}
@@ -0,0 +1,63 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestReaper_RunOnce tests that each table is reaped in batches until a batch comes back short.
+func TestReaper_RunOnce(t *testing.T) {
+	mock := newMockSession(t)
+	mock.ExpectExec(`DELETE FROM "tokens" WHERE .*"id" IN \(SELECT "id" FROM "tokens" WHERE .*"expiry" < \$1.* LIMIT 2`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM "tokens"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM "one_time_tokens" WHERE .*"expiry" <`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM "remember_tokens" WHERE .*"created_at" <`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM "sessions" WHERE .*expiry < current_timestamp`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM "sessions"`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	runs := reaperMetrics.Get("runs")
+	r := NewReaper(ReaperConfig{BatchSize: 2, Sessions: true})
+	res, err := r.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if want := (ReapResult{Tokens: 3, OneTimeTokens: 1, Sessions: 2}); res != want {
+		t.Errorf("RunOnce() = %+v, want %+v", res, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if runs != nil && reaperMetrics.Get("runs").String() == runs.String() {
+		t.Error("runs metric not incremented")
+	}
+	if reaperMetrics.Get("tokens_deleted") == nil {
+		t.Error("tokens_deleted metric not published")
+	}
+	if expvar.Get("reaper") == nil {
+		t.Error("reaper metrics not published")
+	}
+}
+
+// TestReaper_Stop tests that Stop cancels a pass between batches and waits for the reaper to exit.
+func TestReaper_Stop(t *testing.T) {
+	mock := newMockSession(t)
+	mock.ExpectExec(`DELETE FROM "tokens"`).WillDelayFor(50 * time.Millisecond).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	r := NewReaper(ReaperConfig{Interval: time.Millisecond, BatchSize: 1})
+	r.Start()
+	time.Sleep(20 * time.Millisecond)
+	r.Stop()
+	r.Stop()
+
+	// The pass in progress was cancelled, and later passes stop before their first batch.
+	res, err := r.RunOnce(r.ctx)
+	if !errors.Is(err, context.Canceled) || res.Tokens != 0 {
+		t.Errorf("RunOnce() after Stop = %+v, %v", res, err)
+	}
+}
@@ -0,0 +1,227 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/upper/db/v4"
+)
+
+// Defaults for replica routing, configurable via the DATABASE_REPLICA_STICKY and
+// DATABASE_REPLICA_HEALTH_INTERVAL environment variables.
+const (
+	DefaultReplicaSticky         = 2 * time.Second
+	DefaultReplicaHealthInterval = 10 * time.Second
+)
+
+// replicaPingTimeout bounds how long a health check waits for a replica to answer.
+const replicaPingTimeout = 2 * time.Second
+
+// replicas is the set of read replicas reads are spread across, or nil if there are none.
+var replicas *replicaSet
+
+// replica is one read-replica session and its last known health.
+type replica struct {
+	pool    *sql.DB
+	session db.Session
+	healthy atomic.Bool
+}
+
+// replicaSet routes reads to healthy replicas in round-robin order.
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint64
+	sticky   time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// newReplicaSet opens an upper session of the given database type for each replica pool.
+// Replicas start out healthy; health checks run every interval once start is called.
+func newReplicaSet(dbType string, pools []*sql.DB, sticky time.Duration) (*replicaSet, error) {
+	rs := &replicaSet{sticky: sticky, stop: make(chan struct{})}
+	for _, pool := range pools {
+		session, err := newSession(dbType, pool)
+		if err != nil {
+			return nil, err
+		}
+		r := &replica{pool: pool, session: session}
+		r.healthy.Store(true)
+		rs.replicas = append(rs.replicas, r)
+	}
+	return rs, nil
+}
+
+// start runs health checks every interval until close is called.
+func (rs *replicaSet) start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rs.check(replicaPingTimeout)
+			case <-rs.stop:
+				return
+			}
+		}
+	}()
+}
+
+// check pings every replica, marking those that do not answer within timeout as unhealthy.
+func (rs *replicaSet) check(timeout time.Duration) {
+	for _, r := range rs.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		r.healthy.Store(r.pool.PingContext(ctx) == nil)
+		cancel()
+	}
+}
+
+// close stops the health checks.
+func (rs *replicaSet) close() {
+	rs.stopOnce.Do(func() { close(rs.stop) })
+}
+
+// pick returns the next healthy replica, or nil if none is healthy.
+func (rs *replicaSet) pick() *replica {
+	n := len(rs.replicas)
+	start := rs.next.Add(1)
+	for i := 0; i < n; i++ {
+		r := rs.replicas[(start+uint64(i))%uint64(n)]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// HealthyReplicas returns the number of read replicas currently considered healthy.
+func HealthyReplicas() int {
+	if replicas == nil {
+		return 0
+	}
+	healthy := 0
+	for _, r := range replicas.replicas {
+		if r.healthy.Load() {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+// ReadScope routes the reads of one client, typically one HTTP request. Once the client writes through models
+// bound to the scope (see Models.WithReadScope), its reads go to the primary for DATABASE_REPLICA_STICKY, so it
+// reads its own writes despite replication lag, while other clients keep reading from the replicas. Background
+// writes, such as the reaper's, are made outside any scope and pin nobody.
+//
+// A nil *ReadScope is valid: its reads go to the replicas and its writes pin nothing.
+type ReadScope struct {
+	pinnedUntil atomic.Int64 // Unix nanoseconds
+}
+
+// NewReadScope returns a scope whose reads go to the primary until pinnedUntil, for a client that wrote in an
+// earlier request. The zero time pins nothing.
+func NewReadScope(pinnedUntil time.Time) *ReadScope {
+	s := &ReadScope{}
+	if !pinnedUntil.IsZero() {
+		s.pinnedUntil.Store(pinnedUntil.UnixNano())
+	}
+	return s
+}
+
+// PinnedUntil returns when the scope's reads go back to the replicas, which is in the past if they already do.
+func (s *ReadScope) PinnedUntil() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	return time.Unix(0, s.pinnedUntil.Load())
+}
+
+// ReplicaSticky returns how long a client's reads stay on the primary after it writes, or 0 if there are no
+// read replicas.
+func ReplicaSticky() time.Duration {
+	if replicas == nil {
+		return 0
+	}
+	return replicas.sticky
+}
+
+// readScopeKey is the context key of the request's ReadScope.
+type readScopeKey struct{}
+
+// WithReadScope returns a copy of ctx carrying scope.
+func WithReadScope(ctx context.Context, scope *ReadScope) context.Context {
+	return context.WithValue(ctx, readScopeKey{}, scope)
+}
+
+// ReadScopeFrom returns the scope carried by ctx, or nil if there is none.
+func ReadScopeFrom(ctx context.Context) *ReadScope {
+	scope, _ := ctx.Value(readScopeKey{}).(*ReadScope)
+	return scope
+}
+
+// wrote records that the scope's client just wrote to the primary.
+func (s *ReadScope) wrote() {
+	rs := replicas
+	if s == nil || rs == nil {
+		return
+	}
+	s.pinnedUntil.Store(time.Now().Add(rs.sticky).UnixNano())
+}
+
+// pinned reports whether the scope's reads must go to the primary.
+func (s *ReadScope) pinned() bool {
+	return s != nil && time.Now().UnixNano() < s.pinnedUntil.Load()
+}
+
+// pick returns the replica the scope's next read should use, or nil if it should use the primary.
+func (s *ReadScope) pick() *replica {
+	rs := replicas
+	if rs == nil || s.pinned() {
+		return nil
+	}
+	return rs.pick()
+}
+
+// session returns the session for a read that cannot be retried, such as a stream already partly sent: a
+// healthy replica, or the primary if there is none or the scope is pinned.
+func (s *ReadScope) session() db.Session {
+	if r := s.pick(); r != nil {
+		return r.session
+	}
+	return upper
+}
+
+// read runs fn on the session the scope's reads should use, as chosen by session. If a replica fails the read,
+// it is marked unhealthy until a health check succeeds again, and fn is retried on the primary.
+func (s *ReadScope) read(fn func(sess db.Session) error) error {
+	r := s.pick()
+	if r == nil {
+		return fn(upper)
+	}
+	err := fn(r.session)
+	if !replicaFailed(err) {
+		return err
+	}
+	r.healthy.Store(false)
+	return fn(upper)
+}
+
+// replicaFailed reports whether a read's error means the replica failed, rather than that nothing matched or
+// the caller gave up.
+func replicaFailed(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, db.ErrNoMoreRows),
+		errors.Is(err, db.ErrNilRecord),
+		errors.Is(err, sql.ErrNoRows),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/upper/db/v4"
+)
+
+// newTestReplicaSet builds a replica set of n sqlmock-backed Postgres replicas and installs it,
+// along with a mock primary, for the duration of a test.
+func newTestReplicaSet(t *testing.T, n int, sticky time.Duration) (*replicaSet, []sqlmock.Sqlmock) {
+	t.Helper()
+	open := func() (*sql.DB, sqlmock.Sqlmock) {
+		pool, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		if err != nil {
+			t.Fatalf("failed to create sqlmock: %v", err)
+		}
+		t.Cleanup(func() { pool.Close() })
+		mock.ExpectPing()
+		mock.ExpectQuery(`SELECT CURRENT_DATABASE\(\) AS name`).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("testdb"))
+		return pool, mock
+	}
+
+	primary, _ := open()
+	session, err := newSession("postgres", primary)
+	if err != nil {
+		t.Fatalf("failed to open primary session: %v", err)
+	}
+
+	var pools []*sql.DB
+	var mocks []sqlmock.Sqlmock
+	for i := 0; i < n; i++ {
+		pool, mock := open()
+		pools = append(pools, pool)
+		mocks = append(mocks, mock)
+	}
+	rs, err := newReplicaSet("postgres", pools, sticky)
+	if err != nil {
+		t.Fatalf("failed to open replicas: %v", err)
+	}
+
+	oldUpper, oldReplicas := upper, replicas
+	upper, replicas = session, rs
+	t.Cleanup(func() {
+		upper, replicas = oldUpper, oldReplicas
+	})
+	return rs, mocks
+}
+
+// TestReadScope_Session tests that reads rotate across healthy replicas and fall back to the primary.
+func TestReadScope_Session(t *testing.T) {
+	rs, mocks := newTestReplicaSet(t, 2, time.Minute)
+	a, b := rs.replicas[0].session, rs.replicas[1].session
+	var scope *ReadScope
+
+	first, second := scope.session(), scope.session()
+	if first == second || (first != a && first != b) || (second != a && second != b) {
+		t.Errorf("expected reads to alternate between the replicas")
+	}
+
+	mocks[0].ExpectPing().WillReturnError(errors.New("connection refused"))
+	mocks[1].ExpectPing()
+	rs.check(time.Second)
+	if HealthyReplicas() != 1 {
+		t.Fatalf("got %d healthy replicas, want 1", HealthyReplicas())
+	}
+	for i := 0; i < 3; i++ {
+		if got := scope.session(); got != b {
+			t.Fatalf("expected reads to skip the unhealthy replica")
+		}
+	}
+
+	mocks[1].ExpectPing().WillReturnError(errors.New("connection refused"))
+	mocks[0].ExpectPing().WillReturnError(errors.New("connection refused"))
+	rs.check(time.Second)
+	if got := scope.session(); got != upper {
+		t.Errorf("expected reads to fall back to the primary when no replica is healthy")
+	}
+}
+
+// TestReadScope_Sticky tests that a scope's reads stay on the primary for the sticky window after it writes,
+// without pinning other scopes.
+func TestReadScope_Sticky(t *testing.T) {
+	newTestReplicaSet(t, 1, time.Minute)
+	writer, other := NewReadScope(time.Time{}), NewReadScope(time.Time{})
+
+	if writer.session() == upper {
+		t.Fatal("expected reads to go to the replica before any write")
+	}
+	writer.wrote()
+	if writer.session() != upper {
+		t.Error("expected reads right after a write to go to the primary")
+	}
+	if other.session() == upper {
+		t.Error("expected another scope's reads to stay on the replica")
+	}
+	if (*ReadScope)(nil).session() == upper {
+		t.Error("expected unscoped reads to stay on the replica")
+	}
+
+	carried := NewReadScope(writer.PinnedUntil())
+	if carried.session() != upper {
+		t.Error("expected a scope carrying the pin to a later request to read from the primary")
+	}
+
+	replicas.sticky = 0
+	writer.wrote()
+	if writer.session() == upper {
+		t.Error("expected reads to go back to the replica after the sticky window")
+	}
+}
+
+// TestReadScope_Failover tests that a read a replica fails is retried on the primary and takes the replica out
+// of rotation, while not-found results are not retried.
+func TestReadScope_Failover(t *testing.T) {
+	rs, _ := newTestReplicaSet(t, 1, time.Minute)
+	var scope *ReadScope
+
+	var tried []db.Session
+	err := scope.read(func(sess db.Session) error {
+		tried = append(tried, sess)
+		if sess != upper {
+			return errors.New("connection reset by peer")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected the read to succeed on the primary, got %v", err)
+	}
+	if len(tried) != 2 || tried[0] != rs.replicas[0].session || tried[1] != upper {
+		t.Errorf("expected the replica then the primary to be tried, got %d sessions", len(tried))
+	}
+	if HealthyReplicas() != 0 {
+		t.Errorf("expected the failing replica to be marked unhealthy")
+	}
+
+	rs.replicas[0].healthy.Store(true)
+	tried = nil
+	err = scope.read(func(sess db.Session) error {
+		tried = append(tried, sess)
+		return db.ErrNoMoreRows
+	})
+	if !errors.Is(err, db.ErrNoMoreRows) || len(tried) != 1 {
+		t.Errorf("expected not-found to be returned from the replica, got %v after %d tries", err, len(tried))
+	}
+	if HealthyReplicas() != 1 {
+		t.Errorf("expected not-found to leave the replica healthy")
+	}
+}
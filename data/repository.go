@@ -0,0 +1,217 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/upper/db/v4"
+)
+
+// Repository provides the common persistence operations for a model type T, using upper.
+// T must be a struct with db tags whose pointer has a Table() string method. The zero value is ready to use,
+// so models typically embed it and only add domain-specific methods:
+//
+//	type Post struct {
+//		Repository[Post] `db:"-"`
+//		ID    ID     `db:"id,omitempty"`
+//		Title string `db:"title"`
+//	}
+//
+// If T has created_at and updated_at columns of type time.Time they are maintained automatically, and if it has
+// an int version column, updates are conditional on it and return ErrStaleObject when the row has moved on.
+// If *T implements lifecycle hooks such as BeforeInserter, Insert, Update and Delete run them in the write's
+// transaction.
+//
+// Reads are spread across any read replicas. Bind the repository to a client's ReadScope with WithReadScope so
+// that client reads its own writes.
+type Repository[T any] struct {
+	scope *ReadScope
+}
+
+// WithReadScope returns a copy of the repository whose reads and writes go through scope.
+func (r Repository[T]) WithReadScope(scope *ReadScope) Repository[T] {
+	r.scope = scope
+	return r
+}
+
+// tabler is implemented by models that know their table name.
+type tabler interface {
+	Table() string
+}
+
+// table returns T's table name.
+func (r Repository[T]) table() string {
+	t, ok := any(new(T)).(tabler)
+	if !ok {
+		panic(fmt.Sprintf("data: %T does not have a Table method", new(T)))
+	}
+	return t.Table()
+}
+
+// GetAll retrieves all records matching the conditions, or every record if none are given.
+func (r Repository[T]) GetAll(conds ...interface{}) ([]*T, error) {
+	var all []*T
+	err := r.scope.read(func(sess db.Session) error {
+		all = nil
+		return sess.Collection(r.table()).Find(conds...).All(&all)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// FindOne retrieves the first record matching the conditions.
+// It returns db.ErrNoMoreRows if there is none.
+func (r Repository[T]) FindOne(conds ...interface{}) (*T, error) {
+	var one T
+	err := r.scope.read(func(sess db.Session) error {
+		return sess.Collection(r.table()).Find(conds...).One(&one)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &one, nil
+}
+
+// Get retrieves a record by its ID.
+func (r Repository[T]) Get(id ID) (*T, error) {
+	return r.FindOne(db.Cond{"id": id})
+}
+
+// Count returns the number of records matching the conditions.
+func (r Repository[T]) Count(conds ...interface{}) (int, error) {
+	var n uint64
+	err := r.scope.read(func(sess db.Session) (err error) {
+		n, err = sess.Collection(r.table()).Find(conds...).Count()
+		return err
+	})
+	return int(n), err
+}
+
+// Exists reports whether any record matches the conditions.
+func (r Repository[T]) Exists(conds ...interface{}) (bool, error) {
+	var exists bool
+	err := r.scope.read(func(sess db.Session) (err error) {
+		exists, err = sess.Collection(r.table()).Find(conds...).Exists()
+		return err
+	})
+	return exists, err
+}
+
+// Insert adds a new record and returns its ID.
+// It sets the timestamps and the initial version, and generates the ID in Go for UUID and ULID keys.
+func (r Repository[T]) Insert(m T) (ID, error) {
+	defer r.scope.wrote()
+	v := reflect.ValueOf(&m).Elem()
+	now := time.Now()
+	setColumn(v, "created_at", now)
+	setColumn(v, "updated_at", now)
+	setColumn(v, "version", 1)
+
+	id, _ := column(v, "id").(ID)
+	if id.IsZero() {
+		id = NewID()
+		setColumn(v, "id", id)
+	}
+
+	err := withHooks(&m, hookInsert, nil, func(sess db.Session) error {
+		res, err := sess.Collection(r.table()).Insert(m)
+		if err != nil {
+			return err
+		}
+		if id.IsZero() {
+			if id, err = InsertID(res.ID()); err != nil {
+				return err
+			}
+			setColumn(v, "id", id)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Update saves a record by its ID and bumps its updated_at timestamp.
+// For models with a version column the update only applies if the version matches the stored one, which it
+// then increments; otherwise it returns ErrStaleObject, or db.ErrNoMoreRows if the record is gone.
+func (r Repository[T]) Update(m T) error {
+	defer r.scope.wrote()
+	v := reflect.ValueOf(&m).Elem()
+	setColumn(v, "updated_at", time.Now())
+
+	id, _ := column(v, "id").(ID)
+	where := db.Cond{"id": id}
+	expected, versioned := column(v, "version").(int)
+	if versioned {
+		setColumn(v, "version", expected+1)
+		where["version"] = expected
+	}
+
+	return withHooks(&m, hookUpdate, nil, func(sess db.Session) error {
+		res, err := sess.SQL().Update(r.table()).Set(m).Where(where).Exec()
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			if versioned {
+				return staleOrMissing(sess, r.table(), db.Cond{"id": id})
+			}
+			return db.ErrNoMoreRows
+		}
+		return nil
+	})
+}
+
+// Delete removes a record by its ID. Delete hooks see the record as it was before the delete.
+func (r Repository[T]) Delete(id ID) error {
+	defer r.scope.wrote()
+	var m T
+	load := func(tx db.Session) error {
+		return tx.Collection(r.table()).Find(db.Cond{"id": id}).One(&m)
+	}
+	return withHooks(&m, hookDelete, load, func(sess db.Session) error {
+		return sess.Collection(r.table()).Find(db.Cond{"id": id}).Delete()
+	})
+}
+
+// columnField returns the field of struct value v whose db tag names the given column.
+func columnField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("db"), ",")
+		if tag == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// column returns the value of the given column of struct value v, or nil if v has no such column.
+func column(v reflect.Value, name string) interface{} {
+	f, ok := columnField(v, name)
+	if !ok {
+		return nil
+	}
+	return f.Interface()
+}
+
+// setColumn sets the given column of struct value v if it exists and has the value's type.
+func setColumn(v reflect.Value, name string, value interface{}) {
+	f, ok := columnField(v, name)
+	if !ok || !f.CanSet() {
+		return
+	}
+	val := reflect.ValueOf(value)
+	if val.Type() == f.Type() {
+		f.Set(val)
+	}
+}
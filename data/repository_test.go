@@ -0,0 +1,175 @@
+package data
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/upper/db/v4"
+)
+
+// TestRepository_Table tests that the repository resolves the table name from the model's Table method.
+func TestRepository_Table(t *testing.T) {
+	var m TestModel
+	if got := m.table(); got != "test_models" {
+		t.Errorf("got table %q, want %q", got, "test_models")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a model without a Table method")
+		}
+	}()
+	Repository[struct{}]{}.table()
+}
+
+// TestRepository_Columns tests reading and writing struct fields by their db column names.
+func TestRepository_Columns(t *testing.T) {
+	var m TestModel
+	v := reflect.ValueOf(&m).Elem()
+
+	now := time.Now()
+	setColumn(v, "created_at", now)
+	setColumn(v, "version", 3)
+	setColumn(v, "id", ID("7"))
+	setColumn(v, "version", "wrong type")
+	setColumn(v, "missing", 1)
+
+	if !m.CreatedAt.Equal(now) || m.Version != 3 || m.ID != "7" {
+		t.Errorf("unexpected model after setColumn: %+v", m)
+	}
+	if got := column(v, "id"); got != ID("7") {
+		t.Errorf("column(id) = %v, want 7", got)
+	}
+	if got := column(v, "missing"); got != nil {
+		t.Errorf("column(missing) = %v, want nil", got)
+	}
+}
+
+// expectPrimaryKey expects upper's lookup of the test_models primary key, which precedes its first
+// collection query on a session.
+func expectPrimaryKey(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT "pg_attribute"."attname" AS "pkey"`).
+		WillReturnRows(sqlmock.NewRows([]string{"pkey"}).AddRow("id"))
+}
+
+// TestRepository_Insert tests that Insert sets the timestamps and the initial version, and returns the
+// database-assigned ID for serial keys or the one generated in Go otherwise.
+func TestRepository_Insert(t *testing.T) {
+	withKeyType(t, KeySerial)
+	mock := newMockSession(t)
+	expectPrimaryKey(mock)
+	mock.ExpectQuery(`INSERT INTO "test_models" \("created_at", "updated_at", "version"\) VALUES \(\$1, \$2, \$3\) RETURNING "id"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+
+	id, err := (TestModel{}).Insert(TestModel{})
+	if err != nil || id != "7" {
+		t.Errorf("Insert() = %q, %v; want 7", id, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	withKeyType(t, KeyUUID)
+	mock = newMockSession(t)
+	expectPrimaryKey(mock)
+	mock.ExpectQuery(`INSERT INTO "test_models" \("created_at", "id", "updated_at", "version"\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("0190a8e4-5d2c-7000-8000-000000000000"))
+
+	id, err = (TestModel{}).Insert(TestModel{})
+	if err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+	if _, err := ParseID(id.String()); err != nil || id.IsZero() {
+		t.Errorf("Insert() = %q, want a generated UUID", id)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestRepository_Get tests loading a record by ID, and db.ErrNoMoreRows for a missing one.
+func TestRepository_Get(t *testing.T) {
+	withKeyType(t, KeySerial)
+	mock := newMockSession(t)
+	expectPrimaryKey(mock)
+	mock.ExpectQuery(`SELECT \* FROM "test_models" WHERE \("id" = \$1\) LIMIT 1`).
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version"}).AddRow(7, 3))
+	mock.ExpectQuery(`SELECT \* FROM "test_models" WHERE \("id" = \$1\) LIMIT 1`).
+		WithArgs(int64(8)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version"}))
+
+	m, err := (TestModel{}).Get("7")
+	if err != nil || m.ID != "7" || m.Version != 3 {
+		t.Errorf("Get() = %+v, %v; want version 3 of record 7", m, err)
+	}
+	if _, err := (TestModel{}).Get("8"); !errors.Is(err, db.ErrNoMoreRows) {
+		t.Errorf("Get() of a missing record error = %v, want db.ErrNoMoreRows", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestRepository_Update tests that updates are conditional on the version, which they increment, and that
+// an update matching no row is reported as stale if the record exists and as missing otherwise.
+func TestRepository_Update(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected int64
+		exists   bool
+		want     error
+	}{
+		{"current", 1, true, nil},
+		{"stale", 0, true, ErrStaleObject},
+		{"missing", 0, false, db.ErrNoMoreRows},
+	}
+	withKeyType(t, KeySerial)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := newMockSession(t)
+			mock.ExpectExec(`UPDATE "test_models" SET .*"version" = \$\d+ WHERE \("id" = \$\d+ AND "version" = \$\d+\)`).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 4, int64(7), 3).
+				WillReturnResult(sqlmock.NewResult(0, tt.affected))
+			if tt.affected == 0 {
+				expectPrimaryKey(mock)
+				count := 0
+				if tt.exists {
+					count = 1
+				}
+				mock.ExpectQuery(`SELECT count\(1\) AS _t FROM "test_models" WHERE \("id" = \$1\)`).
+					WithArgs(int64(7)).
+					WillReturnRows(sqlmock.NewRows([]string{"_t"}).AddRow(count))
+			}
+
+			err := (TestModel{}).Update(TestModel{ID: "7", Version: 3})
+			if !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+				t.Errorf("Update() error = %v, want %v", err, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestRepository_Delete tests deleting a record by ID.
+func TestRepository_Delete(t *testing.T) {
+	withKeyType(t, KeySerial)
+	mock := newMockSession(t)
+	expectPrimaryKey(mock)
+	mock.ExpectExec(`DELETE FROM "test_models" WHERE \("id" = \$1\)`).
+		WithArgs(int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := (TestModel{}).Delete("7"); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
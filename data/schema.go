@@ -0,0 +1,246 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SchemaDrift is one difference between a model's db struct tags and the columns of its live table.
+type SchemaDrift struct {
+	Table   string
+	Column  string // empty when the whole table is missing
+	Field   string // Go struct field, empty for a column no field maps
+	Problem string
+}
+
+// String describes the drift on one line, such as "users.email (User.Email): column is missing".
+func (d SchemaDrift) String() string {
+	s := d.Table
+	if d.Column != "" {
+		s += "." + d.Column
+	}
+	if d.Field != "" {
+		s += " (" + d.Field + ")"
+	}
+	return s + ": " + d.Problem
+}
+
+// SchemaModels returns the models CheckSchema compares by default: those whose tables the migrations create.
+func SchemaModels() []interface{ Table() string } {
+	return []interface{ Table() string }{&User{}, &Token{}, &OneTimeToken{}}
+}
+
+// dbColumn is a column of a live table as reported by the database.
+type dbColumn struct {
+	name       string
+	dataType   string
+	nullable   bool
+	hasDefault bool
+}
+
+// CheckSchema introspects the primary database and compares each model's table with its db struct tags:
+// every tagged field needs a column of a compatible type, pointer fields need nullable columns and other
+// fields NOT NULL ones, and a NOT NULL column without a default must be mapped or inserts fail. Fields that
+// cannot be mapped, such as an unexported field with a db tag, are reported too. Models are pointers to
+// structs with a Table method; SchemaModels lists the application's.
+func CheckSchema(ctx context.Context, models ...interface{ Table() string }) ([]SchemaDrift, error) {
+	if database == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	var drifts []SchemaDrift
+	for _, m := range models {
+		table := m.Table()
+		columns, err := tableColumns(ctx, table)
+		if err != nil {
+			return nil, fmt.Errorf("reading columns of %s: %w", table, err)
+		}
+		if len(columns) == 0 {
+			drifts = append(drifts, SchemaDrift{Table: table, Problem: "table does not exist"})
+			continue
+		}
+		drifts = append(drifts, compareModel(m, table, columns)...)
+	}
+	return drifts, nil
+}
+
+// compareModel compares the db-tagged fields of model m with the columns of its table.
+func compareModel(m interface{}, table string, columns map[string]dbColumn) []SchemaDrift {
+	var drifts []SchemaDrift
+	t := reflect.TypeOf(m)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	mapped := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("db")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" || (!ok && (!f.IsExported() || f.Anonymous)) {
+			continue
+		}
+		field := t.Name() + "." + f.Name
+		switch {
+		case !f.IsExported():
+			drifts = append(drifts, SchemaDrift{Table: table, Column: name, Field: field,
+				Problem: `unexported field has a db tag and is never stored; tag it db:"-"`})
+			continue
+		case name == "":
+			drifts = append(drifts, SchemaDrift{Table: table, Field: field,
+				Problem: `field has no column name; name its column or tag it db:"-"`})
+			continue
+		}
+
+		mapped[name] = true
+		col, ok := columns[name]
+		if !ok {
+			drifts = append(drifts, SchemaDrift{Table: table, Column: name, Field: field, Problem: "column is missing"})
+			continue
+		}
+		if want := fieldTypeFamilies(f.Type); want != nil && !contains(want, typeFamily(col.dataType)) {
+			drifts = append(drifts, SchemaDrift{Table: table, Column: name, Field: field,
+				Problem: fmt.Sprintf("column type %s does not fit Go type %s", col.dataType, f.Type)})
+		}
+		switch pointer := f.Type.Kind() == reflect.Pointer; {
+		case col.nullable && !pointer:
+			drifts = append(drifts, SchemaDrift{Table: table, Column: name, Field: field,
+				Problem: "column is nullable but the field is not a pointer, so reading NULL fails"})
+		case !col.nullable && pointer:
+			drifts = append(drifts, SchemaDrift{Table: table, Column: name, Field: field,
+				Problem: "column is NOT NULL but the field is a pointer, so saving nil fails"})
+		}
+	}
+
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if col := columns[name]; !mapped[name] && !col.nullable && !col.hasDefault {
+			drifts = append(drifts, SchemaDrift{Table: table, Column: name,
+				Problem: "NOT NULL column without a default is not mapped by any field, so inserts fail"})
+		}
+	}
+	return drifts
+}
+
+// tableColumns returns the columns of a table in the primary database, keyed by name. It returns no columns
+// if the table does not exist.
+func tableColumns(ctx context.Context, table string) (map[string]dbColumn, error) {
+	var query string
+	switch dialect {
+	case "sqlite":
+		// pragma_table_info reports INTEGER PRIMARY KEY columns as nullable, though they never hold NULL.
+		query = `SELECT name, type, "notnull" = 0 AND pk = 0, dflt_value IS NOT NULL OR pk > 0
+			FROM pragma_table_info(?)`
+	case "mysql":
+		query = `SELECT column_name, column_type, is_nullable = 'YES', column_default IS NOT NULL OR extra LIKE '%auto_increment%'
+			FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?`
+	default:
+		query = `SELECT column_name, data_type, is_nullable = 'YES', column_default IS NOT NULL OR is_identity = 'YES'
+			FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1`
+	}
+
+	rows, err := database.QueryContext(ctx, query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]dbColumn)
+	for rows.Next() {
+		var c dbColumn
+		var nullable, hasDefault sql.NullBool
+		if err := rows.Scan(&c.name, &c.dataType, &nullable, &hasDefault); err != nil {
+			return nil, err
+		}
+		c.name = strings.ToLower(c.name)
+		c.nullable, c.hasDefault = nullable.Bool, hasDefault.Bool
+		columns[c.name] = c
+	}
+	return columns, rows.Err()
+}
+
+// typeFamily groups a database column type with the others a Go type can be scanned from.
+func typeFamily(dataType string) string {
+	t := strings.ToLower(strings.TrimSpace(dataType))
+	if i := strings.IndexByte(t, '('); i >= 0 {
+		t = strings.TrimSpace(t[:i])
+	}
+	t = strings.TrimSuffix(t, " unsigned")
+	switch {
+	case t == "boolean" || t == "bool":
+		return "bool"
+	case t == "uuid":
+		return "uuid"
+	case t == "interval":
+		return t
+	case hasAnyPrefix(t, "int", "bigint", "smallint", "tinyint", "mediumint", "serial", "bigserial"):
+		return "integer"
+	case hasAnyPrefix(t, "text", "varchar", "character", "char", "tinytext", "mediumtext", "longtext", "citext", "nvarchar", "clob"):
+		return "text"
+	case hasAnyPrefix(t, "timestamp", "datetime", "date"):
+		return "time"
+	case hasAnyPrefix(t, "bytea", "blob", "binary", "varbinary", "tinyblob", "mediumblob", "longblob"):
+		return "binary"
+	case hasAnyPrefix(t, "real", "double", "float", "numeric", "decimal"):
+		return "float"
+	}
+	return t
+}
+
+// fieldTypeFamilies returns the column type families a field of Go type t can be stored in, or nil if any will do.
+func fieldTypeFamilies(t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == reflect.TypeOf(ID("")):
+		if keyType == KeySerial {
+			return []string{"integer"}
+		}
+		return []string{"uuid", "text", "binary"}
+	case t == reflect.TypeOf(time.Time{}):
+		return []string{"time"}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return []string{"binary"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return []string{"text", "uuid"}
+	case reflect.Bool:
+		return []string{"bool", "integer"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return []string{"integer", "bool"}
+	case reflect.Float32, reflect.Float64:
+		return []string{"float", "integer"}
+	}
+	return nil
+}
+
+// hasAnyPrefix reports whether s starts with any of the prefixes.
+func hasAnyPrefix(s string, prefixes ...string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether list holds s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jorgeSader/devify-test-app/migrations"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// TestCompareModel tests that matching columns pass and each kind of drift is reported.
+func TestCompareModel(t *testing.T) {
+	columns := map[string]dbColumn{
+		"id":         {name: "id", dataType: "integer", hasDefault: true},
+		"created_at": {name: "created_at", dataType: "timestamp without time zone"},
+		"updated_at": {name: "updated_at", dataType: "DATETIME"},
+		"version":    {name: "version", dataType: "int(11)"},
+	}
+	if drifts := compareModel(&TestModel{}, "test_models", columns); len(drifts) != 0 {
+		t.Errorf("matching table reported drift: %v", drifts)
+	}
+
+	type model struct {
+		ID       ID         `db:"id,omitempty"`
+		Name     string     `db:"name"`
+		Count    int        `db:"count"`
+		Seen     *time.Time `db:"seen_at"`
+		Missing  string     `db:"missing"`
+		Untagged string     `db:""`
+		hidden   string     `db:"hidden"`
+		Skipped  string     `db:"-"`
+	}
+	_ = model{}.hidden
+	columns = map[string]dbColumn{
+		"id":       {name: "id", dataType: "INTEGER", hasDefault: true},
+		"name":     {name: "name", dataType: "varchar(255)", nullable: true},
+		"count":    {name: "count", dataType: "text"},
+		"seen_at":  {name: "seen_at", dataType: "timestamp"},
+		"required": {name: "required", dataType: "text"},
+		"optional": {name: "optional", dataType: "text", nullable: true},
+	}
+	want := []string{
+		"m.name (model.Name): column is nullable",
+		"m.count (model.Count): column type text does not fit",
+		"m.seen_at (model.Seen): column is NOT NULL but the field is a pointer",
+		"m.missing (model.Missing): column is missing",
+		"m (model.Untagged): field has no column name",
+		"m.hidden (model.hidden): unexported field has a db tag",
+		"m.required: NOT NULL column without a default is not mapped",
+	}
+	drifts := compareModel(&model{}, "m", columns)
+	if len(drifts) != len(want) {
+		t.Fatalf("got %d drifts, want %d: %v", len(drifts), len(want), drifts)
+	}
+	for _, w := range want {
+		found := false
+		for _, d := range drifts {
+			found = found || strings.HasPrefix(d.String(), w)
+		}
+		if !found {
+			t.Errorf("missing drift %q in %v", w, drifts)
+		}
+	}
+}
+
+// TestTypeFamily tests that column types of each database map to the same family.
+func TestTypeFamily(t *testing.T) {
+	tests := map[string]string{
+		"integer":                  "integer",
+		"bigint unsigned":          "integer",
+		"tinyint(1)":               "integer",
+		"interval":                 "interval",
+		"character varying":        "text",
+		"varchar(255)":             "text",
+		"TEXT":                     "text",
+		"timestamp with time zone": "time",
+		"DATETIME":                 "time",
+		"bytea":                    "binary",
+		"varbinary(255)":           "binary",
+		"BLOB":                     "binary",
+		"boolean":                  "bool",
+		"uuid":                     "uuid",
+		"double precision":         "float",
+		"tsvector":                 "tsvector",
+	}
+	for in, want := range tests {
+		if got := typeFamily(in); got != want {
+			t.Errorf("typeFamily(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// TestCheckSchema_Migrated tests that a SQLite database migrated all the way up has no drift from
+// SchemaModels. It needs the sqlite_fts5 build tag for the full-text search migration.
+func TestCheckSchema_Migrated(t *testing.T) {
+	pool, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if _, err := pool.Exec("CREATE VIRTUAL TABLE fts5_probe USING fts5(body)"); err != nil {
+		t.Skipf("SQLite built without FTS5, run with -tags sqlite_fts5: %v", err)
+	}
+	m, err := migrations.New(pool, "sqlite", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err := m.Up(); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	oldDatabase, oldDialect := database, dialect
+	database, dialect = pool, "sqlite"
+	t.Cleanup(func() { database, dialect = oldDatabase, oldDialect })
+
+	drifts, err := CheckSchema(context.Background(), SchemaModels()...)
+	if err != nil {
+		t.Fatalf("CheckSchema() error = %v", err)
+	}
+	if len(drifts) != 0 {
+		t.Errorf("migrated database reported drift: %v", drifts)
+	}
+}
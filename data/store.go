@@ -0,0 +1,68 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrStaleObject is returned by Update when the record's version no longer matches the version the caller read,
+// meaning someone else changed the record in the meantime. Callers should reload the record and retry or report a conflict.
+var ErrStaleObject = errors.New("stale object: the record was changed by someone else")
+
+// ErrDuplicateEmail is returned by Insert, Update and Restore when another live user already has the email address.
+// Handlers check for it up front to explain the conflict, but two requests can still race to claim the same address.
+var ErrDuplicateEmail = errors.New("duplicate email")
+
+// UserStore is the set of user operations that handlers and middleware depend on.
+// The SQL-backed User model and the in-memory store both satisfy it.
+type UserStore interface {
+	Table() string
+	GetAll() ([]*User, error)
+	List(opts ListOptions) (*UserPage, error)
+	Search(query string, page int) (*UserPage, error)
+	GetByEmail(email string) (*User, error)
+	Get(id ID) (*User, error)
+	Update(user User) error // returns ErrStaleObject if user.Version is out of date; the password is left alone
+	Delete(id ID) error
+	Restore(id ID) error // returns ErrDuplicateEmail if a live user has since taken the email address
+	PurgeDeleted(olderThan time.Duration) (int, error)
+	Insert(user User) (ID, error) // returns ErrDuplicateEmail if a live user has the email address
+	ResetPassword(id ID, newPassword string) error
+	Import(ctx context.Context, r io.Reader, opts ImportOptions) (*ImportReport, error)
+	Export(ctx context.Context, w io.Writer, opts ExportOptions) (int, error)
+}
+
+// TokenStore is the set of token operations that handlers and middleware depend on.
+// The SQL-backed Token model and the in-memory store both satisfy it.
+type TokenStore interface {
+	Table() string
+	GetUserForToken(plainText string) (User, error)
+	GetTokensForUser(id ID) ([]*Token, error)
+	GetUnused(since time.Time) ([]*Token, error)
+	Get(id ID) (*Token, error)
+	GetByToken(plainText string) (*Token, error)
+	Delete(id ID) error
+	DeleteByToken(plainText string) error
+	Insert(token Token, user User) error
+	Add(token Token, user User) error
+	GenerateToken(userID ID, ttl time.Duration) (*Token, error)
+	AuthenticateToken(r *http.Request) (*User, error) // the user's Token is the one presented
+	ValidToken(plainText string) (bool, error)
+}
+
+// OneTimeTokenStore is the set of one-time token operations that handlers depend on.
+// The SQL-backed OneTimeToken model and the in-memory store both satisfy it.
+type OneTimeTokenStore interface {
+	Issue(purpose Purpose, user User, email string, ttl time.Duration) (string, error)
+	Consume(purpose Purpose, plainText string) (*OneTimeToken, error) // returns ErrOneTimeToken if not usable
+}
+
+// Compile-time checks that the SQL models satisfy the store interfaces.
+var (
+	_ UserStore         = (*User)(nil)
+	_ TokenStore        = (*Token)(nil)
+	_ OneTimeTokenStore = (*OneTimeToken)(nil)
+)
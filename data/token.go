@@ -1,91 +1,170 @@
 package data
 
 import (
-	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
-	"encoding/base32"
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
-	"strconv"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/upper/db/v4"
 )
 
-// TokenLength defines the length of generated tokens, configurable via the TOKEN_LENGTH environment variable.
-var TokenLength = 26
-
-func init() {
-	if tl := os.Getenv("TOKEN_LENGTH"); tl != "" {
-		if i, err := strconv.Atoi(tl); err == nil {
-			TokenLength = i
-		}
-	}
-}
-
 // Token represents a token entity in the database.
 type Token struct {
-	ID        int       `db:"id,omitempty"`
-	UserID    int       `db:"user_id"`
-	FirstName string    `db:"first_name"`
-	Email     string    `db:"email"`
-	plainText string    `db:""`
-	Hash      []byte    `db:"token_hash"`
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
-	Expires   time.Time `db:"expiry"`
+	ID         ID         `db:"id,omitempty"`
+	UserID     ID         `db:"user_id"`
+	FirstName  string     `db:"first_name"`
+	Email      string     `db:"email"`
+	plainText  string     `db:"-"`
+	Hash       []byte     `db:"token_hash"`
+	CreatedAt  time.Time  `db:"created_at"`
+	UpdatedAt  time.Time  `db:"updated_at"`
+	Expires    time.Time  `db:"expiry"`
+	Scopes     string     `db:"scopes"`       // space-separated scopes the token grants
+	Type       string     `db:"token_type"`   // selects the expiry policy, see ExpiryPolicy
+	Name       string     `db:"name"`         // set on personal access tokens
+	LastUsedAt *time.Time `db:"last_used_at"` // nil if the token was never used
+	LastUsedIP string     `db:"last_used_ip"`
+	UseCount   int64      `db:"use_count"`
+	scope      *ReadScope `db:"-"` // routes the reads of the store, see Models.WithReadScope
 }
 
+// Scopes a token can grant over the users API.
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+)
+
 // Table returns the database table name for the Token model.
 func (t *Token) Table() string {
 	return "tokens"
 }
 
+// HasScope reports whether the token grants scope.
+func (t *Token) HasScope(scope string) bool {
+	return slices.Contains(strings.Fields(t.Scopes), scope)
+}
+
 // GetUserForToken retrieves the user associated with a given token hash.
-// The token is hashed to match the stored token_hash in the database.
+// The token is hashed to match the stored token_hash in the database. Soft-deleted users are never returned.
 func (t *Token) GetUserForToken(plainText string) (User, error) {
-	var token Token
-	var user User
-	hash := sha256.Sum256([]byte(plainText))
-	row, err := upper.SQL().QueryRow("SELECT id, user_id, first_name, email, token_hash, created_at, updated_at, expiry FROM tokens WHERE token_hash = $1 LIMIT 1", hash[:])
-	err = row.Scan(&token.ID, &token.UserID, &token.FirstName, &token.Email, &token.Hash, &token.CreatedAt, &token.UpdatedAt, &token.Expires)
+	_, user, err := t.authenticate(plainText)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return user, fmt.Errorf("no matching user found")
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, db.ErrNoMoreRows) {
+			return User{}, fmt.Errorf("no matching user found")
+		}
+		return User{}, err
+	}
+	return *user, nil
+}
+
+// authQuery resolves a token hash to the token and its live user in one query on tokens_token_hash_idx.
+const authQuery = `SELECT t.id, t.user_id, t.first_name, t.email, t.token_hash, t.created_at, t.updated_at, t.expiry,
+		t.scopes, t.token_type, t.name, t.last_used_at, t.last_used_ip, t.use_count,
+		u.first_name, u.last_name, u.email, u.user_active, u.password, u.created_at, u.updated_at, u.version
+	FROM tokens t JOIN users u ON u.id = t.user_id
+	WHERE t.token_hash = ? AND u.deleted_at IS NULL
+	LIMIT 1`
+
+// authenticate returns a token, whether expired or not, and the user holding it, or sql.ErrNoRows if no live
+// user holds it. Without a cache this is a single query. With one, the cached token and user lookups are
+// used instead, since a cache hit needs no query at all.
+func (t *Token) authenticate(plainText string) (*Token, *User, error) {
+	if cache != nil {
+		token, err := t.GetByToken(plainText)
+		if err != nil {
+			return nil, nil, err
+		}
+		user, err := (&User{scope: t.scope}).Get(token.UserID)
+		if err != nil {
+			return nil, nil, err
 		}
-		return user, err
+		// The cached user carries their latest token, which need not be the one presented.
+		user.Token = *token
+		return token, user, nil
 	}
-	collection := upper.Collection("users")
-	res := collection.Find(db.Cond{"id": token.UserID})
-	err = res.One(&user)
+
+	if checkTokenFormat(plainText) != nil {
+		return nil, nil, sql.ErrNoRows
+	}
+	hash := sha256.Sum256([]byte(plainText))
+	var token Token
+	var user User
+	err := t.scope.read(func(sess db.Session) error {
+		row, err := sess.SQL().QueryRow(authQuery, hash[:])
+		if err != nil {
+			return err
+		}
+		return row.Scan(&token.ID, &token.UserID, &token.FirstName, &token.Email, &token.Hash, &token.CreatedAt, &token.UpdatedAt,
+			&token.Expires, &token.Scopes, &token.Type, &token.Name, &token.LastUsedAt, &token.LastUsedIP, &token.UseCount,
+			&user.FirstName, &user.LastName, &user.Email, &user.Active, &user.Password, &user.CreatedAt, &user.UpdatedAt, &user.Version)
+	})
 	if err != nil {
-		return user, fmt.Errorf("no matching user found")
+		return nil, nil, err
 	}
-	return user, nil
+	user.ID = token.UserID
+	user.Token = token
+	return &token, &user, nil
 }
 
 // GetTokensForUser retrieves all tokens associated with a given user ID.
-func (t *Token) GetTokensForUser(id int) ([]*Token, error) {
+func (t *Token) GetTokensForUser(id ID) ([]*Token, error) {
+	var tokens []*Token
+	err := t.scope.read(func(sess db.Session) error {
+		tokens = nil
+		return sess.Collection(t.Table()).Find(db.Cond{"user_id": id}).All(&tokens)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// GetUnused retrieves the unexpired tokens not used since the given time, least recently used first.
+// Tokens that were never used count from when they were created. Usage not yet flushed is not seen.
+func (t *Token) GetUnused(since time.Time) ([]*Token, error) {
 	var tokens []*Token
-	collection := upper.Collection(t.Table())
-	res := collection.Find(db.Cond{"user_id": id})
-	err := res.All(&tokens)
+	err := t.scope.read(func(sess db.Session) error {
+		tokens = nil
+		return sess.Collection(t.Table()).Find(
+			db.Cond{"expiry >": time.Now()},
+			db.Or(
+				db.And(db.Cond{"last_used_at IS": nil}, db.Cond{"created_at <": since}),
+				db.Cond{"last_used_at <": since},
+			),
+		).All(&tokens)
+	})
 	if err != nil {
 		return nil, err
 	}
+	sortByLastActive(tokens)
 	return tokens, nil
 }
 
+// lastActive returns when the token was last used, or created if it never was.
+func (t *Token) lastActive() time.Time {
+	if t.LastUsedAt != nil {
+		return *t.LastUsedAt
+	}
+	return t.CreatedAt
+}
+
+// sortByLastActive sorts tokens least recently used first.
+func sortByLastActive(tokens []*Token) {
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].lastActive().Before(tokens[j].lastActive()) })
+}
+
 // Get retrieves a token by its ID.
-func (t *Token) Get(id int) (*Token, error) {
+func (t *Token) Get(id ID) (*Token, error) {
 	var token Token
-	collection := upper.Collection(t.Table())
-	res := collection.Find(db.Cond{"id =": id})
-	err := res.One(&token)
+	err := t.scope.read(func(sess db.Session) error {
+		return sess.Collection(t.Table()).Find(db.Cond{"id =": id}).One(&token)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -93,84 +172,146 @@ func (t *Token) Get(id int) (*Token, error) {
 }
 
 // GetByToken retrieves a token by its plaintext value.
-// It hashes the token to match against the stored hash.
+// It hashes the token to match against the stored hash. Results are cached when a cache is configured.
+// Tokens that fail the format check are not found, without a query.
 func (t *Token) GetByToken(plainText string) (*Token, error) {
-	var token Token
-	hash := sha256.Sum256([]byte(plainText))
-	row, err := upper.SQL().QueryRow("SELECT id, user_id, first_name, email, token_hash, created_at, updated_at, expiry FROM tokens WHERE token_hash = $1 LIMIT 1", hash[:])
-	if err != nil {
-		return nil, err
-	}
-	err = row.Scan(&token.ID, &token.UserID, &token.FirstName, &token.Email, &token.Hash, &token.CreatedAt, &token.UpdatedAt, &token.Expires)
-	if err != nil {
-		return nil, err
+	if checkTokenFormat(plainText) != nil {
+		return nil, sql.ErrNoRows
 	}
-	return &token, nil
+	hash := sha256.Sum256([]byte(plainText))
+	return cached(tokenCacheKey(hash[:]), tokenCacheTTL, func() (*Token, error) {
+		var token Token
+		err := t.scope.read(func(sess db.Session) error {
+			row, err := sess.SQL().QueryRow("SELECT id, user_id, first_name, email, token_hash, created_at, updated_at, expiry, scopes, token_type, name, last_used_at, last_used_ip, use_count FROM tokens WHERE token_hash = ? LIMIT 1", hash[:])
+			if err != nil {
+				return err
+			}
+			return row.Scan(&token.ID, &token.UserID, &token.FirstName, &token.Email, &token.Hash, &token.CreatedAt, &token.UpdatedAt, &token.Expires, &token.Scopes, &token.Type, &token.Name, &token.LastUsedAt, &token.LastUsedIP, &token.UseCount)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &token, nil
+	})
 }
 
 // Delete removes a token from the database by its ID.
-func (t *Token) Delete(id int) error {
-	collection := upper.Collection(t.Table())
-	res := collection.Find(db.Cond{"id =": id})
-	err := res.Delete()
-	if err != nil {
-		return err
-	}
-	return nil
+func (t *Token) Delete(id ID) error {
+	return t.delete(db.Cond{"id =": id})
 }
 
 // DeleteByToken removes a token from the database based on its plaintext value.
 func (t *Token) DeleteByToken(plainText string) error {
 	hash := sha256.Sum256([]byte(plainText))
-	_, err := upper.SQL().Exec("DELETE FROM tokens WHERE token_hash = $1", hash[:])
-	return err
+	return t.delete(db.Cond{"token_hash =": hash[:]})
 }
 
-// Insert adds a new token to the database for a user.
-// It deletes existing tokens for the user first, then inserts the new one using the provided plaintext.
-func (t *Token) Insert(token Token, user User) error {
-	collection := upper.Collection(t.Table())
-	res := collection.Find(db.Cond{"user_id =": user.ID})
-	err := res.Delete()
+// delete removes the token matching cond, running any delete hooks, and drops it from the cache.
+func (t *Token) delete(cond db.Cond) error {
+	defer t.scope.wrote()
+	var token Token
+	load := func(tx db.Session) error {
+		return tx.Collection(t.Table()).Find(cond).One(&token)
+	}
+	var keys []string
+	if cache != nil {
+		var cached Token
+		if err := upper.Collection(t.Table()).Find(cond).One(&cached); err == nil {
+			keys = cached.cacheKeys()
+		}
+	}
+
+	err := withHooks(&token, hookDelete, load, func(sess db.Session) error {
+		return sess.Collection(t.Table()).Find(cond).Delete()
+	})
 	if err != nil {
 		return err
 	}
+	invalidate(keys...)
+	return nil
+}
+
+// cacheKeys returns the cache entries that hold the token: its own and its user's, which embeds the latest token.
+func (t *Token) cacheKeys() []string {
+	return []string{tokenCacheKey(t.Hash), userCacheKey(t.UserID)}
+}
 
+// Insert adds a new token to the database for a user.
+// It deletes the user's existing tokens first, apart from their personal access tokens, then inserts the new
+// one using the provided plaintext. Insert hooks run around both.
+func (t *Token) Insert(token Token, user User) error {
+	return t.insert(token, user, true)
+}
+
+// Add adds a new token to the database for a user, keeping their existing tokens. It is how personal access
+// tokens are created, since a user may hold several. Insert hooks run around it.
+func (t *Token) Add(token Token, user User) error {
+	return t.insert(token, user, false)
+}
+
+// insert stores token for user, first deleting the user's tokens other than personal access tokens if replace
+// is set.
+func (t *Token) insert(token Token, user User, replace bool) error {
+	defer t.scope.wrote()
 	token.CreatedAt = time.Now()
 	token.UpdatedAt = time.Now()
 	token.UserID = user.ID
 	token.FirstName = user.FirstName
 	token.Email = user.Email
+	if token.Type == "" {
+		token.Type = TokenTypeAPI
+	}
 	hash := sha256.Sum256([]byte(token.plainText))
 	token.Hash = hash[:]
+	if token.ID.IsZero() {
+		token.ID = NewID()
+	}
 
-	_, err = collection.Insert(token)
-	if err != nil {
+	keys := []string{userCacheKey(user.ID)}
+	err := withHooks(&token, hookInsert, nil, func(sess db.Session) error {
+		collection := sess.Collection(t.Table())
+		if replace {
+			res := collection.Find(db.Cond{"user_id =": user.ID, "token_type <>": TokenTypePersonal})
+			if cache != nil {
+				var existing []*Token
+				if err := res.All(&existing); err != nil {
+					return err
+				}
+				for _, old := range existing {
+					keys = append(keys, tokenCacheKey(old.Hash))
+				}
+			}
+			if err := res.Delete(); err != nil {
+				return err
+			}
+		}
+
+		r, err := collection.Insert(token)
+		if err != nil {
+			return err
+		}
+		if token.ID.IsZero() {
+			token.ID, err = InsertID(r.ID())
+		}
 		return err
-	}
-	return nil
+	})
+	invalidate(keys...)
+	return err
 }
 
-// GenerateToken creates a new token for a user with a specified time-to-live (TTL).
-// It returns the token struct or an error.
-func (t *Token) GenerateToken(userID int, ttl time.Duration) (*Token, error) {
+// GenerateToken creates a new token for a user with a specified time-to-live (TTL), in the dvf_ format
+// described at TokenPrefix. It returns the token struct or an error.
+func (t *Token) GenerateToken(userID ID, ttl time.Duration) (*Token, error) {
 	token := &Token{
 		UserID:  userID,
 		Expires: time.Now().Add(ttl),
+		Type:    TokenTypeAPI,
 	}
 
-	randomBytes := make([]byte, 16)
-	_, err := rand.Read(randomBytes)
+	plainText, err := newTokenText(TokenLength)
 	if err != nil {
 		return nil, err
 	}
-
-	plainText := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
-	if len(plainText) < TokenLength {
-		plainText = plainText + strings.Repeat("A", TokenLength-len(plainText))
-	} else if len(plainText) > TokenLength {
-		plainText = plainText[:TokenLength]
-	}
 	token.plainText = plainText
 
 	hash := sha256.Sum256([]byte(plainText))
@@ -178,57 +319,77 @@ func (t *Token) GenerateToken(userID int, ttl time.Duration) (*Token, error) {
 	return token, nil
 }
 
+// PlainText returns the plain text of a token made by GenerateToken. It is empty for tokens read back from
+// the database, which only keeps a hash, so it must be shown to the user before the token is discarded.
+func (t *Token) PlainText() string {
+	return t.plainText
+}
+
 // AuthenticateToken validates a token from an HTTP request’s Authorization header.
-// It returns the associated user if the token is valid and not expired.
+// It returns the associated user if the token is valid and not expired, and records the use of opaque
+// tokens when usage is tracked. Signed tokens are verified with the configured signing keys and the user is
+// built from their claims, without a database round trip. Either way the user's Token is the one presented,
+// so its scopes can be checked.
 func (t *Token) AuthenticateToken(r *http.Request) (*User, error) {
-	authorizationHeader := r.Header.Get("Authorization")
-	if authorizationHeader == "" {
-		return nil, errors.New("no authorization header received")
+	token, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+	if DetectTokenFormat(token) != TokenOpaque {
+		return authenticateSigned(token)
 	}
 
-	headerParts := strings.Split(authorizationHeader, " ")
-	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-		return nil, errors.New("invalid authorization header format")
+	tok, user, err := t.authenticate(token)
+	if err != nil {
+		return nil, errors.New("no matching token found")
 	}
 
-	token := headerParts[1]
-	if len(token) != TokenLength {
-		return nil, errors.New("invalid token length")
+	if err := t.use(tok); err != nil {
+		return nil, err
 	}
 
-	tok, err := t.GetByToken(token)
-	if err != nil {
-		return nil, errors.New("no matching token found")
+	if u := tokenUsage.Load(); u != nil {
+		u.record(tok.ID, clientIP(r))
 	}
+	return user, nil
+}
 
-	if tok.Expires.Before(time.Now()) {
-		return nil, errors.New("token has expired")
+// bearerToken extracts the plaintext token from an HTTP request’s Authorization header.
+// It returns an error if the header is missing or malformed, or an opaque token fails its format check.
+func bearerToken(r *http.Request) (string, error) {
+	authorizationHeader := r.Header.Get("Authorization")
+	if authorizationHeader == "" {
+		return "", errors.New("no authorization header received")
 	}
 
-	user, err := t.GetUserForToken(token)
-	if err != nil {
-		return nil, errors.New("no matching user found for token")
+	headerParts := strings.Split(authorizationHeader, " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return "", errors.New("invalid authorization header format")
 	}
 
-	return &user, nil
+	token := headerParts[1]
+	if DetectTokenFormat(token) == TokenOpaque {
+		if err := checkTokenFormat(token); err != nil {
+			return "", err
+		}
+	}
+	return token, nil
 }
 
-// ValidToken checks if a token is valid and not expired.
+// ValidToken checks if a token is valid and not expired, and slides its expiry like AuthenticateToken.
 // It returns true if valid, false otherwise, with an error on failure.
 func (t *Token) ValidToken(plainText string) (bool, error) {
-	_, err := t.GetUserForToken(plainText)
+	token, _, err := t.authenticate(plainText)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, db.ErrNoMoreRows) {
+			return false, fmt.Errorf("no matching user found")
+		}
 		return false, err
 	}
 
-	token, err := t.GetByToken(plainText)
-	if err != nil {
+	if err := t.use(token); err != nil {
 		return false, err
 	}
 
-	if token.Expires.Before(time.Now()) {
-		return false, errors.New("token has expired")
-	}
-
 	return true, nil
 }
@@ -0,0 +1,138 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/upper/db/v4"
+)
+
+// Token types. Each can be given its own ExpiryPolicy.
+const (
+	TokenTypeAPI      = "api"      // tokens issued to API clients, and tokens with no type
+	TokenTypePersonal = "personal" // named personal access tokens, created by users on their tokens page
+)
+
+// errTokenExpired is returned for tokens past their expiry or max lifetime.
+var errTokenExpired = errors.New("token has expired")
+
+// ExpiryPolicy is how the expiry of tokens of one type moves. With sliding expiration, each use pushes
+// expiry out to Sliding from now, but never past MaxLifetime from when the token was created. MaxLifetime
+// also caps tokens that do not slide, however long the TTL they were generated with.
+//
+// Expiry is only rewritten once less than half of Sliding remains, so a token in constant use costs one
+// write per Sliding/2 rather than one per request.
+type ExpiryPolicy struct {
+	Sliding     time.Duration // zero for a fixed expiry
+	MaxLifetime time.Duration // zero for no absolute limit
+}
+
+var (
+	expiryMu sync.RWMutex
+	// expiryPolicies are the policies of the token types that have one; other types keep a fixed expiry.
+	expiryPolicies map[string]ExpiryPolicy
+)
+
+// SetExpiryPolicies replaces the expiry policies, keyed by token type.
+func SetExpiryPolicies(policies map[string]ExpiryPolicy) {
+	expiryMu.Lock()
+	defer expiryMu.Unlock()
+	expiryPolicies = policies
+}
+
+// expiryPolicyFor returns the policy of a token type.
+func expiryPolicyFor(tokenType string) ExpiryPolicy {
+	if tokenType == "" {
+		tokenType = TokenTypeAPI
+	}
+	expiryMu.RLock()
+	defer expiryMu.RUnlock()
+	return expiryPolicies[tokenType]
+}
+
+// ParseExpiryPolicies parses a comma-separated list of type:sliding:max-lifetime entries, as found in the
+// TOKEN_EXPIRY_POLICIES environment variable, such as "api:24h:720h". Either duration may be 0.
+func ParseExpiryPolicies(s string) (map[string]ExpiryPolicy, error) {
+	policies := make(map[string]ExpiryPolicy)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid TOKEN_EXPIRY_POLICIES entry %q: want type:sliding:max-lifetime", entry)
+		}
+		sliding, err := time.ParseDuration(parts[1])
+		if err != nil || sliding < 0 {
+			return nil, fmt.Errorf("invalid sliding expiration for %s tokens: %s", parts[0], parts[1])
+		}
+		maxLifetime, err := time.ParseDuration(parts[2])
+		if err != nil || maxLifetime < 0 {
+			return nil, fmt.Errorf("invalid max lifetime for %s tokens: %s", parts[0], parts[2])
+		}
+		if maxLifetime > 0 && sliding > maxLifetime {
+			return nil, fmt.Errorf("sliding expiration of %s tokens is longer than their max lifetime", parts[0])
+		}
+		policies[parts[0]] = ExpiryPolicy{Sliding: sliding, MaxLifetime: maxLifetime}
+	}
+	return policies, nil
+}
+
+// expiryPoliciesFromEnv reads the expiry policies from TOKEN_EXPIRY_POLICIES.
+func expiryPoliciesFromEnv() (map[string]ExpiryPolicy, error) {
+	return ParseExpiryPolicies(os.Getenv("TOKEN_EXPIRY_POLICIES"))
+}
+
+// ExpiresAt returns when the token stops being valid: its expiry, capped by the max lifetime of its type.
+func (t *Token) ExpiresAt() time.Time {
+	policy := expiryPolicyFor(t.Type)
+	if policy.MaxLifetime > 0 {
+		if limit := t.CreatedAt.Add(policy.MaxLifetime); limit.Before(t.Expires) {
+			return limit
+		}
+	}
+	return t.Expires
+}
+
+// slidTo returns the expiry a use at now extends the token to, and false if it should not be rewritten:
+// its type does not slide, the absolute limit is reached, or more than half of the sliding window remains.
+// Close to the limit, expiry moves straight to it in one last write.
+func (t *Token) slidTo(now time.Time) (time.Time, bool) {
+	policy := expiryPolicyFor(t.Type)
+	if policy.Sliding <= 0 {
+		return time.Time{}, false
+	}
+	expires := now.Add(policy.Sliding)
+	if policy.MaxLifetime > 0 {
+		if limit := t.CreatedAt.Add(policy.MaxLifetime); limit.Before(expires) {
+			return limit, limit.After(t.Expires)
+		}
+	}
+	return expires, expires.Sub(t.Expires) > policy.Sliding/2
+}
+
+// use checks that token has not expired and slides its expiry according to its type's policy. Extending
+// the expiry is best effort: if the write fails, a later use retries it.
+func (t *Token) use(token *Token) error {
+	now := time.Now()
+	if !token.ExpiresAt().After(now) {
+		return errTokenExpired
+	}
+	expires, ok := token.slidTo(now)
+	if !ok {
+		return nil
+	}
+	// Only ever move expiry forward, in case another instance has extended it further meanwhile.
+	_, err := upper.SQL().Update(t.Table()).Set("expiry", expires).
+		Where(db.Cond{"id": token.ID, "expiry <": expires}).Exec()
+	if err == nil {
+		token.Expires = expires
+		invalidate(tokenCacheKey(token.Hash))
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// setExpiryPolicies installs policies for the duration of the test.
+func setExpiryPolicies(t *testing.T, policies map[string]ExpiryPolicy) {
+	t.Helper()
+	SetExpiryPolicies(policies)
+	t.Cleanup(func() { SetExpiryPolicies(nil) })
+}
+
+func TestParseExpiryPolicies(t *testing.T) {
+	policies, err := ParseExpiryPolicies("api:24h:720h, session:30m:0")
+	if err != nil {
+		t.Fatalf("ParseExpiryPolicies() error = %v", err)
+	}
+	if got := policies["api"]; got.Sliding != 24*time.Hour || got.MaxLifetime != 720*time.Hour {
+		t.Errorf("api policy = %+v", got)
+	}
+	if got := policies["session"]; got.Sliding != 30*time.Minute || got.MaxLifetime != 0 {
+		t.Errorf("session policy = %+v", got)
+	}
+	if policies, err := ParseExpiryPolicies(""); err != nil || len(policies) != 0 {
+		t.Errorf("ParseExpiryPolicies(\"\") = %v, %v, want no policies", policies, err)
+	}
+
+	for _, spec := range []string{"api", "api:24h", ":1h:2h", "api:soon:1h", "api:1h:-1h", "api:48h:24h"} {
+		if _, err := ParseExpiryPolicies(spec); err == nil {
+			t.Errorf("ParseExpiryPolicies(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+// TestToken_ExpiresAt tests that the max lifetime caps expiry, and that types without a policy keep theirs.
+func TestToken_ExpiresAt(t *testing.T) {
+	setExpiryPolicies(t, map[string]ExpiryPolicy{"api": {MaxLifetime: 24 * time.Hour}})
+	created := time.Now().Add(-time.Hour)
+	token := Token{CreatedAt: created, Expires: created.Add(48 * time.Hour)}
+
+	if got, want := token.ExpiresAt(), created.Add(24*time.Hour); !got.Equal(want) {
+		t.Errorf("ExpiresAt() of an untyped token = %s, want the api limit %s", got, want)
+	}
+	token.Type = "legacy"
+	if got := token.ExpiresAt(); !got.Equal(token.Expires) {
+		t.Errorf("ExpiresAt() with no policy = %s, want %s", got, token.Expires)
+	}
+}
+
+// TestToken_SlidTo tests that expiry is only rewritten once half the sliding window has passed, and never
+// past the max lifetime.
+func TestToken_SlidTo(t *testing.T) {
+	setExpiryPolicies(t, map[string]ExpiryPolicy{
+		"api":   {Sliding: 10 * time.Hour, MaxLifetime: 30 * time.Hour},
+		"fixed": {MaxLifetime: 30 * time.Hour},
+	})
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		token     Token
+		want      time.Time
+		wantSlide bool
+	}{
+		{"fresh", Token{Type: "api", CreatedAt: now, Expires: now.Add(9 * time.Hour)}, time.Time{}, false},
+		{"half used", Token{Type: "api", CreatedAt: now, Expires: now.Add(4 * time.Hour)}, now.Add(10 * time.Hour), true},
+		{"near limit", Token{Type: "api", CreatedAt: now.Add(-25 * time.Hour), Expires: now.Add(time.Hour)}, now.Add(5 * time.Hour), true},
+		{"at limit", Token{Type: "api", CreatedAt: now.Add(-29 * time.Hour), Expires: now.Add(time.Hour)}, time.Time{}, false},
+		{"fixed", Token{Type: "fixed", CreatedAt: now, Expires: now.Add(time.Minute)}, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.token.slidTo(now)
+			if ok != tt.wantSlide || (ok && !got.Equal(tt.want)) {
+				t.Errorf("slidTo() = %s, %t, want %s, %t", got, ok, tt.want, tt.wantSlide)
+			}
+		})
+	}
+}
+
+// TestToken_Use tests that a use slides expiry with a single conditional update, and that an expired token
+// writes nothing.
+func TestToken_Use(t *testing.T) {
+	mock := newMockSession(t)
+	setExpiryPolicies(t, map[string]ExpiryPolicy{"api": {Sliding: 24 * time.Hour, MaxLifetime: 720 * time.Hour}})
+	now := time.Now()
+
+	token := &Token{ID: "7", Type: "api", CreatedAt: now.Add(-time.Hour), Expires: now.Add(time.Hour)}
+	mock.ExpectExec(`UPDATE "tokens" SET "expiry" = \$1 WHERE \("expiry" < \$2 AND "id" = \$3\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := (&Token{}).use(token); err != nil {
+		t.Fatalf("use() error = %v", err)
+	}
+	if d := time.Until(token.Expires); d < 23*time.Hour {
+		t.Errorf("use() left the token expiring in %s, want about 24h", d)
+	}
+
+	expired := &Token{ID: "8", Type: "api", CreatedAt: now.Add(-721 * time.Hour), Expires: now.Add(time.Hour)}
+	if err := (&Token{}).use(expired); !errors.Is(err, errTokenExpired) {
+		t.Errorf("use() past the max lifetime error = %v, want errTokenExpired", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMemoryTokens_SlidingExpiry tests that ValidToken extends a stored token like AuthenticateToken does.
+func TestMemoryTokens_SlidingExpiry(t *testing.T) {
+	m := newMemoryModels(t)
+	setExpiryPolicies(t, map[string]ExpiryPolicy{"api": {Sliding: 24 * time.Hour, MaxLifetime: 720 * time.Hour}})
+
+	id, err := m.Users.Insert(User{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Active: 1, Password: "secret"})
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	user, _ := m.Users.Get(id)
+	token, _ := m.Tokens.GenerateToken(id, time.Hour)
+	if err := m.Tokens.Insert(*token, *user); err != nil {
+		t.Fatalf("failed to insert token: %v", err)
+	}
+
+	if ok, err := m.Tokens.ValidToken(token.plainText); !ok || err != nil {
+		t.Fatalf("ValidToken() = %t, %v", ok, err)
+	}
+	stored, err := m.Tokens.GetByToken(token.plainText)
+	if err != nil {
+		t.Fatalf("GetByToken() error = %v", err)
+	}
+	if d := time.Until(stored.Expires); d < 23*time.Hour {
+		t.Errorf("stored token expires in %s after use, want about 24h", d)
+	}
+	if stored.Type != TokenTypeAPI {
+		t.Errorf("stored token type = %q, want %q", stored.Type, TokenTypeAPI)
+	}
+}
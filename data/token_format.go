@@ -0,0 +1,146 @@
+package data
+
+import (
+	"crypto/rand"
+	"errors"
+	"hash/crc32"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TokenPrefix starts every opaque token, so secret scanners can recognise leaked ones. A token is
+// TokenPrefix, TokenLength random base62 characters, an underscore and a CRC-32 checksum of everything
+// before it, as six base62 characters: dvf_<random>_<checksum>.
+const TokenPrefix = "dvf_"
+
+// MinTokenLength is the shortest random part accepted, about 131 bits of entropy.
+const MinTokenLength = 22
+
+// TokenLength is the number of random characters in new tokens, configurable via the TOKEN_LENGTH
+// environment variable. Changing it does not invalidate tokens already issued.
+var TokenLength = 32
+
+// legacyTokensUntil ends the migration window for tokens issued before the prefixed format, set by the
+// LEGACY_TOKENS_UNTIL environment variable as a date or RFC 3339 time. Legacy tokens are accepted while it
+// is zero, and rejected without a query after it.
+var legacyTokensUntil time.Time
+
+// Errors for bearer tokens that are rejected by their shape alone, before any lookup.
+var (
+	ErrMalformedToken = errors.New("malformed token")
+	ErrTokenChecksum  = errors.New("invalid token checksum")
+	ErrLegacyToken    = errors.New("legacy tokens are no longer accepted")
+)
+
+const (
+	base62              = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	tokenChecksumLength = 6
+	maxLegacyTokenLen   = 64
+)
+
+func init() {
+	if tl := os.Getenv("TOKEN_LENGTH"); tl != "" {
+		if i, err := strconv.Atoi(tl); err == nil && i >= MinTokenLength {
+			TokenLength = i
+		} else {
+			log.Printf("ignoring TOKEN_LENGTH %q: must be a number of at least %d", tl, MinTokenLength)
+		}
+	}
+	if v := os.Getenv("LEGACY_TOKENS_UNTIL"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, v)
+		}
+		if err != nil {
+			log.Printf("ignoring LEGACY_TOKENS_UNTIL %q: want a date such as 2026-12-31", v)
+		}
+		legacyTokensUntil = t
+	}
+}
+
+// newTokenText returns the plain text of a new token with n random characters.
+func newTokenText(n int) (string, error) {
+	return newSecretText(TokenPrefix, n)
+}
+
+// newSecretText returns prefix, n random base62 characters, an underscore and the checksum of everything
+// before it.
+func newSecretText(prefix string, n int) (string, error) {
+	// Rejection sampling keeps every character equally likely: bytes from 248 up would favour the
+	// first characters of the alphabet.
+	const limit = 256 - 256%len(base62)
+	random := make([]byte, 0, n)
+	buf := make([]byte, n+n/4)
+	for len(random) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(random) < n {
+				random = append(random, base62[int(b)%len(base62)])
+			}
+		}
+	}
+	body := prefix + string(random)
+	return body + "_" + tokenChecksum(body), nil
+}
+
+// tokenChecksum returns the CRC-32 of body as six base62 characters.
+func tokenChecksum(body string) string {
+	sum := crc32.ChecksumIEEE([]byte(body))
+	var out [tokenChecksumLength]byte
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = base62[sum%uint32(len(base62))]
+		sum /= uint32(len(base62))
+	}
+	return string(out[:])
+}
+
+// checkTokenFormat rejects an opaque token that cannot have been issued, so no query is spent on it:
+// a prefixed token needs a valid checksum, and an unprefixed legacy token must still be in its migration window.
+func checkTokenFormat(plainText string) error {
+	if !strings.HasPrefix(plainText, TokenPrefix) {
+		return checkLegacyToken(plainText)
+	}
+	return checkSecretFormat(TokenPrefix, plainText)
+}
+
+// checkSecretFormat checks a secret made by newSecretText with prefix.
+func checkSecretFormat(prefix, plainText string) error {
+	rest, ok := strings.CutPrefix(plainText, prefix)
+	if !ok {
+		return ErrMalformedToken
+	}
+	body, checksum, ok := strings.Cut(rest, "_")
+	if !ok || len(body) < MinTokenLength || len(checksum) != tokenChecksumLength || !isBase62(body) {
+		return ErrMalformedToken
+	}
+	if tokenChecksum(prefix+body) != checksum {
+		return ErrTokenChecksum
+	}
+	return nil
+}
+
+// checkLegacyToken checks a token from before the prefixed format, which was a bare alphanumeric string.
+func checkLegacyToken(plainText string) error {
+	if plainText == "" || len(plainText) > maxLegacyTokenLen || !isBase62(plainText) {
+		return ErrMalformedToken
+	}
+	if !legacyTokensUntil.IsZero() && time.Now().After(legacyTokensUntil) {
+		return ErrLegacyToken
+	}
+	return nil
+}
+
+// isBase62 reports whether s consists of ASCII letters and digits only.
+func isBase62(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !strings.ContainsRune(base62, rune(s[i])) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package data
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenFormat(t *testing.T) {
+	token, err := (&Token{}).GenerateToken("1", time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateToken() error = %v", err)
+	}
+	plainText := token.plainText
+	if want := len(TokenPrefix) + TokenLength + 1 + tokenChecksumLength; len(plainText) != want || !strings.HasPrefix(plainText, TokenPrefix) {
+		t.Fatalf("GenerateToken() = %q, want %d characters starting with %s", plainText, want, TokenPrefix)
+	}
+	if DetectTokenFormat(plainText) != TokenOpaque {
+		t.Errorf("DetectTokenFormat(%q) = %s, want opaque", plainText, DetectTokenFormat(plainText))
+	}
+
+	body := plainText[:len(plainText)-tokenChecksumLength-1]
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"generated", plainText, nil},
+		{"bad checksum", body + "_000000", ErrTokenChecksum},
+		{"changed body", TokenPrefix + "X" + plainText[len(TokenPrefix)+1:], ErrTokenChecksum},
+		{"short body", TokenPrefix + "abc_" + tokenChecksum(TokenPrefix+"abc"), ErrMalformedToken},
+		{"no checksum", body, ErrMalformedToken},
+		{"symbols", TokenPrefix + strings.Repeat("-", TokenLength) + "_000000", ErrMalformedToken},
+		{"legacy", "FIXTUREJANEAPITOKEN0000000", nil},
+		{"legacy symbols", "not a token", ErrMalformedToken},
+		{"empty", "", ErrMalformedToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "changed body" && plainText[len(TokenPrefix)] == 'X' {
+				t.Skip("generated token already starts with X")
+			}
+			if err := checkTokenFormat(tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("checkTokenFormat(%q) = %v, want %v", tt.token, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestTokenFormat_LegacyWindow tests that unprefixed tokens are refused once the migration window closes.
+func TestTokenFormat_LegacyWindow(t *testing.T) {
+	defer func(until time.Time) { legacyTokensUntil = until }(legacyTokensUntil)
+
+	legacyTokensUntil = time.Now().Add(time.Hour)
+	if err := checkTokenFormat("FIXTUREJANEAPITOKEN0000000"); err != nil {
+		t.Errorf("checkTokenFormat() inside the window = %v", err)
+	}
+	legacyTokensUntil = time.Now().Add(-time.Hour)
+	if err := checkTokenFormat("FIXTUREJANEAPITOKEN0000000"); !errors.Is(err, ErrLegacyToken) {
+		t.Errorf("checkTokenFormat() after the window = %v, want ErrLegacyToken", err)
+	}
+}
+
+// TestTokenFormat_NoQuery tests that a token with a bad checksum is rejected without querying the database.
+func TestTokenFormat_NoQuery(t *testing.T) {
+	mock := newMockSession(t)
+	plainText := TokenPrefix + strings.Repeat("a", TokenLength) + "_000000"
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+plainText)
+	if _, err := (&Token{}).AuthenticateToken(r); !errors.Is(err, ErrTokenChecksum) {
+		t.Errorf("AuthenticateToken() error = %v, want ErrTokenChecksum", err)
+	}
+	if valid, _ := (&Token{}).ValidToken(plainText); valid {
+		t.Error("ValidToken() = true for a token with a bad checksum")
+	}
+	if _, err := (&Token{}).GetByToken(plainText); err == nil {
+		t.Error("GetByToken() found a token with a bad checksum")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,213 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/upper/db/v4"
+)
+
+// TokenFormat is the wire format of a bearer token.
+type TokenFormat string
+
+// Token formats. Opaque tokens are random strings looked up by hash in the tokens table; JWT and PASETO
+// tokens are signed and carry their own claims, so verifying them needs no database round trip.
+const (
+	TokenOpaque TokenFormat = "opaque"
+	TokenJWT    TokenFormat = "jwt"
+	TokenPASETO TokenFormat = "paseto"
+)
+
+// pasetoPrefix is the header of every PASETO v4 public token.
+const pasetoPrefix = "v4.public."
+
+// ErrInvalidToken is returned when a token is malformed, has a bad signature, has expired or was revoked.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// DetectTokenFormat tells the format of a token from its shape: PASETO tokens start with their version
+// header, JWTs are three dot-separated segments, and anything else is treated as opaque.
+func DetectTokenFormat(plainText string) TokenFormat {
+	switch {
+	case strings.HasPrefix(plainText, pasetoPrefix):
+		return TokenPASETO
+	case strings.Count(plainText, ".") == 2:
+		return TokenJWT
+	default:
+		return TokenOpaque
+	}
+}
+
+// TokenClaims is what a verified token says about its bearer.
+type TokenClaims struct {
+	ID        string // token ID: the row ID of an opaque token, the jti of a signed one
+	UserID    ID
+	FirstName string
+	Email     string
+	Scopes    string // space-separated
+	IssuedAt  time.Time
+	Expires   time.Time
+}
+
+// user returns the user the claims describe, holding a token with the claimed scopes. It has only the fields
+// a token carries.
+func (c *TokenClaims) user() *User {
+	return &User{
+		ID:        c.UserID,
+		FirstName: c.FirstName,
+		Email:     c.Email,
+		Active:    1,
+		Token:     Token{UserID: c.UserID, FirstName: c.FirstName, Email: c.Email, Scopes: c.Scopes, CreatedAt: c.IssuedAt, Expires: c.Expires},
+	}
+}
+
+// TokenIssuer issues and verifies bearer tokens of one format.
+type TokenIssuer interface {
+	// Format returns the format of the tokens the issuer issues.
+	Format() TokenFormat
+	// Issue returns a new token for user, valid for ttl and granting scopes.
+	Issue(user User, ttl time.Duration, scopes string) (string, error)
+	// Verify checks a token and returns its claims, or ErrInvalidToken if it is not valid.
+	Verify(plainText string) (*TokenClaims, error)
+	// Revoke makes a valid token fail verification from now on.
+	Revoke(plainText string) error
+}
+
+// OpaqueIssuer issues the random tokens stored by hash in a TokenStore. Issuing one replaces the user's
+// other tokens, as TokenStore.Insert does.
+type OpaqueIssuer struct {
+	Tokens TokenStore
+}
+
+// Format returns TokenOpaque.
+func (o OpaqueIssuer) Format() TokenFormat { return TokenOpaque }
+
+// Issue generates and stores a token for user.
+func (o OpaqueIssuer) Issue(user User, ttl time.Duration, scopes string) (string, error) {
+	token, err := o.Tokens.GenerateToken(user.ID, ttl)
+	if err != nil {
+		return "", err
+	}
+	token.Scopes = scopes
+	if err := o.Tokens.Insert(*token, user); err != nil {
+		return "", err
+	}
+	return token.plainText, nil
+}
+
+// Verify looks the token up and checks that it has not expired.
+func (o OpaqueIssuer) Verify(plainText string) (*TokenClaims, error) {
+	if DetectTokenFormat(plainText) != TokenOpaque {
+		return nil, ErrInvalidToken
+	}
+	token, err := o.Tokens.GetByToken(plainText)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, db.ErrNoMoreRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if !token.ExpiresAt().After(time.Now()) {
+		return nil, ErrInvalidToken
+	}
+	return &TokenClaims{
+		ID:        token.ID.String(),
+		UserID:    token.UserID,
+		FirstName: token.FirstName,
+		Email:     token.Email,
+		Scopes:    token.Scopes,
+		IssuedAt:  token.CreatedAt,
+		Expires:   token.ExpiresAt(),
+	}, nil
+}
+
+// Revoke deletes the token.
+func (o OpaqueIssuer) Revoke(plainText string) error {
+	return o.Tokens.DeleteByToken(plainText)
+}
+
+// signedTokens verifies JWT and PASETO bearer tokens, or is nil if no signing keys are configured and
+// only opaque tokens are accepted.
+var signedTokens *SignedIssuer
+
+// SetSignedIssuer replaces the issuer that verifies signed bearer tokens. A nil issuer rejects them.
+func SetSignedIssuer(s *SignedIssuer) {
+	signedTokens = s
+}
+
+// VerifySignedToken verifies a JWT or PASETO token with the configured signing keys and returns its claims.
+func VerifySignedToken(plainText string) (*TokenClaims, error) {
+	if signedTokens == nil {
+		return nil, fmt.Errorf("signed tokens are not accepted: %w", ErrInvalidToken)
+	}
+	return signedTokens.Verify(plainText)
+}
+
+// authenticateSigned returns the user a signed token was issued to, built from its claims without
+// reading the database.
+func authenticateSigned(plainText string) (*User, error) {
+	claims, err := VerifySignedToken(plainText)
+	if err != nil {
+		return nil, err
+	}
+	return claims.user(), nil
+}
+
+// tokenIssuerFromEnv builds the issuer selected by TOKEN_FORMAT ("opaque", the default, "jwt" or "paseto")
+// and the verifier for signed tokens. Signing keys are read from TOKEN_SIGNING_KEYS, a comma-separated list
+// of kid:alg:base64-key entries, of which TOKEN_SIGNING_KEY_ID names the one new tokens are signed with.
+// TOKEN_DENY_LIST enables revocation of signed tokens: "memory" keeps revoked token IDs in process and
+// "cache" keeps them in the configured cache. The verifier is built whenever keys are configured, so signed
+// tokens issued before switching back to opaque ones stay valid until they expire.
+func tokenIssuerFromEnv(tokens TokenStore) (TokenIssuer, *SignedIssuer, error) {
+	format := TokenFormat(strings.ToLower(os.Getenv("TOKEN_FORMAT")))
+	switch format {
+	case "":
+		format = TokenOpaque
+	case TokenOpaque, TokenJWT, TokenPASETO:
+	default:
+		return nil, nil, fmt.Errorf("unknown TOKEN_FORMAT: %s", format)
+	}
+
+	spec := os.Getenv("TOKEN_SIGNING_KEYS")
+	if spec == "" {
+		if format != TokenOpaque {
+			return nil, nil, fmt.Errorf("TOKEN_FORMAT %s needs TOKEN_SIGNING_KEYS", format)
+		}
+		return OpaqueIssuer{Tokens: tokens}, nil, nil
+	}
+	keys, err := ParseSigningKeys(spec, os.Getenv("TOKEN_SIGNING_KEY_ID"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var deny DenyList
+	switch v := strings.ToLower(os.Getenv("TOKEN_DENY_LIST")); v {
+	case "", "off", "none":
+	case "memory":
+		deny = NewMemoryDenyList()
+	case "cache":
+		if cache == nil {
+			return nil, nil, errors.New("TOKEN_DENY_LIST cache needs CACHE to be set")
+		}
+		deny = CacheDenyList{Cache: cache}
+	default:
+		return nil, nil, fmt.Errorf("unknown TOKEN_DENY_LIST: %s", v)
+	}
+
+	signedFormat := format
+	if signedFormat == TokenOpaque {
+		signedFormat = TokenJWT
+	}
+	signed, err := NewSignedIssuer(signedFormat, keys, deny)
+	if err != nil {
+		return nil, nil, err
+	}
+	if format == TokenOpaque {
+		return OpaqueIssuer{Tokens: tokens}, signed, nil
+	}
+	return signed, signed, nil
+}
@@ -0,0 +1,451 @@
+package data
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signing algorithms. HS256 keys are shared secrets, so every service that verifies tokens can also mint
+// them; EdDSA (Ed25519) keys are needed for PASETO v4 public tokens.
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// minHMACKeyLength is the shortest HS256 secret accepted, in bytes.
+const minHMACKeyLength = 32
+
+// b64 is the unpadded base64url encoding JWT and PASETO use.
+var b64 = base64.RawURLEncoding
+
+// SigningKey is a key signed tokens are signed and verified with, identified by its key ID.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	secret    []byte             // HS256
+	private   ed25519.PrivateKey // EdDSA
+}
+
+// NewSigningKey returns a key with the given ID. For HS256, material is a secret of at least 32 bytes;
+// for EdDSA, it is a 32-byte Ed25519 seed.
+func NewSigningKey(id, alg string, material []byte) (SigningKey, error) {
+	if id == "" {
+		return SigningKey{}, errors.New("signing key has no ID")
+	}
+	switch alg {
+	case AlgHS256:
+		if len(material) < minHMACKeyLength {
+			return SigningKey{}, fmt.Errorf("signing key %s: HS256 secret must be at least %d bytes", id, minHMACKeyLength)
+		}
+		return SigningKey{ID: id, Algorithm: alg, secret: material}, nil
+	case AlgEdDSA:
+		if len(material) != ed25519.SeedSize {
+			return SigningKey{}, fmt.Errorf("signing key %s: Ed25519 seed must be %d bytes", id, ed25519.SeedSize)
+		}
+		return SigningKey{ID: id, Algorithm: alg, private: ed25519.NewKeyFromSeed(material)}, nil
+	default:
+		return SigningKey{}, fmt.Errorf("signing key %s: unknown algorithm %s", id, alg)
+	}
+}
+
+// sign returns the signature of msg.
+func (k SigningKey) sign(msg []byte) []byte {
+	if k.Algorithm == AlgEdDSA {
+		return ed25519.Sign(k.private, msg)
+	}
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+// verify reports whether sig is the signature of msg.
+func (k SigningKey) verify(msg, sig []byte) bool {
+	if k.Algorithm == AlgEdDSA {
+		return ed25519.Verify(k.private.Public().(ed25519.PublicKey), msg, sig)
+	}
+	return hmac.Equal(k.sign(msg), sig)
+}
+
+// SigningKeys is a key set: new tokens are signed with the current key, and tokens signed with any key in
+// the set verify. To rotate, add a new key and make it current; drop the old one once the tokens it
+// signed have expired.
+type SigningKeys struct {
+	current SigningKey
+	byID    map[string]SigningKey
+}
+
+// NewSigningKeys returns a key set signing with the key named currentID, or with the first key if
+// currentID is empty.
+func NewSigningKeys(currentID string, keys ...SigningKey) (*SigningKeys, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	if currentID == "" {
+		currentID = keys[0].ID
+	}
+	ks := &SigningKeys{byID: make(map[string]SigningKey, len(keys))}
+	for _, k := range keys {
+		if _, dup := ks.byID[k.ID]; dup {
+			return nil, fmt.Errorf("duplicate signing key ID %s", k.ID)
+		}
+		ks.byID[k.ID] = k
+	}
+	current, ok := ks.byID[currentID]
+	if !ok {
+		return nil, fmt.Errorf("no signing key with ID %s", currentID)
+	}
+	ks.current = current
+	return ks, nil
+}
+
+// ParseSigningKeys parses a comma-separated list of kid:alg:base64-key entries, as found in the
+// TOKEN_SIGNING_KEYS environment variable, into a key set signing with the key named currentID.
+func ParseSigningKeys(spec, currentID string) (*SigningKeys, error) {
+	var keys []SigningKey
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid TOKEN_SIGNING_KEYS entry for %s: want kid:alg:base64-key", parts[0])
+		}
+		material, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid TOKEN_SIGNING_KEYS entry for %s: key is not base64", parts[0])
+		}
+		k, err := NewSigningKey(parts[0], parts[1], material)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return NewSigningKeys(currentID, keys...)
+}
+
+// DenyList records revoked signed tokens by ID until they expire, since a signed token cannot be deleted.
+type DenyList interface {
+	// Deny marks token id as revoked until the given time.
+	Deny(id string, until time.Time) error
+	// Denied reports whether token id was revoked.
+	Denied(id string) (bool, error)
+}
+
+// CacheDenyList keeps revoked token IDs in a Cache. Use a shared cache such as Redis when several instances
+// verify tokens, and one that does not evict entries early, or revoked tokens become valid again.
+type CacheDenyList struct {
+	Cache Cache
+}
+
+// Deny stores id until the token expires.
+func (d CacheDenyList) Deny(id string, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+	return d.Cache.Set("denied-token:"+id, []byte{1}, ttl)
+}
+
+// Denied reports whether id is stored.
+func (d CacheDenyList) Denied(id string) (bool, error) {
+	_, ok, err := d.Cache.Get("denied-token:" + id)
+	return ok, err
+}
+
+// MemoryDenyList keeps revoked token IDs in process, for single-instance deployments and tests.
+type MemoryDenyList struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+// NewMemoryDenyList returns an empty in-process deny list.
+func NewMemoryDenyList() *MemoryDenyList {
+	return &MemoryDenyList{ids: make(map[string]time.Time)}
+}
+
+// Deny records id until the given time, forgetting tokens that have expired since.
+func (d *MemoryDenyList) Deny(id string, until time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for k, exp := range d.ids {
+		if !exp.After(now) {
+			delete(d.ids, k)
+		}
+	}
+	d.ids[id] = until
+	return nil
+}
+
+// Denied reports whether id was revoked and has not expired yet.
+func (d *MemoryDenyList) Denied(id string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	until, ok := d.ids[id]
+	return ok && until.After(time.Now()), nil
+}
+
+// SignedIssuer issues JWT or PASETO v4 public tokens and verifies tokens of both formats. The key ID is
+// carried in the JWT header and the PASETO footer, so tokens signed before a key rotation still verify.
+type SignedIssuer struct {
+	format TokenFormat
+	keys   *SigningKeys
+	deny   DenyList
+}
+
+// NewSignedIssuer returns an issuer of tokens in the given format, signed with the current key of keys.
+// Revoked tokens are recorded in deny; if it is nil, tokens cannot be revoked and stay valid until they
+// expire.
+func NewSignedIssuer(format TokenFormat, keys *SigningKeys, deny DenyList) (*SignedIssuer, error) {
+	switch format {
+	case TokenJWT:
+	case TokenPASETO:
+		if keys.current.Algorithm != AlgEdDSA {
+			return nil, fmt.Errorf("PASETO v4 tokens need an %s signing key, and %s is %s", AlgEdDSA, keys.current.ID, keys.current.Algorithm)
+		}
+	default:
+		return nil, fmt.Errorf("%s is not a signed token format", format)
+	}
+	return &SignedIssuer{format: format, keys: keys, deny: deny}, nil
+}
+
+// signedClaims are the claims signed tokens of both formats carry.
+type signedClaims struct {
+	Subject string `json:"sub"`
+	TokenID string `json:"jti"`
+	Name    string `json:"name,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Scope   string `json:"scope,omitempty"`
+}
+
+// jwtClaims are JWT claims, with times as seconds since the epoch.
+type jwtClaims struct {
+	signedClaims
+	IssuedAt int64 `json:"iat"`
+	Expires  int64 `json:"exp"`
+}
+
+// pasetoClaims are PASETO claims, with times as RFC 3339 strings.
+type pasetoClaims struct {
+	signedClaims
+	IssuedAt time.Time `json:"iat"`
+	Expires  time.Time `json:"exp"`
+}
+
+// jwtHeader is the JOSE header of a JWT.
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Type      string `json:"typ,omitempty"`
+}
+
+// pasetoFooter is the footer of a PASETO token.
+type pasetoFooter struct {
+	KeyID string `json:"kid"`
+}
+
+// Format returns the format of the tokens the issuer issues.
+func (s *SignedIssuer) Format() TokenFormat { return s.format }
+
+// Issue returns a token for user signed with the current key.
+func (s *SignedIssuer) Issue(user User, ttl time.Duration, scopes string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	claims := signedClaims{
+		Subject: user.ID.String(),
+		TokenID: hex.EncodeToString(id),
+		Name:    user.FirstName,
+		Email:   user.Email,
+		Scope:   scopes,
+	}
+	if s.format == TokenPASETO {
+		return s.issuePASETO(pasetoClaims{claims, now, now.Add(ttl)})
+	}
+	return s.issueJWT(jwtClaims{claims, now.Unix(), now.Add(ttl).Unix()})
+}
+
+// issueJWT encodes and signs a JWT.
+func (s *SignedIssuer) issueJWT(claims jwtClaims) (string, error) {
+	key := s.keys.current
+	header, err := json.Marshal(jwtHeader{Algorithm: key.Algorithm, KeyID: key.ID, Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	return signingInput + "." + b64.EncodeToString(key.sign([]byte(signingInput))), nil
+}
+
+// issuePASETO encodes and signs a PASETO v4 public token, with the key ID in its footer.
+func (s *SignedIssuer) issuePASETO(claims pasetoClaims) (string, error) {
+	key := s.keys.current
+	footer, err := json.Marshal(pasetoFooter{KeyID: key.ID})
+	if err != nil {
+		return "", err
+	}
+	message, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	sig := key.sign(pae([]byte(pasetoPrefix), message, footer, nil))
+	return pasetoPrefix + b64.EncodeToString(append(message, sig...)) + "." + b64.EncodeToString(footer), nil
+}
+
+// Verify checks the signature, expiry and revocation of a JWT or PASETO token and returns its claims.
+func (s *SignedIssuer) Verify(plainText string) (*TokenClaims, error) {
+	claims, err := s.verifySignature(plainText)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.Expires.After(time.Now()) {
+		return nil, ErrInvalidToken
+	}
+	if s.deny != nil {
+		denied, err := s.deny.Denied(claims.ID)
+		if err != nil {
+			return nil, err
+		}
+		if denied {
+			return nil, ErrInvalidToken
+		}
+	}
+	return claims, nil
+}
+
+// Revoke adds a valid token to the deny list until it expires. Revoking an invalid token does nothing.
+func (s *SignedIssuer) Revoke(plainText string) error {
+	if s.deny == nil {
+		return errors.New("signed tokens cannot be revoked without a deny list")
+	}
+	claims, err := s.Verify(plainText)
+	if errors.Is(err, ErrInvalidToken) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return s.deny.Deny(claims.ID, claims.Expires)
+}
+
+// verifySignature decodes a token and checks its signature, but not its expiry or revocation.
+func (s *SignedIssuer) verifySignature(plainText string) (*TokenClaims, error) {
+	switch DetectTokenFormat(plainText) {
+	case TokenJWT:
+		return s.verifyJWT(plainText)
+	case TokenPASETO:
+		return s.verifyPASETO(plainText)
+	default:
+		return nil, ErrInvalidToken
+	}
+}
+
+// verifyJWT checks a JWT. The algorithm in its header must be the one of the key it names, so a token
+// cannot pick a weaker algorithm than its key was issued for.
+func (s *SignedIssuer) verifyJWT(plainText string) (*TokenClaims, error) {
+	parts := strings.Split(plainText, ".")
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	key, ok := s.keys.byID[header.KeyID]
+	if !ok || header.Algorithm != key.Algorithm {
+		return nil, ErrInvalidToken
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil || !key.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrInvalidToken
+	}
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	return claims.signedClaims.tokenClaims(time.Unix(claims.IssuedAt, 0), time.Unix(claims.Expires, 0))
+}
+
+// verifyPASETO checks a PASETO v4 public token, signed with the Ed25519 key named in its footer.
+func (s *SignedIssuer) verifyPASETO(plainText string) (*TokenClaims, error) {
+	body, encodedFooter, _ := strings.Cut(strings.TrimPrefix(plainText, pasetoPrefix), ".")
+	signed, err := b64.DecodeString(body)
+	if err != nil || len(signed) < ed25519.SignatureSize {
+		return nil, ErrInvalidToken
+	}
+	footer, err := b64.DecodeString(encodedFooter)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var f pasetoFooter
+	if err := json.Unmarshal(footer, &f); err != nil {
+		return nil, ErrInvalidToken
+	}
+	key, ok := s.keys.byID[f.KeyID]
+	if !ok || key.Algorithm != AlgEdDSA {
+		return nil, ErrInvalidToken
+	}
+	message, sig := signed[:len(signed)-ed25519.SignatureSize], signed[len(signed)-ed25519.SignatureSize:]
+	if !key.verify(pae([]byte(pasetoPrefix), message, footer, nil), sig) {
+		return nil, ErrInvalidToken
+	}
+	var claims pasetoClaims
+	if err := json.Unmarshal(message, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	return claims.signedClaims.tokenClaims(claims.IssuedAt, claims.Expires)
+}
+
+// tokenClaims converts signed claims to TokenClaims.
+func (c signedClaims) tokenClaims(issuedAt, expires time.Time) (*TokenClaims, error) {
+	if c.Subject == "" || c.TokenID == "" {
+		return nil, ErrInvalidToken
+	}
+	return &TokenClaims{
+		ID:        c.TokenID,
+		UserID:    ID(c.Subject),
+		FirstName: c.Name,
+		Email:     c.Email,
+		Scopes:    c.Scope,
+		IssuedAt:  issuedAt,
+		Expires:   expires,
+	}, nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT into v.
+func decodeSegment(segment string, v interface{}) error {
+	raw, err := b64.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// pae is PASETO's pre-authentication encoding: the number of pieces, then each piece preceded by its
+// length, all lengths as little-endian 64-bit integers with the top bit clear.
+func pae(pieces ...[]byte) []byte {
+	le64 := func(n int) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, uint64(n)&^(1<<63))
+		return b
+	}
+	out := le64(len(pieces))
+	for _, p := range pieces {
+		out = append(out, le64(len(p))...)
+		out = append(out, p...)
+	}
+	return out
+}
@@ -0,0 +1,214 @@
+package data
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSigningKey returns a key whose material is byte b repeated.
+func testSigningKey(t *testing.T, id, alg string, b byte) SigningKey {
+	t.Helper()
+	k, err := NewSigningKey(id, alg, bytes.Repeat([]byte{b}, 32))
+	if err != nil {
+		t.Fatalf("NewSigningKey(%s) error = %v", id, err)
+	}
+	return k
+}
+
+// newTestIssuer returns a signed issuer of format signing with key, with an in-memory deny list.
+func newTestIssuer(t *testing.T, format TokenFormat, keys ...SigningKey) *SignedIssuer {
+	t.Helper()
+	ks, err := NewSigningKeys("", keys...)
+	if err != nil {
+		t.Fatalf("NewSigningKeys() error = %v", err)
+	}
+	s, err := NewSignedIssuer(format, ks, NewMemoryDenyList())
+	if err != nil {
+		t.Fatalf("NewSignedIssuer() error = %v", err)
+	}
+	return s
+}
+
+var signedTestUser = User{ID: "42", FirstName: "Jane", Email: "jane@example.com"}
+
+func TestSignedIssuer_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		format TokenFormat
+		alg    string
+	}{
+		{"jwt hs256", TokenJWT, AlgHS256},
+		{"jwt eddsa", TokenJWT, AlgEdDSA},
+		{"paseto", TokenPASETO, AlgEdDSA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestIssuer(t, tt.format, testSigningKey(t, "k1", tt.alg, 1))
+			token, err := s.Issue(signedTestUser, time.Hour, "users:read")
+			if err != nil {
+				t.Fatalf("Issue() error = %v", err)
+			}
+			if got := DetectTokenFormat(token); got != tt.format {
+				t.Errorf("DetectTokenFormat() = %s, want %s", got, tt.format)
+			}
+			claims, err := s.Verify(token)
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if claims.UserID != "42" || claims.Email != "jane@example.com" || claims.FirstName != "Jane" || claims.Scopes != "users:read" {
+				t.Errorf("Verify() claims = %+v", claims)
+			}
+			if d := time.Until(claims.Expires); d <= 59*time.Minute || d > time.Hour {
+				t.Errorf("token expires in %s, want an hour", d)
+			}
+
+			if err := s.Revoke(token); err != nil {
+				t.Fatalf("Revoke() error = %v", err)
+			}
+			if _, err := s.Verify(token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify() after Revoke error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestSignedIssuer_Invalid(t *testing.T) {
+	s := newTestIssuer(t, TokenJWT, testSigningKey(t, "k1", AlgHS256, 1))
+	token, err := s.Issue(signedTestUser, time.Hour, "")
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	parts := strings.Split(token, ".")
+	expired, _ := s.Issue(signedTestUser, -time.Minute, "")
+	other, _ := newTestIssuer(t, TokenJWT, testSigningKey(t, "k1", AlgHS256, 2)).Issue(signedTestUser, time.Hour, "")
+	// A token claiming no signature is needed, signed with nothing.
+	none := b64.EncodeToString([]byte(`{"alg":"none","kid":"k1"}`)) + "." + parts[1] + "."
+	// A payload claiming another user, under the original signature.
+	forged := parts[0] + "." + b64.EncodeToString([]byte(`{"sub":"1","jti":"x","exp":9999999999}`)) + "." + parts[2]
+
+	for name, token := range map[string]string{
+		"expired":       expired,
+		"other key":     other,
+		"alg none":      none,
+		"forged claims": forged,
+		"garbage":       "a.b.c",
+		"opaque":        "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := s.Verify(token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify() error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+// TestSignedIssuer_Rotation tests that tokens signed with a previous key verify until that key is dropped.
+func TestSignedIssuer_Rotation(t *testing.T) {
+	old := testSigningKey(t, "2024", AlgEdDSA, 1)
+	next := testSigningKey(t, "2025", AlgEdDSA, 2)
+	before := newTestIssuer(t, TokenPASETO, old)
+	token, err := before.Issue(signedTestUser, time.Hour, "")
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	ks, err := NewSigningKeys("2025", old, next)
+	if err != nil {
+		t.Fatalf("NewSigningKeys() error = %v", err)
+	}
+	rotated, err := NewSignedIssuer(TokenPASETO, ks, nil)
+	if err != nil {
+		t.Fatalf("NewSignedIssuer() error = %v", err)
+	}
+	if _, err := rotated.Verify(token); err != nil {
+		t.Errorf("Verify() of a token signed before rotation error = %v", err)
+	}
+	fresh, _ := rotated.Issue(signedTestUser, time.Hour, "")
+	if _, err := before.Verify(fresh); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() with an unknown key ID error = %v, want ErrInvalidToken", err)
+	}
+
+	after := newTestIssuer(t, TokenPASETO, next)
+	if _, err := after.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() after dropping the old key error = %v, want ErrInvalidToken", err)
+	}
+}
+
+// TestPAE tests the pre-authentication encoding against the examples in the PASETO specification.
+func TestPAE(t *testing.T) {
+	tests := []struct {
+		pieces [][]byte
+		want   string
+	}{
+		{nil, "\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{[][]byte{{}}, "\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{[][]byte{[]byte("test")}, "\x01\x00\x00\x00\x00\x00\x00\x00\x04\x00\x00\x00\x00\x00\x00\x00test"},
+	}
+	for _, tt := range tests {
+		if got := pae(tt.pieces...); string(got) != tt.want {
+			t.Errorf("pae(%q) = %q, want %q", tt.pieces, got, tt.want)
+		}
+	}
+}
+
+func TestParseSigningKeys(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	ks, err := ParseSigningKeys("old:HS256:"+secret+", new:EdDSA:"+secret, "new")
+	if err != nil {
+		t.Fatalf("ParseSigningKeys() error = %v", err)
+	}
+	if ks.current.ID != "new" || ks.current.Algorithm != AlgEdDSA || len(ks.byID) != 2 {
+		t.Errorf("ParseSigningKeys() = current %s/%s with %d keys", ks.current.ID, ks.current.Algorithm, len(ks.byID))
+	}
+
+	for _, spec := range []string{
+		"",
+		"k1:HS256",
+		"k1:HS256:not base64!",
+		"k1:HS256:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"k1:RS256:" + secret,
+		"k1:HS256:" + secret + ",k1:HS256:" + secret,
+	} {
+		if _, err := ParseSigningKeys(spec, ""); err == nil {
+			t.Errorf("ParseSigningKeys(%q) succeeded, want an error", spec)
+		}
+	}
+	if _, err := ParseSigningKeys("k1:HS256:"+secret, "k2"); err == nil {
+		t.Error("ParseSigningKeys() with an unknown current key succeeded, want an error")
+	}
+}
+
+// TestAuthenticateToken_Signed tests that signed bearer tokens authenticate without a stored token.
+func TestAuthenticateToken_Signed(t *testing.T) {
+	m := newMemoryModels(t)
+	s := newTestIssuer(t, TokenJWT, testSigningKey(t, "k1", AlgHS256, 1))
+	token, err := s.Issue(signedTestUser, time.Hour, ScopeUsersRead)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	SetSignedIssuer(nil)
+	if _, err := m.Tokens.AuthenticateToken(r); err == nil {
+		t.Error("AuthenticateToken() accepted a signed token with no signing keys configured")
+	}
+
+	SetSignedIssuer(s)
+	t.Cleanup(func() { SetSignedIssuer(nil) })
+	user, err := m.Tokens.AuthenticateToken(r)
+	if err != nil {
+		t.Fatalf("AuthenticateToken() error = %v", err)
+	}
+	if user.ID != "42" || user.Email != "jane@example.com" {
+		t.Errorf("AuthenticateToken() = %+v", user)
+	}
+	if !user.Token.HasScope(ScopeUsersRead) || user.Token.HasScope(ScopeUsersWrite) {
+		t.Errorf("expected the user to carry the token's scopes, got %q", user.Token.Scopes)
+	}
+}
@@ -0,0 +1,51 @@
+package data
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestToken_AuthenticateToken_SingleQuery tests that an opaque token and its user are resolved in one query.
+func TestToken_AuthenticateToken_SingleQuery(t *testing.T) {
+	mock := newMockSession(t)
+	oldCache := cache
+	SetCache(nil, 0, 0)
+	t.Cleanup(func() { cache = oldCache })
+	now := time.Now()
+	plainText := strings.Repeat("A", TokenLength)
+
+	columns := []string{"id", "user_id", "first_name", "email", "token_hash", "created_at", "updated_at", "expiry",
+		"scopes", "token_type", "name", "last_used_at", "last_used_ip", "use_count",
+		"first_name", "last_name", "email", "user_active", "password", "created_at", "updated_at", "version"}
+	mock.ExpectQuery(`FROM tokens t JOIN users u ON u.id = t.user_id\s+WHERE t.token_hash = \$1 AND u.deleted_at IS NULL`).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("7", "3", "Jane", "jane@example.com", []byte("hash"), now, now, now.Add(time.Hour),
+			"users:read", "api", "", nil, "", 0,
+			"Jane", "Doe", "jane@example.com", 1, "x", now, now, 2))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+plainText)
+	user, err := (&Token{}).AuthenticateToken(r)
+	if err != nil {
+		t.Fatalf("AuthenticateToken() error = %v", err)
+	}
+	if user.ID != "3" || user.LastName != "Doe" || user.Version != 2 || user.Token.ID != "7" || user.Token.Scopes != "users:read" {
+		t.Errorf("AuthenticateToken() = %+v", user)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestToken_HasScope tests matching whole scopes in the space-separated list.
+func TestToken_HasScope(t *testing.T) {
+	token := Token{Scopes: "users:read  admin"}
+	for scope, want := range map[string]bool{"users:read": true, "admin": true, "users": false, "users:write": false, "": false} {
+		if got := token.HasScope(scope); got != want {
+			t.Errorf("HasScope(%q) = %v, want %v", scope, got, want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/upper/db/v4"
+)
+
+// DefaultTokenUsageFlushInterval is how often recorded token usage is written to the database,
+// configurable via the TOKEN_USAGE_FLUSH_INTERVAL environment variable.
+const DefaultTokenUsageFlushInterval = time.Minute
+
+// maxIPLength is the length of the last_used_ip column, enough for any IPv6 address.
+const maxIPLength = 45
+
+// tokenUsage holds the tracker opaque tokens record their use in, or nil if usage is not tracked.
+var tokenUsage atomic.Pointer[TokenUsage]
+
+// tokenUse is the usage of one token since the last flush.
+type tokenUse struct {
+	count int64
+	at    time.Time
+	ip    string
+}
+
+// merge adds the usage in other, keeping the most recent time and address.
+func (u tokenUse) merge(other tokenUse) tokenUse {
+	u.count += other.count
+	if other.at.After(u.at) {
+		u.at, u.ip = other.at, other.ip
+	}
+	return u
+}
+
+// TokenUsage records when, from where and how often opaque tokens authenticate. Uses are counted in memory
+// and written once per token every flush interval, so a busy token costs one update per interval rather
+// than one per request. Usage still in memory when the process dies is lost.
+type TokenUsage struct {
+	interval time.Duration
+	errorLog *log.Logger
+
+	mu      sync.Mutex
+	pending map[ID]tokenUse
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// TokenUsageIntervalFromEnv reads the flush interval from TOKEN_USAGE_FLUSH_INTERVAL. It returns zero if
+// the variable is "off", which disables usage tracking.
+func TokenUsageIntervalFromEnv() (time.Duration, error) {
+	if strings.ToLower(os.Getenv("TOKEN_USAGE_FLUSH_INTERVAL")) == "off" {
+		return 0, nil
+	}
+	return envDuration("TOKEN_USAGE_FLUSH_INTERVAL", DefaultTokenUsageFlushInterval)
+}
+
+// NewTokenUsage returns a tracker flushing every interval and reporting failed flushes to errorLog,
+// or the standard logger if it is nil. Call Start to run it.
+func NewTokenUsage(interval time.Duration, errorLog *log.Logger) *TokenUsage {
+	if errorLog == nil {
+		errorLog = log.Default()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TokenUsage{
+		interval: interval,
+		errorLog: errorLog,
+		pending:  make(map[ID]tokenUse),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start makes AuthenticateToken record usage in the tracker, and flushes it every interval until Stop is
+// called. It does nothing if the interval is not positive.
+func (u *TokenUsage) Start() {
+	if u.interval <= 0 {
+		close(u.done)
+		return
+	}
+	tokenUsage.Store(u)
+	go func() {
+		defer close(u.done)
+		ticker := time.NewTicker(u.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := u.Flush(u.ctx); err != nil && u.ctx.Err() == nil {
+					u.errorLog.Println("token usage:", err)
+				}
+			case <-u.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops recording and writes the usage recorded so far. It must only be called after Start, and may be
+// called more than once.
+func (u *TokenUsage) Stop() {
+	tokenUsage.CompareAndSwap(u, nil)
+	u.cancel()
+	<-u.done
+	if err := u.Flush(context.Background()); err != nil {
+		u.errorLog.Println("token usage:", err)
+	}
+}
+
+// record counts one use of token id from address ip.
+func (u *TokenUsage) record(id ID, ip string) {
+	use := tokenUse{count: 1, at: time.Now(), ip: ip}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if prev, ok := u.pending[id]; ok {
+		use = prev.merge(use)
+	}
+	u.pending[id] = use
+}
+
+// Flush writes the usage recorded since the last flush, one update per token. Usage that could not be
+// written is kept for the next flush.
+func (u *TokenUsage) Flush(ctx context.Context) error {
+	u.mu.Lock()
+	pending := u.pending
+	u.pending = make(map[ID]tokenUse)
+	u.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	sess := upper.WithContext(ctx).SQL()
+	var err error
+	for id, use := range pending {
+		_, err = sess.Update("tokens").
+			Set("use_count", db.Raw("use_count + ?", use.count), "last_used_at", use.at, "last_used_ip", use.ip).
+			Where(db.Cond{"id": id}).
+			Exec()
+		if err != nil {
+			break
+		}
+		delete(pending, id)
+	}
+	if err != nil {
+		u.mu.Lock()
+		for id, use := range pending {
+			if newer, ok := u.pending[id]; ok {
+				use = use.merge(newer)
+			}
+			u.pending[id] = use
+		}
+		u.mu.Unlock()
+		return fmt.Errorf("writing usage of %d tokens: %w", len(pending), err)
+	}
+	return nil
+}
+
+// clientIP returns the address of the client that sent r, without its port, truncated to fit last_used_ip.
+// Behind a proxy, the RealIP middleware has already replaced the remote address with the forwarded one.
+func clientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if len(ip) > maxIPLength {
+		ip = ip[:maxIPLength]
+	}
+	return ip
+}
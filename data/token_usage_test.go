@@ -0,0 +1,78 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestTokenUsage_Flush tests that uses of a token are written in one update, and kept if the write fails.
+func TestTokenUsage_Flush(t *testing.T) {
+	mock := newMockSession(t)
+	u := NewTokenUsage(time.Hour, nil)
+	u.record("1", "10.0.0.1")
+	u.record("1", "10.0.0.2")
+	u.record("1", "10.0.0.3")
+
+	mock.ExpectExec(`UPDATE "tokens" SET "use_count" = use_count \+ \$1, "last_used_at" = \$2, "last_used_ip" = \$3 WHERE \("id" = \$4\)`).
+		WithArgs(int64(3), sqlmock.AnyArg(), "10.0.0.3", sqlmock.AnyArg()).
+		WillReturnError(errors.New("database is down"))
+	if err := u.Flush(context.Background()); err == nil {
+		t.Fatal("Flush() succeeded, want the database error")
+	}
+
+	u.record("1", "10.0.0.4")
+	mock.ExpectExec(`UPDATE "tokens"`).
+		WithArgs(int64(4), sqlmock.AnyArg(), "10.0.0.4", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := u.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if err := u.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() with nothing recorded error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemoryTokens_Usage(t *testing.T) {
+	m := newMemoryModels(t)
+	id, err := m.Users.Insert(User{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Active: 1, Password: "password1"})
+	if err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+	user, _ := m.Users.Get(id)
+	plainText, err := m.Issuer.Issue(*user, time.Hour, "")
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	if unused, _ := m.Tokens.GetUnused(time.Now().Add(time.Minute)); len(unused) != 1 {
+		t.Fatalf("GetUnused() of a never used token = %d tokens, want 1", len(unused))
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "[2001:db8::1]:4321"
+	r.Header.Set("Authorization", "Bearer "+plainText)
+	for i := 0; i < 2; i++ {
+		if _, err := m.Tokens.AuthenticateToken(r); err != nil {
+			t.Fatalf("AuthenticateToken() error = %v", err)
+		}
+	}
+
+	token, err := m.Tokens.GetByToken(plainText)
+	if err != nil {
+		t.Fatalf("GetByToken() error = %v", err)
+	}
+	if token.UseCount != 2 || token.LastUsedIP != "2001:db8::1" || token.LastUsedAt == nil {
+		t.Errorf("token usage = %d uses, last from %q at %v", token.UseCount, token.LastUsedIP, token.LastUsedAt)
+	}
+	if unused, _ := m.Tokens.GetUnused(time.Now().Add(-time.Minute)); len(unused) != 0 {
+		t.Errorf("GetUnused() of a token just used = %d tokens, want 0", len(unused))
+	}
+}
@@ -2,10 +2,13 @@ package data
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/jorgeSader/devify"
 	"github.com/upper/db/v4"
 	"golang.org/x/crypto/bcrypt"
@@ -13,22 +16,33 @@ import (
 
 // User represents a user entity in the database.
 type User struct {
-	ID        int       `db:"id,omitempty"`
-	FirstName string    `db:"first_name"`
-	LastName  string    `db:"last_name"`
-	Email     string    `db:"email"`
-	Active    int       `db:"user_active"`
-	Password  string    `db:"password"`
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
-	Token     Token     `db:"-"`
+	ID        ID         `db:"id,omitempty"`
+	FirstName string     `db:"first_name"`
+	LastName  string     `db:"last_name"`
+	Email     string     `db:"email"`
+	Active    int        `db:"user_active"`
+	Password  string     `db:"password" json:"-"` // never cached, see Get
+	CreatedAt time.Time  `db:"created_at"`
+	UpdatedAt time.Time  `db:"updated_at"`
+	DeletedAt *time.Time `db:"deleted_at"`
+	Version   int        `db:"version"`
+	Token     Token      `db:"-"`
+	scope     *ReadScope `db:"-"` // routes the reads of the store, see Models.WithReadScope
 }
 
+// notDeleted matches users that have not been soft-deleted.
+var notDeleted = db.Cond{"deleted_at": db.IsNull()}
+
 // Table returns the database table name for the User model.
 func (u *User) Table() string {
 	return "users"
 }
 
+// IsDeleted reports whether the user has been soft-deleted.
+func (u *User) IsDeleted() bool {
+	return u.DeletedAt != nil
+}
+
 func (u *User) Validate(validator *devify.Validation) {
 	validator.Required("first_name", "last_name", "email", "active").
 		Between("first_name", 3, 50, "First name must be 3-50 characters").
@@ -37,32 +51,44 @@ func (u *User) Validate(validator *devify.Validation) {
 		IsBoolean("active", "Active must be an boolean")
 }
 
-// GetAll retrieves all users from the database, ordered by last name.
+// GetAll retrieves all users that have not been soft-deleted, ordered by last name.
 func (u *User) GetAll() ([]*User, error) {
-	collection := upper.Collection(u.Table())
 	var all []*User
-	res := collection.Find().OrderBy("last_name")
-	err := res.All(&all)
+	err := u.scope.read(func(sess db.Session) error {
+		all = nil
+		return sess.Collection(u.Table()).Find(notDeleted).OrderBy("last_name").All(&all)
+	})
 	if err != nil {
 		return nil, err
 	}
 	return all, nil
 }
 
-// GetByEmail retrieves a user by their email address.
+// GetByEmail retrieves a user by their email address, ignoring soft-deleted users.
 // It includes the most recent non-expired token, if available.
 func (u *User) GetByEmail(email string) (*User, error) {
+	var user *User
+	err := u.scope.read(func(sess db.Session) (err error) {
+		user, err = findUser(sess, db.Cond{"email =": email})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// findUser retrieves the user matching cond from sess, ignoring soft-deleted users, with their most recent
+// non-expired token, if any.
+func findUser(sess db.Session, cond db.Cond) (*User, error) {
 	var user User
-	collection := upper.Collection(u.Table())
-	res := collection.Find(db.Cond{"email =": email})
-	err := res.One(&user)
+	err := sess.Collection(user.Table()).Find(cond, notDeleted).One(&user)
 	if err != nil {
 		return nil, err
 	}
 
 	var token Token
-	collection = upper.Collection(token.Table())
-	res = collection.Find(db.Cond{"user_id =": user.ID, "expiry >": time.Now()}).OrderBy("created_at desc")
+	res := sess.Collection(token.Table()).Find(db.Cond{"user_id =": user.ID, "expiry >": time.Now()}).OrderBy("created_at desc")
 	err = res.One(&token)
 	if err != nil {
 		if !errors.Is(err, db.ErrNilRecord) && !errors.Is(err, db.ErrNoMoreRows) {
@@ -74,115 +100,323 @@ func (u *User) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-// Get retrieves a user by their ID.
-// It includes the most recent non-expired token, if available.
-func (u *User) Get(id int) (*User, error) {
-	var user User
-	collection := upper.Collection(u.Table())
-	res := collection.Find(db.Cond{"id =": id})
-	err := res.One(&user)
+// Get retrieves a user by their ID, ignoring soft-deleted users.
+// It includes the most recent non-expired token, if available. Results are cached when a cache is configured,
+// without the password hash, so a user served from the cache has an empty Password; PasswordMatches loads it.
+func (u *User) Get(id ID) (*User, error) {
+	user, err := cached(userCacheKey(id), userCacheTTL, func() (*User, error) {
+		var user *User
+		err := u.scope.read(func(sess db.Session) (err error) {
+			user, err = findUser(sess, db.Cond{"id =": id})
+			return err
+		})
+		return user, err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	var token Token
-	collection = upper.Collection(token.Table())
-	res = collection.Find(db.Cond{"user_id =": user.ID, "expiry >": time.Now()}).OrderBy("created_at desc")
-	err = res.One(&token)
-	if err != nil {
-		if !errors.Is(err, db.ErrNilRecord) && !errors.Is(err, db.ErrNoMoreRows) {
-			return nil, err
-		}
+	// A cached token may have expired since it was stored.
+	if !user.Token.Expires.After(time.Now()) {
+		user.Token = Token{}
 	}
-
-	user.Token = token
-	return &user, nil
+	return user, nil
 }
 
-// Update modifies an existing user in the database.
-// It updates the UpdatedAt timestamp to the current time.
+// Update modifies an existing user in the database; soft-deleted users are left untouched.
+// The update only applies if user.Version still matches the stored version, which it then increments;
+// otherwise it returns ErrStaleObject, or db.ErrNoMoreRows if the user does not exist. It returns
+// ErrDuplicateEmail if another live user has the email address.
+// It updates the UpdatedAt timestamp to the current time, and runs any update hooks. The password is left
+// alone, so a user read from the cache can be saved; use ResetPassword to change it.
 func (u *User) Update(user User) error {
+	defer u.scope.wrote()
+	expected := user.Version
+	user.Version++
 	user.UpdatedAt = time.Now()
-	collection := upper.Collection(u.Table())
-	res := collection.Find(db.Cond{"id =": user.ID})
-	err := res.Update(&user)
+	err := withHooks(&user, hookUpdate, nil, func(sess db.Session) error {
+		res, err := sess.SQL().
+			Update(u.Table()).
+			Set("first_name", user.FirstName, "last_name", user.LastName, "email", user.Email,
+				"user_active", user.Active, "updated_at", user.UpdatedAt, "version", user.Version).
+			Where(db.Cond{"id =": user.ID, "version =": expected}, notDeleted).
+			Exec()
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return staleOrMissing(sess, u.Table(), db.Cond{"id =": user.ID}, notDeleted)
+		}
+		return nil
+	})
+	if uniqueViolation(err) {
+		return fmt.Errorf("%w: %s", ErrDuplicateEmail, user.Email)
+	}
 	if err != nil {
 		return err
 	}
+	invalidate(userCacheKey(user.ID))
 	return nil
 }
 
-// Delete removes a user from the database by their ID.
-func (u *User) Delete(id int) error {
-	collection := upper.Collection(u.Table())
-	res := collection.Find(db.Cond{"id =": id})
-	err := res.Delete()
+// staleOrMissing explains why a versioned update matched no rows: ErrStaleObject if the record
+// still exists, so only its version moved on, or db.ErrNoMoreRows if it is gone.
+func staleOrMissing(sess db.Session, table string, conds ...interface{}) error {
+	exists, err := sess.Collection(table).Find(conds...).Exists()
 	if err != nil {
 		return err
 	}
+	if exists {
+		return ErrStaleObject
+	}
+	return db.ErrNoMoreRows
+}
+
+// uniqueViolation reports whether err is the database rejecting a write for a duplicate key. The only key of
+// users that writes can collide on is the email address of live users.
+func uniqueViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	var state interface{ SQLState() string } // PostgreSQL, through pgx or lib/pq
+	if errors.As(err, &state) {
+		return state.SQLState() == "23505"
+	}
+	var my *mysql.MySQLError
+	if errors.As(err, &my) {
+		return my.Number == 1062 // ER_DUP_ENTRY
+	}
+	return dialect == "sqlite" && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// Delete soft-deletes a user by their ID.
+// The row and the user's tokens are kept so the user can be restored, but the user is hidden from
+// Get, GetByEmail, GetAll and List, and their tokens no longer authenticate. Delete hooks see the user
+// as it was before the delete.
+func (u *User) Delete(id ID) error {
+	defer u.scope.wrote()
+	var user User
+	load := func(tx db.Session) error {
+		return tx.Collection(u.Table()).Find(db.Cond{"id =": id}, notDeleted).One(&user)
+	}
+	err := withHooks(&user, hookDelete, load, func(sess db.Session) error {
+		_, err := sess.SQL().
+			Update(u.Table()).
+			Set("deleted_at", time.Now(), "version = version + 1").
+			Where(db.Cond{"id =": id}, notDeleted).
+			Exec()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	invalidate(userCacheKey(id))
 	return nil
 }
 
-// Insert adds a new user to the database.
-// It hashes the password with bcrypt, sets timestamps, and returns the new user’s ID, updating the User struct.
-func (u *User) Insert(user User) (int, error) {
-	cost := 12
-	if c := os.Getenv("BCRYPT_COST"); c != "" {
-		if i, err := strconv.Atoi(c); err == nil {
-			cost = i
+// Restore undoes a soft delete, running any update hooks.
+// It returns db.ErrNoMoreRows if there is no soft-deleted user with the given ID, or ErrDuplicateEmail if a live
+// user has taken the email address since.
+func (u *User) Restore(id ID) error {
+	defer u.scope.wrote()
+	deleted := db.Cond{"id =": id, "deleted_at": db.IsNotNull()}
+	var user User
+	load := func(tx db.Session) error {
+		if err := tx.Collection(u.Table()).Find(deleted).One(&user); err != nil {
+			return err
+		}
+		user.DeletedAt = nil
+		user.Version++
+		return nil
+	}
+	err := withHooks(&user, hookUpdate, load, func(sess db.Session) error {
+		res, err := sess.SQL().
+			Update(u.Table()).
+			Set("deleted_at", nil, "version = version + 1").
+			Where(deleted).
+			Exec()
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
 		}
+		if n == 0 {
+			return db.ErrNoMoreRows
+		}
+		return nil
+	})
+	if uniqueViolation(err) {
+		return fmt.Errorf("%w: restoring user %s", ErrDuplicateEmail, id)
 	}
-	newHash, err := bcrypt.GenerateFromPassword([]byte(user.Password), cost)
 	if err != nil {
+		return err
+	}
+	invalidate(userCacheKey(id))
+	return nil
+}
+
+// PurgeDeleted permanently removes users that were soft-deleted more than olderThan ago,
+// along with their tokens. It returns the number of users removed. If the model has delete hooks, the users
+// are removed one at a time, each in its own transaction with its hooks; otherwise in a single statement.
+func (u *User) PurgeDeleted(olderThan time.Duration) (int, error) {
+	defer u.scope.wrote()
+	purgeable := db.Cond{"deleted_at <": time.Now().Add(-olderThan)}
+	if !hasHooks(&User{}, hookDelete) {
+		res, err := upper.SQL().
+			DeleteFrom(u.Table()).
+			Where(purgeable).
+			Exec()
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		return int(n), err
+	}
+
+	var users []*User
+	if err := upper.Collection(u.Table()).Find(purgeable).Select("id").All(&users); err != nil {
 		return 0, err
 	}
+	purged := 0
+	for _, stored := range users {
+		var user User
+		cond := db.Cond{"id =": stored.ID}
+		load := func(tx db.Session) error {
+			return tx.Collection(u.Table()).Find(cond, purgeable).One(&user)
+		}
+		err := withHooks(&user, hookDelete, load, func(sess db.Session) error {
+			res, err := sess.SQL().DeleteFrom(u.Table()).Where(cond, purgeable).Exec()
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			purged += int(n)
+			return err
+		})
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// Insert adds a new user to the database.
+// It hashes the password with bcrypt, sets timestamps, runs any insert hooks and returns the new user’s ID.
+// For UUID and ULID keys the ID is generated in Go before the insert; serial keys are assigned by the database.
+// It returns ErrDuplicateEmail if a live user has the email address.
+func (u *User) Insert(user User) (ID, error) {
+	newHash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcryptCost())
+	if err != nil {
+		return "", err
+	}
 
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
 	user.Password = string(newHash)
+	user.Version = 1
+	if user.ID.IsZero() {
+		user.ID = NewID()
+	}
 
-	collection := upper.Collection(u.Table())
-	res, err := collection.Insert(user)
+	defer u.scope.wrote()
+	err = withHooks(&user, hookInsert, nil, func(sess db.Session) error {
+		res, err := sess.Collection(u.Table()).Insert(user)
+		if err != nil {
+			return err
+		}
+		if user.ID.IsZero() {
+			user.ID, err = InsertID(res.ID()) // Update the struct with the new ID
+		}
+		return err
+	})
+	if uniqueViolation(err) {
+		return "", fmt.Errorf("%w: %s", ErrDuplicateEmail, user.Email)
+	}
 	if err != nil {
-		return 0, err
+		return "", err
 	}
-
-	id := GetInsertID(res.ID())
-	user.ID = id // Update the struct with the new ID
-	return id, nil
+	return user.ID, nil
 }
 
 // ResetPassword updates a user’s password by their ID.
-// It hashes the new password with bcrypt and updates the user record.
-func (u *User) ResetPassword(id int, newPassword string) error {
-	user, err := u.Get(id)
+// It hashes the new password with bcrypt and updates the user record, running any update hooks. The reset is not
+// conditional on the user's version, so it cannot fail because of a concurrent edit, but it does bump the version.
+func (u *User) ResetPassword(id ID, newPassword string) error {
+	defer u.scope.wrote()
+	newHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcryptCost())
 	if err != nil {
 		return err
 	}
 
-	cost := 12
-	if c := os.Getenv("BCRYPT_COST"); c != "" {
-		if i, err := strconv.Atoi(c); err == nil {
-			cost = i
+	now := time.Now()
+	var user User
+	load := func(tx db.Session) error {
+		if err := tx.Collection(u.Table()).Find(db.Cond{"id =": id}, notDeleted).One(&user); err != nil {
+			return err
 		}
+		user.Password = string(newHash)
+		user.UpdatedAt = now
+		user.Version++
+		return nil
 	}
-	newHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), cost)
+	err = withHooks(&user, hookUpdate, load, func(sess db.Session) error {
+		res, err := sess.SQL().
+			Update(u.Table()).
+			Set("password", string(newHash), "updated_at", now, "version = version + 1").
+			Where(db.Cond{"id =": id}, notDeleted).
+			Exec()
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return db.ErrNoMoreRows
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
+	invalidate(userCacheKey(id))
+	return nil
+}
 
-	user.Password = string(newHash)
-	err = u.Update(*user)
-	if err != nil {
-		return err
+// bcryptCost returns the bcrypt cost to hash passwords with, configurable via the BCRYPT_COST environment variable.
+func bcryptCost() int {
+	cost := 12
+	if c := os.Getenv("BCRYPT_COST"); c != "" {
+		if i, err := strconv.Atoi(c); err == nil {
+			cost = i
+		}
 	}
-	return nil
+	return cost
 }
 
 // PasswordMatches verifies if the provided plaintext password matches the stored hash.
-// It returns true if they match, false otherwise, with an error only on bcrypt failure.
+// It returns true if they match, false otherwise, with an error only on bcrypt or database failure.
+// The hash of a user served from the cache is loaded from the primary for the check, and not kept.
 func (u *User) PasswordMatches(plainText string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(plainText))
+	hash := u.Password
+	if hash == "" && !u.ID.IsZero() {
+		var row struct {
+			Password string `db:"password"`
+		}
+		err := upper.SQL().Select("password").From(u.Table()).Where(db.Cond{"id =": u.ID}, notDeleted).One(&row)
+		if err != nil {
+			return false, err
+		}
+		hash = row.Password
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(plainText))
 	if err != nil {
 		switch {
 		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
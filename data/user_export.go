@@ -0,0 +1,290 @@
+package data
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/upper/db/v4"
+)
+
+// ExportFormat is the encoding of a user export.
+type ExportFormat string
+
+// Supported export formats. CSV has a header row; NDJSON has one JSON object per line; XML has a <users>
+// root element with a <user> element per user and an element per column.
+const (
+	ExportCSV    ExportFormat = "csv"
+	ExportNDJSON ExportFormat = "ndjson"
+	ExportXML    ExportFormat = "xml"
+)
+
+// ErrInvalidExportColumn is returned when an export asks for a column that is not whitelisted.
+var ErrInvalidExportColumn = errors.New("invalid export column")
+
+// userExportColumns whitelists the columns a user export may include, in their default order.
+// The password hash is deliberately absent.
+var userExportColumns = []string{"id", "first_name", "last_name", "email", "active", "created_at", "updated_at", "version"}
+
+// UserExportColumns returns the whitelisted columns a user export can include, in their default order.
+func UserExportColumns() []string {
+	return append([]string(nil), userExportColumns...)
+}
+
+// ParseExportFormat returns the export format named by s, such as "csv", or the extension of a file name.
+func ParseExportFormat(s string) (ExportFormat, error) {
+	s = strings.ToLower(s)
+	if i := strings.LastIndex(s, "."); i >= 0 {
+		s = s[i+1:]
+	}
+	switch s {
+	case "csv":
+		return ExportCSV, nil
+	case "ndjson", "jsonl", "json":
+		return ExportNDJSON, nil
+	case "xml":
+		return ExportXML, nil
+	default:
+		return "", fmt.Errorf("unknown export format: %s", s)
+	}
+}
+
+// ContentType returns the MIME type of the format.
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportCSV:
+		return "text/csv; charset=utf-8"
+	case ExportNDJSON:
+		return "application/x-ndjson"
+	case ExportXML:
+		return "application/xml; charset=utf-8"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// ExportOptions controls which users and columns an export includes. Columns defaults to every whitelisted
+// column; users are exported in ID order.
+type ExportOptions struct {
+	Format  ExportFormat
+	Columns []string
+	Filter  UserFilter
+}
+
+// Validate checks that the format is supported and every column is whitelisted.
+func (o ExportOptions) Validate() error {
+	for _, col := range o.Columns {
+		if exportValue(&User{}, col) == nil {
+			return fmt.Errorf("%w: %s", ErrInvalidExportColumn, col)
+		}
+	}
+	switch o.Format {
+	case ExportCSV, ExportNDJSON, ExportXML:
+		return nil
+	default:
+		return fmt.Errorf("unknown export format: %s", o.Format)
+	}
+}
+
+// normalize validates the options and fills in defaults.
+func (o *ExportOptions) normalize() error {
+	if len(o.Columns) == 0 {
+		o.Columns = userExportColumns
+	}
+	return o.Validate()
+}
+
+// exportValue returns the value of a whitelisted export column for a user, or nil for any other column.
+func exportValue(u *User, column string) interface{} {
+	switch column {
+	case "id":
+		return u.ID
+	case "first_name":
+		return u.FirstName
+	case "last_name":
+		return u.LastName
+	case "email":
+		return u.Email
+	case "active":
+		return u.Active == 1
+	case "created_at":
+		return u.CreatedAt.UTC()
+	case "updated_at":
+		return u.UpdatedAt.UTC()
+	case "version":
+		return u.Version
+	}
+	return nil
+}
+
+// exportText formats an export value as text, for CSV and XML.
+func exportText(v interface{}) string {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// exportWriter encodes users one at a time in an export format.
+type exportWriter interface {
+	write(u *User) error
+	close() error
+}
+
+// newExportWriter returns a writer encoding the given columns of each user to w. Output is buffered;
+// close flushes it and writes any trailer.
+func newExportWriter(w io.Writer, format ExportFormat, columns []string) (exportWriter, error) {
+	bw := bufio.NewWriter(w)
+	switch format {
+	case ExportCSV:
+		cw := csv.NewWriter(bw)
+		if err := cw.Write(columns); err != nil {
+			return nil, err
+		}
+		return &csvExportWriter{buf: bw, csv: cw, columns: columns, record: make([]string, len(columns))}, nil
+	case ExportNDJSON:
+		return &ndjsonExportWriter{buf: bw, columns: columns}, nil
+	case ExportXML:
+		if _, err := bw.WriteString(xml.Header + "<users>\n"); err != nil {
+			return nil, err
+		}
+		return &xmlExportWriter{buf: bw, enc: xml.NewEncoder(bw), columns: columns}, nil
+	default:
+		return nil, fmt.Errorf("unknown export format: %s", format)
+	}
+}
+
+// csvExportWriter writes a header row, then one record per user.
+type csvExportWriter struct {
+	buf     *bufio.Writer
+	csv     *csv.Writer
+	columns []string
+	record  []string
+}
+
+func (e *csvExportWriter) write(u *User) error {
+	for i, col := range e.columns {
+		e.record[i] = exportText(exportValue(u, col))
+	}
+	return e.csv.Write(e.record)
+}
+
+func (e *csvExportWriter) close() error {
+	e.csv.Flush()
+	if err := e.csv.Error(); err != nil {
+		return err
+	}
+	return e.buf.Flush()
+}
+
+// ndjsonExportWriter writes one JSON object per line.
+type ndjsonExportWriter struct {
+	buf     *bufio.Writer
+	columns []string
+}
+
+// write encodes the user as a JSON object whose keys follow the column order.
+func (e *ndjsonExportWriter) write(u *User) error {
+	e.buf.WriteByte('{')
+	for i, col := range e.columns {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(col)
+		value, err := json.Marshal(exportValue(u, col))
+		if err != nil {
+			return err
+		}
+		e.buf.Write(key)
+		e.buf.WriteByte(':')
+		e.buf.Write(value)
+	}
+	_, err := e.buf.WriteString("}\n")
+	return err
+}
+
+func (e *ndjsonExportWriter) close() error {
+	return e.buf.Flush()
+}
+
+// xmlExportWriter writes a <user> element per user inside a <users> root element.
+type xmlExportWriter struct {
+	buf     *bufio.Writer
+	enc     *xml.Encoder
+	columns []string
+}
+
+func (e *xmlExportWriter) write(u *User) error {
+	user := xml.StartElement{Name: xml.Name{Local: "user"}}
+	if err := e.enc.EncodeToken(user); err != nil {
+		return err
+	}
+	for _, col := range e.columns {
+		if err := e.enc.EncodeElement(exportText(exportValue(u, col)), xml.StartElement{Name: xml.Name{Local: col}}); err != nil {
+			return err
+		}
+	}
+	if err := e.enc.EncodeToken(user.End()); err != nil {
+		return err
+	}
+	if err := e.enc.Flush(); err != nil {
+		return err
+	}
+	_, err := e.buf.WriteString("\n")
+	return err
+}
+
+func (e *xmlExportWriter) close() error {
+	if _, err := e.buf.WriteString("</users>\n"); err != nil {
+		return err
+	}
+	return e.buf.Flush()
+}
+
+// Export streams the users matching the options' filter to w in the requested format and returns how many
+// were written. Rows are read from a database cursor and encoded one at a time, so memory use does not grow
+// with the number of users. Password hashes are never exported.
+func (u *User) Export(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	if err := opts.normalize(); err != nil {
+		return 0, err
+	}
+	ew, err := newExportWriter(w, opts.Format, opts.Columns)
+	if err != nil {
+		return 0, err
+	}
+
+	// Rows already written cannot be taken back, so a failing replica is not retried on the primary.
+	iter := u.scope.session().WithContext(ctx).SQL().
+		Select("id", "first_name", "last_name", "email", "user_active", "created_at", "updated_at", "version").
+		From(u.Table()).
+		Where(db.And(opts.Filter.conditions()...)).
+		OrderBy("id").
+		Iterator()
+	defer iter.Close()
+
+	n := 0
+	var user User
+	for iter.Next(&user) {
+		if err := ew.write(&user); err != nil {
+			return n, err
+		}
+		n++
+		user = User{}
+	}
+	if err := iter.Err(); err != nil {
+		return n, err
+	}
+	return n, ew.close()
+}
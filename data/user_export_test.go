@@ -0,0 +1,79 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestMemoryUsers_Export tests each export format, column selection, filtering and that passwords never leak.
+func TestMemoryUsers_Export(t *testing.T) {
+	m := newMemoryModels(t)
+	for _, u := range []User{
+		{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Active: 1},
+		{FirstName: "Bob", LastName: "O'Brien, Jr.", Email: "bob@example.com"},
+	} {
+		u.Password = "secret"
+		if _, err := m.Users.Insert(u); err != nil {
+			t.Fatalf("failed to insert user: %v", err)
+		}
+	}
+	deleted, err := m.Users.Insert(User{FirstName: "Gone", LastName: "User", Email: "gone@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	if err := m.Users.Delete(deleted); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+
+	columns := []string{"email", "last_name", "active"}
+	tests := []struct {
+		format ExportFormat
+		want   string
+	}{
+		{ExportCSV, "email,last_name,active\njane@example.com,Doe,true\nbob@example.com,\"O'Brien, Jr.\",false\n"},
+		{ExportNDJSON, `{"email":"jane@example.com","last_name":"Doe","active":true}` + "\n" +
+			`{"email":"bob@example.com","last_name":"O'Brien, Jr.","active":false}` + "\n"},
+		{ExportXML, xml.Header + "<users>\n" +
+			"<user><email>jane@example.com</email><last_name>Doe</last_name><active>true</active></user>\n" +
+			"<user><email>bob@example.com</email><last_name>O&#39;Brien, Jr.</last_name><active>false</active></user>\n" +
+			"</users>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := m.Users.Export(context.Background(), &buf, ExportOptions{Format: tt.format, Columns: columns})
+			if err != nil {
+				t.Fatalf("Export() error = %v", err)
+			}
+			if n != 2 {
+				t.Errorf("exported %d users, want 2", n)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	active := true
+	n, err := m.Users.Export(context.Background(), &buf, ExportOptions{Format: ExportCSV, Filter: UserFilter{Active: &active}})
+	if err != nil || n != 1 {
+		t.Fatalf("filtered Export() = %d, %v", n, err)
+	}
+	header, _, _ := strings.Cut(buf.String(), "\n")
+	if header != strings.Join(UserExportColumns(), ",") {
+		t.Errorf("default columns: got header %q", header)
+	}
+	if strings.Contains(buf.String(), "$2a$") {
+		t.Error("export contains a password hash")
+	}
+
+	_, err = m.Users.Export(context.Background(), &buf, ExportOptions{Format: ExportCSV, Columns: []string{"password"}})
+	if !errors.Is(err, ErrInvalidExportColumn) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidExportColumn)
+	}
+}
@@ -0,0 +1,519 @@
+package data
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jorgeSader/devify"
+	"github.com/upper/db/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ImportFormat is the encoding of a bulk user import.
+type ImportFormat string
+
+// Supported import formats. CSV input starts with a header row naming the columns; JSON input is either an
+// array of objects or a stream of objects, one per line. Both use the first_name, last_name, email, active and
+// password fields. Unknown columns are ignored, and active defaults to true when absent.
+const (
+	ImportCSV  ImportFormat = "csv"
+	ImportJSON ImportFormat = "json"
+)
+
+// Defaults for bulk imports.
+const (
+	DefaultImportBatchSize = 500
+)
+
+// ImportOptions configures a bulk user import.
+type ImportOptions struct {
+	Format    ImportFormat
+	BatchSize int // rows written per transaction; DefaultImportBatchSize if zero
+	Workers   int // passwords hashed concurrently; the number of CPUs if zero
+}
+
+// ImportRowError describes why one row of an import was rejected. Row counts data rows from 1,
+// so the CSV header is not counted.
+type ImportRowError struct {
+	Row    int               `json:"row"`
+	Email  string            `json:"email,omitempty"`
+	Errors map[string]string `json:"errors"`
+}
+
+// ImportReport summarizes a bulk import. Rows that failed are listed in Errors, ordered by row.
+type ImportReport struct {
+	Rows     int              `json:"rows"`
+	Imported int              `json:"imported"`
+	Failed   int              `json:"failed"`
+	Errors   []ImportRowError `json:"errors"`
+}
+
+// ParseImportFormat returns the import format named by s, such as "csv", or the extension of a file name.
+func ParseImportFormat(s string) (ImportFormat, error) {
+	s = strings.ToLower(s)
+	if i := strings.LastIndex(s, "."); i >= 0 {
+		s = s[i+1:]
+	}
+	switch s {
+	case "csv":
+		return ImportCSV, nil
+	case "json", "ndjson", "jsonl":
+		return ImportJSON, nil
+	default:
+		return "", fmt.Errorf("unknown import format: %s", s)
+	}
+}
+
+// importRow is one row of an import on its way through the pipeline.
+type importRow struct {
+	row      int
+	values   url.Values
+	password string
+	user     User
+	errs     map[string]string
+}
+
+// fail records an error against the row's field.
+func (r *importRow) fail(field, message string) {
+	if r.errs == nil {
+		r.errs = make(map[string]string)
+	}
+	if _, exists := r.errs[field]; !exists {
+		r.errs[field] = message
+	}
+}
+
+// importColumns are the fields read from each row.
+var importColumns = []string{"first_name", "last_name", "email", "active", "password"}
+
+// newImportRow validates the fields of one row with User.Validate and builds the user to import.
+func newImportRow(n int, fields map[string]string) *importRow {
+	row := &importRow{row: n, values: url.Values{}}
+	for _, col := range importColumns {
+		row.values.Set(col, strings.TrimSpace(fields[col]))
+	}
+	if _, ok := fields["active"]; !ok {
+		row.values.Set("active", "true")
+	}
+	row.password = fields["password"]
+
+	validator := &devify.Validation{Data: row.values, Errors: make(map[string]string)}
+	row.user.Validate(validator)
+	for field, message := range validator.Errors {
+		row.fail(field, message)
+	}
+	active, err := strconv.ParseBool(row.values.Get("active"))
+	if err != nil {
+		row.fail("active", "Active must be an boolean")
+	}
+	if row.password == "" {
+		row.fail("password", "Password is required")
+	}
+
+	row.user.FirstName = row.values.Get("first_name")
+	row.user.LastName = row.values.Get("last_name")
+	row.user.Email = row.values.Get("email")
+	if active {
+		row.user.Active = 1
+	}
+	return row
+}
+
+// readImportRows parses r and sends each row, numbered from 1, to rows until the input is exhausted.
+func readImportRows(ctx context.Context, r io.Reader, format ImportFormat, rows chan<- *importRow) error {
+	send := func(row *importRow) error {
+		select {
+		case rows <- row:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	switch format {
+	case ImportCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+		header, err := cr.Read()
+		if err != nil {
+			return fmt.Errorf("reading csv header: %w", err)
+		}
+		for i := range header {
+			header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+		}
+		for n := 1; ; n++ {
+			record, err := cr.Read()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("reading csv row %d: %w", n, err)
+			}
+			fields := make(map[string]string, len(header))
+			for i, value := range record {
+				if i < len(header) {
+					fields[header[i]] = value
+				}
+			}
+			if err := send(newImportRow(n, fields)); err != nil {
+				return err
+			}
+		}
+	case ImportJSON:
+		br := bufio.NewReader(r)
+		dec := json.NewDecoder(br)
+		array, err := startsWith(br, '[')
+		if err != nil {
+			return err
+		}
+		if array {
+			if _, err := dec.Token(); err != nil {
+				return fmt.Errorf("reading json: %w", err)
+			}
+		}
+		for n := 1; !array || dec.More(); n++ {
+			var obj map[string]interface{}
+			err := dec.Decode(&obj)
+			if !array && errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("reading json row %d: %w", n, err)
+			}
+			fields := make(map[string]string, len(obj))
+			for key, value := range obj {
+				if value != nil {
+					fields[strings.ToLower(key)] = fmt.Sprint(value)
+				}
+			}
+			if err := send(newImportRow(n, fields)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown import format: %s", format)
+	}
+}
+
+// startsWith reports whether the first non-space byte of br is c, without consuming it.
+func startsWith(br *bufio.Reader, c byte) (bool, error) {
+	for {
+		b, err := br.Peek(1)
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = br.ReadByte()
+		default:
+			return b[0] == c, nil
+		}
+	}
+}
+
+// runImport streams rows from r through validation and a bounded pool of bcrypt workers, and hands valid rows
+// to write in batches. write records failures on the rows it is given; a returned error aborts the import.
+func runImport(ctx context.Context, r io.Reader, opts ImportOptions, write func(ctx context.Context, batch []*importRow) error) (*ImportReport, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultImportBatchSize
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parsed := make(chan *importRow, opts.Workers)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(parsed)
+		readErr <- readImportRows(ctx, r, opts.Format, parsed)
+	}()
+
+	hashed := make(chan *importRow, opts.Workers)
+	var workers sync.WaitGroup
+	cost := bcryptCost()
+	for i := 0; i < opts.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for row := range parsed {
+				if row.errs == nil {
+					hash, err := bcrypt.GenerateFromPassword([]byte(row.password), cost)
+					if err != nil {
+						row.fail("password", err.Error())
+					}
+					row.user.Password = string(hash)
+				}
+				select {
+				case hashed <- row:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(hashed)
+	}()
+
+	report := &ImportReport{Errors: []ImportRowError{}}
+	var batch []*importRow
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := write(ctx, batch); err != nil {
+			return err
+		}
+		for _, row := range batch {
+			report.add(row)
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for row := range hashed {
+		if row.errs != nil {
+			report.add(row)
+			continue
+		}
+		batch = append(batch, row)
+		if len(batch) >= opts.BatchSize {
+			if err := flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return report, err
+	}
+	if err := <-readErr; err != nil {
+		return report, err
+	}
+
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Row < report.Errors[j].Row })
+	return report, nil
+}
+
+// add counts a processed row in the report.
+func (rep *ImportReport) add(row *importRow) {
+	rep.Rows++
+	if row.errs == nil {
+		rep.Imported++
+		return
+	}
+	rep.Failed++
+	rep.Errors = append(rep.Errors, ImportRowError{Row: row.row, Email: row.user.Email, Errors: row.errs})
+}
+
+// Import bulk-loads users from r, which holds CSV or JSON as described by ImportFormat.
+// Each row is validated with User.Validate and must have a password. Passwords are hashed concurrently,
+// and rows are written in batches, one transaction per batch. A row whose email belongs to a live user updates
+// that user's name, active flag and password; otherwise it inserts a new user. If the model has insert or update
+// hooks, they run for each row in the batch's transaction. Invalid or rejected rows are reported per row and do
+// not stop the import; an error is returned only if the input cannot be read, a hook fails or the database
+// fails rather than rejecting a row, in which case the report covers the rows processed so far.
+func (u *User) Import(ctx context.Context, r io.Reader, opts ImportOptions) (*ImportReport, error) {
+	query, err := upsertUserSQL()
+	if err != nil {
+		return nil, err
+	}
+	hooked := hasHooks(&User{}, hookInsert) || hasHooks(&User{}, hookUpdate)
+	return runImport(ctx, r, opts, func(ctx context.Context, batch []*importRow) error {
+		defer u.scope.wrote()
+		now := time.Now()
+		args := make([][]interface{}, len(batch))
+		for i, row := range batch {
+			args[i] = upsertUserArgs(row.user, now)
+		}
+		writeRow := func(sess db.Session, i int) error {
+			if hooked {
+				return u.importUser(ctx, sess, batch[i].user, now)
+			}
+			_, err := sess.SQL().ExecContext(ctx, query, args[i]...)
+			return err
+		}
+
+		err := upper.TxContext(ctx, func(tx db.Session) error {
+			for i := range batch {
+				if err := writeRow(tx, i); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, nil)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !rowRejected(err) {
+				return err
+			}
+			// Some row was rejected, so the batch was rolled back; write the rows one at a time to find out which.
+			for i, row := range batch {
+				var err error
+				if hooked {
+					err = upper.TxContext(ctx, func(tx db.Session) error { return writeRow(tx, i) }, nil)
+				} else {
+					err = writeRow(upper, i)
+				}
+				if err != nil {
+					if !rowRejected(err) {
+						return err
+					}
+					row.fail("row", err.Error())
+				}
+			}
+		}
+		u.invalidateImported(batch)
+		return nil
+	})
+}
+
+// importUser writes one imported user in tx, the way the upsert does, but as a separate lookup and insert or
+// update so that the matching hooks run.
+func (u *User) importUser(ctx context.Context, tx db.Session, imported User, now time.Time) error {
+	var user User
+	err := tx.WithContext(ctx).Collection(u.Table()).Find(db.Cond{"email =": imported.Email}, notDeleted).One(&user)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		user = imported
+		user.CreatedAt = now
+		user.UpdatedAt = now
+		user.Version = 1
+		user.ID = NewID()
+		return withHooksTx(tx, &user, hookInsert, nil, func(sess db.Session) error {
+			res, err := sess.WithContext(ctx).Collection(u.Table()).Insert(user)
+			if err != nil {
+				return err
+			}
+			if user.ID.IsZero() {
+				user.ID, err = InsertID(res.ID())
+			}
+			return err
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	user.FirstName = imported.FirstName
+	user.LastName = imported.LastName
+	user.Active = imported.Active
+	user.Password = imported.Password
+	user.UpdatedAt = now
+	user.Version++
+	return withHooksTx(tx, &user, hookUpdate, nil, func(sess db.Session) error {
+		_, err := sess.WithContext(ctx).SQL().
+			Update(u.Table()).
+			Set("first_name", user.FirstName, "last_name", user.LastName, "user_active", user.Active,
+				"password", user.Password, "updated_at", now, "version = version + 1").
+			Where(db.Cond{"id =": user.ID}, notDeleted).
+			Exec()
+		return err
+	})
+}
+
+// rowRejected reports whether err is the database rejecting a row's data, such as a constraint violation or a
+// value out of range, rather than the database failing, such as a lost connection. Only rejected rows are
+// reported per row; any other error stops the import.
+func rowRejected(err error) bool {
+	var state interface{ SQLState() string } // PostgreSQL, through pgx or lib/pq
+	if errors.As(err, &state) {
+		return sqlStateRejectsRow(state.SQLState())
+	}
+	var my *mysql.MySQLError
+	if errors.As(err, &my) {
+		return sqlStateRejectsRow(string(my.SQLState[:]))
+	}
+	// The SQLite driver needs cgo for its error type, so match its messages for the constraint, datatype
+	// mismatch and too big result codes instead.
+	msg := err.Error()
+	return dialect == "sqlite" &&
+		(strings.Contains(msg, "constraint failed") || strings.Contains(msg, "datatype mismatch") || strings.Contains(msg, "too big"))
+}
+
+// sqlStateRejectsRow reports whether an SQLSTATE is a data exception (class 22) or an integrity constraint
+// violation (class 23).
+func sqlStateRejectsRow(state string) bool {
+	return strings.HasPrefix(state, "22") || strings.HasPrefix(state, "23")
+}
+
+// upsertUserSQL returns the statement that inserts a user, or updates the live user with the same email.
+func upsertUserSQL() (string, error) {
+	columns := "first_name, last_name, email, user_active, password, created_at, updated_at, version"
+	values := "?, ?, ?, ?, ?, ?, ?, 1"
+	if keyType != KeySerial {
+		columns = "id, " + columns
+		values = "?, " + values
+	}
+	insert := "INSERT INTO users (" + columns + ") VALUES (" + values + ")"
+
+	switch dialect {
+	case "postgres", "sqlite":
+		// The conflict target names the partial unique index on the email of live users.
+		return insert + " ON CONFLICT (email) WHERE deleted_at IS NULL DO UPDATE SET" +
+			" first_name = excluded.first_name, last_name = excluded.last_name, user_active = excluded.user_active," +
+			" password = excluded.password, updated_at = excluded.updated_at, version = users.version + 1", nil
+	case "mysql":
+		// The duplicate key is the unique index on live_email, which is NULL for soft-deleted users.
+		return insert + " ON DUPLICATE KEY UPDATE" +
+			" first_name = VALUES(first_name), last_name = VALUES(last_name), user_active = VALUES(user_active)," +
+			" password = VALUES(password), updated_at = VALUES(updated_at), version = version + 1", nil
+	default:
+		return "", fmt.Errorf("bulk import is not supported for %q", dialect)
+	}
+}
+
+// upsertUserArgs returns the arguments of upsertUserSQL for user.
+func upsertUserArgs(user User, now time.Time) []interface{} {
+	args := []interface{}{user.FirstName, user.LastName, user.Email, user.Active, user.Password, now, now}
+	if keyType != KeySerial {
+		args = append([]interface{}{NewID()}, args...)
+	}
+	return args
+}
+
+// invalidateImported removes the users updated by an import batch from the cache.
+func (u *User) invalidateImported(batch []*importRow) {
+	if cache == nil {
+		return
+	}
+	emails := make([]interface{}, 0, len(batch))
+	for _, row := range batch {
+		if row.errs == nil {
+			emails = append(emails, row.user.Email)
+		}
+	}
+	if len(emails) == 0 {
+		return
+	}
+	var users []*User
+	err := upper.Collection(u.Table()).Find(db.Cond{"email IN": emails}, notDeleted).Select("id").All(&users)
+	if err != nil {
+		return
+	}
+	keys := make([]string, len(users))
+	for i, user := range users {
+		keys[i] = userCacheKey(user.ID)
+	}
+	invalidate(keys...)
+}
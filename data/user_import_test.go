@@ -0,0 +1,170 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestMemoryUsers_Import tests that CSV and JSON imports insert new users, upsert existing ones by email,
+// and report invalid rows without stopping.
+func TestMemoryUsers_Import(t *testing.T) {
+	m := newMemoryModels(t)
+	id, err := m.Users.Insert(User{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Active: 0, Password: "old"})
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+
+	csv := "Email,First_Name,Last_Name,Active,Password,Role\n" +
+		"jane@example.com,Janet,Doe,true,new,admin\n" +
+		"bob@example.com,Bob,Smith,1,secret,\n" +
+		"ann@example.com,Ann,Other,maybe,secret,\n" +
+		"cid@example.com,Cid,Other,false,,\n"
+	report, err := m.Users.Import(context.Background(), strings.NewReader(csv), ImportOptions{Format: ImportCSV, BatchSize: 1, Workers: 2})
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if report.Rows != 4 || report.Imported != 2 || report.Failed != 2 {
+		t.Errorf("got %d rows, %d imported and %d failed, want 4, 2 and 2", report.Rows, report.Imported, report.Failed)
+	}
+	if got := fmt.Sprint(report.Errors); got != "[{3 ann@example.com map[active:Active must be an boolean]} {4 cid@example.com map[password:Password is required]}]" {
+		t.Errorf("unexpected errors: %s", got)
+	}
+
+	jane, err := m.Users.Get(id)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if jane.FirstName != "Janet" || jane.Active != 1 || jane.Version != 2 {
+		t.Errorf("existing user not updated: %+v", jane)
+	}
+	if ok, _ := jane.PasswordMatches("new"); !ok {
+		t.Error("expected the imported password to replace the old one")
+	}
+	bob, err := m.Users.GetByEmail("bob@example.com")
+	if err != nil {
+		t.Fatalf("imported user not found: %v", err)
+	}
+	if ok, _ := bob.PasswordMatches("secret"); !ok || bob.Active != 1 {
+		t.Errorf("unexpected imported user: %+v", bob)
+	}
+
+	for name, input := range map[string]string{
+		"Array":  `[{"first_name": "Dee", "last_name": "Jones", "email": "dee@example.com", "password": "secret", "active": false}]`,
+		"Stream": "{\"first_name\": \"Eve\", \"last_name\": \"Jones\", \"email\": \"eve@example.com\", \"password\": \"secret\"}\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			report, err := m.Users.Import(context.Background(), strings.NewReader(input), ImportOptions{Format: ImportJSON})
+			if err != nil || report.Imported != 1 || report.Failed != 0 {
+				t.Errorf("got %+v, %v", report, err)
+			}
+		})
+	}
+	if dee, _ := m.Users.GetByEmail("dee@example.com"); dee == nil || dee.Active != 0 {
+		t.Errorf("expected Dee to be imported inactive, got %+v", dee)
+	}
+	if eve, _ := m.Users.GetByEmail("eve@example.com"); eve == nil || eve.Active != 1 {
+		t.Errorf("expected Eve to be imported active, got %+v", eve)
+	}
+}
+
+// TestUser_ImportBatchErrors tests that a batch rolled back because of a rejected row is retried row by row,
+// while a database failure stops the import instead of marking every row failed.
+func TestUser_ImportBatchErrors(t *testing.T) {
+	t.Setenv("BCRYPT_COST", strconv.Itoa(bcrypt.MinCost))
+	input := "email,first_name,last_name,active,password\n" +
+		"jane@example.com,Jane,Doe,1,secret\n" +
+		"bob@example.com,Bob,Smith,1,secret\n"
+	duplicate := &pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint"}
+
+	t.Run("Rejected", func(t *testing.T) {
+		mock := newMockSession(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO users`).WillReturnError(duplicate)
+		mock.ExpectRollback()
+		mock.ExpectExec(`INSERT INTO users`).WillReturnError(duplicate)
+		mock.ExpectExec(`INSERT INTO users`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		report, err := (&User{}).Import(context.Background(), strings.NewReader(input), ImportOptions{Format: ImportCSV, BatchSize: 2, Workers: 1})
+		if err != nil {
+			t.Fatalf("Import() error = %v", err)
+		}
+		if report.Imported != 1 || report.Failed != 1 {
+			t.Errorf("got %d imported and %d failed, want 1 and 1", report.Imported, report.Failed)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		mock := newMockSession(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO users`).WillReturnError(errors.New("read tcp: connection reset by peer"))
+		mock.ExpectRollback()
+
+		report, err := (&User{}).Import(context.Background(), strings.NewReader(input), ImportOptions{Format: ImportCSV, BatchSize: 2, Workers: 1})
+		if err == nil {
+			t.Fatal("expected the database failure to stop the import")
+		}
+		if report != nil && report.Failed != 0 {
+			t.Errorf("expected no rows to be reported failed, got %d", report.Failed)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// TestImport_MalformedInput tests that unreadable input aborts the import with an error.
+func TestImport_MalformedInput(t *testing.T) {
+	m := newMemoryModels(t)
+	tests := []struct {
+		name   string
+		format ImportFormat
+		input  string
+	}{
+		{"EmptyCSV", ImportCSV, ""},
+		{"UnterminatedQuote", ImportCSV, "email\n\"bob@example.com\n"},
+		{"TruncatedJSON", ImportJSON, `[{"email": "bob@example.com"`},
+		{"UnknownFormat", "xml", "<users/>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.Users.Import(context.Background(), strings.NewReader(tt.input), ImportOptions{Format: tt.format})
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// TestUpsertUserSQL tests that each dialect binds exactly one argument per placeholder.
+func TestUpsertUserSQL(t *testing.T) {
+	oldDialect, oldKeyType := dialect, keyType
+	t.Cleanup(func() { dialect, keyType = oldDialect, oldKeyType })
+
+	for _, kt := range []KeyType{KeySerial, KeyUUID} {
+		for _, d := range []string{"postgres", "mysql", "sqlite"} {
+			t.Run(fmt.Sprintf("%s/%s", d, kt), func(t *testing.T) {
+				dialect, keyType = d, kt
+				query, err := upsertUserSQL()
+				if err != nil {
+					t.Fatalf("upsertUserSQL() error = %v", err)
+				}
+				args := upsertUserArgs(User{}, time.Now())
+				if n := strings.Count(query, "?"); n != len(args) {
+					t.Errorf("query has %d placeholders and %d args", n, len(args))
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/upper/db/v4"
+)
+
+// DefaultPerPage is the page size used when ListOptions.PerPage is not set.
+const DefaultPerPage = 20
+
+// MaxPerPage caps the page size a caller may request.
+const MaxPerPage = 100
+
+var (
+	// ErrInvalidSort is returned when a listing is sorted by a column that is not whitelisted.
+	ErrInvalidSort = errors.New("invalid sort column")
+	// ErrInvalidCursor is returned when a pagination cursor cannot be decoded or does not match the sort order.
+	ErrInvalidCursor = errors.New("invalid pagination cursor")
+)
+
+// userSortColumns whitelists the columns users can be sorted by, mapped to the kind of value they hold.
+var userSortColumns = map[string]string{
+	"id":         "id",
+	"first_name": "string",
+	"last_name":  "string",
+	"email":      "string",
+	"created_at": "time",
+	"updated_at": "time",
+}
+
+// UserSortColumns returns the whitelisted columns a user listing can be sorted by.
+func UserSortColumns() []string {
+	columns := make([]string, 0, len(userSortColumns))
+	for column := range userSortColumns {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// UserFilter narrows down a user listing. Zero-valued fields are ignored.
+type UserFilter struct {
+	Active        *bool     // only active (true) or inactive (false) users
+	Email         string    // exact email match
+	NamePrefix    string    // first or last name starts with this prefix
+	CreatedAfter  time.Time // created at or after this time
+	CreatedBefore time.Time // created before this time
+	Deleted       bool      // list soft-deleted users instead of live ones
+}
+
+// ListOptions controls filtering, sorting and pagination of a user listing.
+// Sort is a whitelisted column name, prefixed with "-" for descending order; it defaults to "last_name".
+// When Cursor is set, keyset pagination is used and Page is ignored.
+type ListOptions struct {
+	Filter  UserFilter
+	Sort    string
+	Page    int
+	PerPage int
+	Cursor  string
+}
+
+// UserPage is one page of a user listing.
+type UserPage struct {
+	Users      []*User `json:"users"`
+	Total      int     `json:"total"`
+	Page       int     `json:"page,omitempty"`
+	PerPage    int     `json:"per_page"`
+	TotalPages int     `json:"total_pages"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// HasNext reports whether there is a page after this one.
+func (p *UserPage) HasNext() bool {
+	return p.NextCursor != "" || p.Page > 0 && p.Page < p.TotalPages
+}
+
+// HasPrevious reports whether there is an offset page before this one.
+func (p *UserPage) HasPrevious() bool {
+	return p.Page > 1
+}
+
+// listCursor is the decoded form of a keyset pagination cursor.
+type listCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    ID     `json:"id"`
+}
+
+// normalize validates the options and fills in defaults.
+// It returns the sort column and whether the sort is descending.
+func (o *ListOptions) normalize() (string, bool, error) {
+	if o.Sort == "" {
+		o.Sort = "last_name"
+	}
+	column := strings.TrimPrefix(o.Sort, "-")
+	if _, ok := userSortColumns[column]; !ok {
+		return "", false, fmt.Errorf("%w: %s", ErrInvalidSort, column)
+	}
+	if o.PerPage <= 0 {
+		o.PerPage = DefaultPerPage
+	}
+	if o.PerPage > MaxPerPage {
+		o.PerPage = MaxPerPage
+	}
+	if o.Page <= 0 || o.Cursor != "" {
+		o.Page = 1
+	}
+	return column, strings.HasPrefix(o.Sort, "-"), nil
+}
+
+// encodeCursor builds an opaque cursor pointing just past the given user in the given sort order.
+func encodeCursor(sortBy string, u *User) string {
+	column := strings.TrimPrefix(sortBy, "-")
+	var value string
+	switch v := userSortValue(u, column).(type) {
+	case time.Time:
+		value = v.UTC().Format(time.RFC3339Nano)
+	default:
+		value = fmt.Sprint(v)
+	}
+	b, _ := json.Marshal(listCursor{Sort: sortBy, Value: value, ID: u.ID})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses a cursor and converts its value back to the sort column's type.
+func decodeCursor(cursor, sortBy string) (interface{}, ID, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, "", ErrInvalidCursor
+	}
+	var c listCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.Sort != sortBy || c.ID.IsZero() {
+		return nil, "", ErrInvalidCursor
+	}
+
+	switch userSortColumns[strings.TrimPrefix(sortBy, "-")] {
+	case "id":
+		return ID(c.Value), c.ID, nil
+	case "time":
+		v, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		return v, c.ID, nil
+	default:
+		return c.Value, c.ID, nil
+	}
+}
+
+// compareSortValues orders two values of the same sort column kind, returning -1, 0 or 1.
+func compareSortValues(a, b interface{}) int {
+	switch av := a.(type) {
+	case ID:
+		bv := b.(ID)
+		switch {
+		case av.Less(bv):
+			return -1
+		case bv.Less(av):
+			return 1
+		}
+	case string:
+		return strings.Compare(av, b.(string))
+	case time.Time:
+		return av.Compare(b.(time.Time))
+	}
+	return 0
+}
+
+// userSortValue returns the value of a whitelisted sort column for a user.
+func userSortValue(u *User, column string) interface{} {
+	switch column {
+	case "id":
+		return u.ID
+	case "first_name":
+		return u.FirstName
+	case "last_name":
+		return u.LastName
+	case "email":
+		return u.Email
+	case "created_at":
+		return u.CreatedAt
+	case "updated_at":
+		return u.UpdatedAt
+	}
+	return nil
+}
+
+// escapeLike escapes the LIKE wildcards in s so it is matched literally.
+// It uses "!" as the escape character because a backslash needs different quoting in MySQL and PostgreSQL.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(s)
+}
+
+// conditions converts the filter into upper conditions.
+func (f UserFilter) conditions() []db.LogicalExpr {
+	conds := []db.LogicalExpr{notDeleted}
+	if f.Deleted {
+		conds = []db.LogicalExpr{db.Cond{"deleted_at": db.IsNotNull()}}
+	}
+	if f.Active != nil {
+		if *f.Active {
+			conds = append(conds, db.Cond{"user_active": 1})
+		} else {
+			conds = append(conds, db.Cond{"user_active <>": 1})
+		}
+	}
+	if f.Email != "" {
+		conds = append(conds, db.Cond{"email": f.Email})
+	}
+	if f.NamePrefix != "" {
+		prefix := escapeLike(f.NamePrefix) + "%"
+		conds = append(conds, db.Or(
+			db.Raw(`first_name LIKE ? ESCAPE '!'`, prefix),
+			db.Raw(`last_name LIKE ? ESCAPE '!'`, prefix),
+		))
+	}
+	if !f.CreatedAfter.IsZero() {
+		conds = append(conds, db.Cond{"created_at >=": f.CreatedAfter})
+	}
+	if !f.CreatedBefore.IsZero() {
+		conds = append(conds, db.Cond{"created_at <": f.CreatedBefore})
+	}
+	return conds
+}
+
+// matches reports whether a user passes the filter; it mirrors conditions for stores that are not SQL-backed.
+func (f UserFilter) matches(u *User) bool {
+	if u.IsDeleted() != f.Deleted {
+		return false
+	}
+	if f.Active != nil && (u.Active == 1) != *f.Active {
+		return false
+	}
+	if f.Email != "" && u.Email != f.Email {
+		return false
+	}
+	if f.NamePrefix != "" && !strings.HasPrefix(u.FirstName, f.NamePrefix) && !strings.HasPrefix(u.LastName, f.NamePrefix) {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && u.CreatedAt.Before(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !u.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// List retrieves one page of users matching the options' filter, in the requested order.
+// It supports both offset pagination (Page) and keyset pagination (Cursor); the returned page
+// always carries the total number of matching users and, when more rows exist, a cursor to the next page.
+func (u *User) List(opts ListOptions) (*UserPage, error) {
+	column, desc, err := opts.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	var id ID
+	if opts.Cursor != "" {
+		if value, id, err = decodeCursor(opts.Cursor, opts.Sort); err != nil {
+			return nil, err
+		}
+	}
+
+	order := []interface{}{column, "id"}
+	if desc {
+		order = []interface{}{"-" + column, "-id"}
+	}
+
+	var total uint64
+	var users []*User
+	err = u.scope.read(func(sess db.Session) (err error) {
+		res := sess.Collection(u.Table()).Find(db.And(opts.Filter.conditions()...))
+		if total, err = res.Count(); err != nil {
+			return err
+		}
+
+		if opts.Cursor != "" {
+			op := ">"
+			if desc {
+				op = "<"
+			}
+			res = res.And(db.Or(
+				db.Cond{column + " " + op: value},
+				db.And(db.Cond{column: value}, db.Cond{"id " + op: id}),
+			))
+		} else {
+			res = res.Offset((opts.Page - 1) * opts.PerPage)
+		}
+
+		users = nil
+		return res.OrderBy(order...).Limit(opts.PerPage + 1).All(&users)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newUserPage(users, int(total), opts), nil
+}
+
+// newUserPage trims the look-ahead row fetched by a listing and builds the page metadata.
+func newUserPage(users []*User, total int, opts ListOptions) *UserPage {
+	page := &UserPage{
+		Users:      users,
+		Total:      total,
+		PerPage:    opts.PerPage,
+		TotalPages: (total + opts.PerPage - 1) / opts.PerPage,
+	}
+	if opts.Cursor == "" {
+		page.Page = opts.Page
+	}
+	if len(users) > opts.PerPage {
+		page.Users = users[:opts.PerPage]
+		page.NextCursor = encodeCursor(opts.Sort, page.Users[opts.PerPage-1])
+	}
+	if page.Users == nil {
+		page.Users = []*User{}
+	}
+	return page
+}
@@ -0,0 +1,87 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// TestMemoryUsers_List tests filtering, sorting and both pagination modes against the in-memory store.
+func TestMemoryUsers_List(t *testing.T) {
+	m := newMemoryModels(t)
+	for i, first := range []string{"Eve", "Bob", "Ann", "Dee", "Cid"} {
+		_, err := m.Users.Insert(User{FirstName: first, LastName: "Tester", Active: i % 2, Email: fmt.Sprintf("u%d@example.com", i), Password: "secret"})
+		if err != nil {
+			t.Fatalf("failed to insert user: %v", err)
+		}
+	}
+
+	opts := ListOptions{Sort: "first_name", PerPage: 2}
+	var names []string
+	pages := 0
+	for {
+		page, err := m.Users.List(opts)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if page.Total != 5 || page.TotalPages != 3 {
+			t.Fatalf("got total %d and %d pages, want 5 and 3", page.Total, page.TotalPages)
+		}
+		for _, u := range page.Users {
+			names = append(names, u.FirstName)
+		}
+		pages++
+		if !page.HasNext() {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	if got := fmt.Sprint(names); got != "[Ann Bob Cid Dee Eve]" || pages != 3 {
+		t.Errorf("cursor pagination got %s in %d pages", got, pages)
+	}
+
+	active := false
+	tests := []struct {
+		name string
+		opts ListOptions
+		want string
+	}{
+		{"OffsetDescending", ListOptions{Sort: "-first_name", Page: 2, PerPage: 2}, "[Cid Bob]"},
+		{"PastLastPage", ListOptions{Page: 9}, "[]"},
+		{"Inactive", ListOptions{Sort: "first_name", Filter: UserFilter{Active: &active}}, "[Ann Cid Eve]"},
+		{"Email", ListOptions{Filter: UserFilter{Email: "u1@example.com"}}, "[Bob]"},
+		{"NamePrefix", ListOptions{Filter: UserFilter{NamePrefix: "De"}}, "[Dee]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := m.Users.List(tt.opts)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			var got []string
+			for _, u := range page.Users {
+				got = append(got, u.FirstName)
+			}
+			if fmt.Sprint(got) != tt.want {
+				t.Errorf("got %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestListOptions_Errors tests that unknown sort columns and tampered cursors are rejected.
+func TestListOptions_Errors(t *testing.T) {
+	m := newMemoryModels(t)
+
+	if _, err := m.Users.List(ListOptions{Sort: "password"}); !errors.Is(err, ErrInvalidSort) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidSort)
+	}
+	if _, err := m.Users.List(ListOptions{Cursor: "not-a-cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidCursor)
+	}
+
+	cursor := encodeCursor("email", &User{ID: "1", Email: "a@example.com"})
+	if _, err := m.Users.List(ListOptions{Sort: "-email", Cursor: cursor}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("cursor reused with a different sort: got error %v, want %v", err, ErrInvalidCursor)
+	}
+}
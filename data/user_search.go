@@ -0,0 +1,170 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/upper/db/v4"
+)
+
+// userColumns lists the users columns selected by hand-written queries.
+const userColumns = "users.id, users.first_name, users.last_name, users.email, users.user_active, users.password, " +
+	"users.created_at, users.updated_at, users.deleted_at, users.version"
+
+// searchTerms splits a search query into words, dropping punctuation so that no query syntax of the
+// underlying full-text engine can be injected. An email address becomes its parts, like the indexed text.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// searchQuery is a full-text user search compiled for the current dialect.
+type searchQuery struct {
+	countSQL  string
+	countArgs []interface{}
+	pageSQL   string
+	pageArgs  []interface{}
+}
+
+// newSearchQuery builds the count and page queries for a full-text search in the current dialect.
+// Every term must match, as a prefix, one of first name, last name or email; results are ranked by relevance.
+func newSearchQuery(terms []string, limit, offset int) (*searchQuery, error) {
+	parts := make([]string, len(terms))
+	switch dialect {
+	case "postgres":
+		// The search column is a weighted tsvector kept up to date by the database; see the migrations.
+		for i, term := range terms {
+			parts[i] = term + ":*"
+		}
+		match := strings.Join(parts, " & ")
+		from := "FROM users WHERE search @@ to_tsquery('simple', ?) AND deleted_at IS NULL"
+		return &searchQuery{
+			countSQL:  "SELECT COUNT(*) " + from,
+			countArgs: []interface{}{match},
+			pageSQL: "SELECT " + userColumns + " " + from +
+				" ORDER BY ts_rank(search, to_tsquery('simple', ?)) DESC, users.id LIMIT ? OFFSET ?",
+			pageArgs: []interface{}{match, match, limit, offset},
+		}, nil
+	case "mysql":
+		for i, term := range terms {
+			parts[i] = "+" + term + "*"
+		}
+		match := strings.Join(parts, " ")
+		against := "MATCH (first_name, last_name, email) AGAINST (? IN BOOLEAN MODE)"
+		from := "FROM users WHERE " + against + " AND deleted_at IS NULL"
+		return &searchQuery{
+			countSQL:  "SELECT COUNT(*) " + from,
+			countArgs: []interface{}{match},
+			pageSQL:   "SELECT " + userColumns + " " + from + " ORDER BY " + against + " DESC, users.id LIMIT ? OFFSET ?",
+			pageArgs:  []interface{}{match, match, limit, offset},
+		}, nil
+	case "sqlite":
+		// users_fts is an external-content FTS5 table over users, kept in sync by triggers; bm25 is lower for better matches.
+		for i, term := range terms {
+			parts[i] = `"` + term + `"*`
+		}
+		match := strings.Join(parts, " AND ")
+		from := "FROM users JOIN users_fts ON users_fts.rowid = users.rowid WHERE users_fts MATCH ? AND users.deleted_at IS NULL"
+		return &searchQuery{
+			countSQL:  "SELECT COUNT(*) " + from,
+			countArgs: []interface{}{match},
+			pageSQL:   "SELECT " + userColumns + " " + from + " ORDER BY bm25(users_fts), users.id LIMIT ? OFFSET ?",
+			pageArgs:  []interface{}{match, limit, offset},
+		}, nil
+	default:
+		return nil, fmt.Errorf("full-text search is not supported for %q", dialect)
+	}
+}
+
+// Search finds live users whose first name, last name or email match every word of the query, best matches
+// first, and returns the given page of results. An empty query matches nothing.
+func (u *User) Search(query string, page int) (*UserPage, error) {
+	opts := ListOptions{Page: page}
+	if _, _, err := opts.normalize(); err != nil {
+		return nil, err
+	}
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return newSearchPage(nil, 0, opts), nil
+	}
+
+	q, err := newSearchQuery(terms, opts.PerPage, (opts.Page-1)*opts.PerPage)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int
+	var users []*User
+	err = u.scope.read(func(sess db.Session) error {
+		row, err := sess.SQL().QueryRow(q.countSQL, q.countArgs...)
+		if err != nil {
+			return err
+		}
+		if err := row.Scan(&total); err != nil {
+			return err
+		}
+		users = nil
+		return sess.SQL().Iterator(q.pageSQL, q.pageArgs...).All(&users)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newSearchPage(users, total, opts), nil
+}
+
+// newSearchPage builds the page metadata for a search result.
+func newSearchPage(users []*User, total int, opts ListOptions) *UserPage {
+	if users == nil {
+		users = []*User{}
+	}
+	return &UserPage{
+		Users:      users,
+		Total:      total,
+		Page:       opts.Page,
+		PerPage:    opts.PerPage,
+		TotalPages: (total + opts.PerPage - 1) / opts.PerPage,
+	}
+}
+
+// searchScore ranks a user against search terms for stores without a full-text engine.
+// It returns 0 unless every term prefixes a word of the user's name or email, and favours name matches.
+func searchScore(u *User, terms []string) int {
+	name := searchTerms(u.FirstName + " " + u.LastName)
+	email := searchTerms(u.Email)
+	score := 0
+	for _, term := range terms {
+		switch {
+		case hasPrefixWord(name, term):
+			score += 2
+		case hasPrefixWord(email, term):
+			score++
+		default:
+			return 0
+		}
+	}
+	return score
+}
+
+// hasPrefixWord reports whether any of words starts with prefix.
+func hasPrefixWord(words []string, prefix string) bool {
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// rankSearch orders users by descending score, then by ID.
+func rankSearch(users []*User, scores map[ID]int) {
+	sort.SliceStable(users, func(i, j int) bool {
+		si, sj := scores[users[i].ID], scores[users[j].ID]
+		if si != sj {
+			return si > sj
+		}
+		return users[i].ID.Less(users[j].ID)
+	})
+}
@@ -0,0 +1,92 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestMemoryUsers_Search tests ranking, prefix matching and pagination of the in-memory search.
+func TestMemoryUsers_Search(t *testing.T) {
+	m := newMemoryModels(t)
+	for _, u := range []User{
+		{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com"},
+		{FirstName: "John", LastName: "Smith", Email: "janedoe@corp.io"},
+		{FirstName: "Bob", LastName: "Janeway", Email: "bob@example.com"},
+		{FirstName: "Ann", LastName: "Other", Email: "ann@example.com"},
+	} {
+		u.Password = "secret"
+		if _, err := m.Users.Insert(u); err != nil {
+			t.Fatalf("failed to insert user: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"jane", "[Jane Bob John]"},
+		{"JAN doe", "[Jane]"},
+		{"example", "[Jane Bob Ann]"},
+		{"corp.io", "[John]"},
+		{"nobody", "[]"},
+		{`"*) OR 1=1 --`, "[]"},
+		{"  ", "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			page, err := m.Users.Search(tt.query, 1)
+			if err != nil {
+				t.Fatalf("Search() error = %v", err)
+			}
+			var got []string
+			for _, u := range page.Users {
+				got = append(got, u.FirstName)
+			}
+			if fmt.Sprint(got) != tt.want {
+				t.Errorf("got %v, want %s", got, tt.want)
+			}
+		})
+	}
+
+	page, err := m.Users.Search("example", 2)
+	if err != nil || page.Total != 3 || len(page.Users) != 0 || page.HasNext() {
+		t.Errorf("unexpected second page: %+v, %v", page, err)
+	}
+}
+
+// TestNewSearchQuery tests that each dialect binds exactly one argument per placeholder
+// and that query syntax in the search terms never reaches the match expression.
+func TestNewSearchQuery(t *testing.T) {
+	old := dialect
+	t.Cleanup(func() { dialect = old })
+
+	terms := searchTerms(`jane" OR * -doe`)
+	if fmt.Sprint(terms) != "[jane or doe]" {
+		t.Fatalf("searchTerms() = %v", terms)
+	}
+
+	for _, d := range []string{"postgres", "mysql", "sqlite"} {
+		t.Run(d, func(t *testing.T) {
+			dialect = d
+			q, err := newSearchQuery(terms, 20, 40)
+			if err != nil {
+				t.Fatalf("newSearchQuery() error = %v", err)
+			}
+			if n := strings.Count(q.countSQL, "?"); n != len(q.countArgs) {
+				t.Errorf("count query has %d placeholders and %d args", n, len(q.countArgs))
+			}
+			if n := strings.Count(q.pageSQL, "?"); n != len(q.pageArgs) {
+				t.Errorf("page query has %d placeholders and %d args", n, len(q.pageArgs))
+			}
+			if !strings.Contains(q.pageSQL, "deleted_at IS NULL") {
+				t.Error("expected soft-deleted users to be excluded")
+			}
+		})
+	}
+
+	dialect = "oracle"
+	if _, err := newSearchQuery(terms, 20, 0); err == nil {
+		t.Error("expected an error for an unsupported dialect")
+	}
+}
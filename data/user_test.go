@@ -0,0 +1,52 @@
+package data
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// TestUniqueViolation tests that duplicate keys are recognised for each dialect, and other errors are not.
+func TestUniqueViolation(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect string
+		err     error
+		want    bool
+	}{
+		{"Postgres", "postgres", &pgconn.PgError{Code: "23505"}, true},
+		{"PostgresForeignKey", "postgres", &pgconn.PgError{Code: "23503"}, false},
+		{"MySQL", "mysql", &mysql.MySQLError{Number: 1062}, true},
+		{"MySQLNotNull", "mysql", &mysql.MySQLError{Number: 1048}, false},
+		{"SQLite", "sqlite", errors.New("UNIQUE constraint failed: users.email"), true},
+		{"SQLiteMessageElsewhere", "postgres", errors.New("UNIQUE constraint failed: users.email"), false},
+		{"Other", "postgres", errors.New("connection reset by peer"), false},
+		{"Nil", "postgres", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := dialect
+			dialect = tt.dialect
+			defer func() { dialect = old }()
+			if got := uniqueViolation(tt.err); got != tt.want {
+				t.Errorf("uniqueViolation(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestUser_UpdateDuplicateEmail tests that an update rejected by the unique email index returns ErrDuplicateEmail.
+func TestUser_UpdateDuplicateEmail(t *testing.T) {
+	mock := newMockSession(t)
+	mock.ExpectExec(`UPDATE "users"`).WillReturnError(&pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint"})
+
+	err := (&User{}).Update(User{ID: "1", FirstName: "Jane", Email: "taken@example.com", Version: 1})
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("Update() error = %v, want ErrDuplicateEmail", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
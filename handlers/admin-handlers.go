@@ -0,0 +1,457 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/CloudyKit/jet/v6"
+	"github.com/go-chi/chi/v5"
+	"github.com/jorgeSader/devify"
+	"github.com/jorgeSader/devify-test-app/data"
+	"github.com/upper/db/v4"
+)
+
+// conflictMessage is shown when an edit is rejected because the user changed since the form was loaded.
+const conflictMessage = "This user was changed by someone else while you were editing. " +
+	"The form now shows the latest values; please re-apply your changes and save again."
+
+// AdminUsers renders a paginated, filterable list of users.
+// When the q query parameter is set it shows full-text search results for it instead.
+func (h *Handlers) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	opts, err := h.userListOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var page *data.UserPage
+	if q := r.URL.Query().Get("q"); q != "" {
+		page, err = h.models(r).Users.Search(q, opts.Page)
+	} else {
+		page, err = h.models(r).Users.List(opts)
+	}
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidSort) || errors.Is(err, data.ErrInvalidCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.App.ErrorLog.Println("error listing users:", err)
+		h.App.Error500(w)
+		return
+	}
+
+	vars := make(jet.VarMap)
+	vars.Set("page", page)
+	vars.Set("query", r.URL.Query())
+	vars.Set("sortColumns", data.UserSortColumns())
+	if page.HasPrevious() {
+		vars.Set("prevURL", pageURL(r.URL, page.Page-1))
+	} else {
+		vars.Set("prevURL", "")
+	}
+	if page.HasNext() {
+		vars.Set("nextURL", pageURL(r.URL, page.Page+1))
+	} else {
+		vars.Set("nextURL", "")
+	}
+
+	err = h.App.Render.Page(w, r, "admin-users", nil, vars)
+	if err != nil {
+		h.App.ErrorLog.Println("error rendering:", err)
+	}
+}
+
+// pageURL returns u with its page query parameter set to page, keeping every other parameter.
+func pageURL(u *url.URL, page int) string {
+	q := u.Query()
+	q.Del("cursor")
+	q.Set("page", strconv.Itoa(page))
+	next := *u
+	next.RawQuery = q.Encode()
+	return next.RequestURI()
+}
+
+// AdminUserEdit renders the edit form for a single user.
+// The form carries the user's version so a concurrent edit can be detected on save.
+func (h *Handlers) AdminUserEdit(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.adminUser(w, r)
+	if !ok {
+		return
+	}
+	h.renderUserEdit(w, r, http.StatusOK, user, h.App.Validator(r), "")
+}
+
+// AdminUserUpdate saves the edit form for a single user.
+// If the user was changed after the form was loaded, the form is shown again with the latest values
+// and a conflict message, and the response status is 409 Conflict.
+func (h *Handlers) AdminUserUpdate(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	current, ok := h.adminUser(w, r)
+	if !ok {
+		return
+	}
+	version, err := strconv.Atoi(r.Form.Get("version"))
+	if err != nil {
+		http.Error(w, "invalid version", http.StatusBadRequest)
+		return
+	}
+
+	edited := *current
+	edited.FirstName = r.Form.Get("first_name")
+	edited.LastName = r.Form.Get("last_name")
+	edited.Email = r.Form.Get("email")
+	edited.Active, _ = strconv.Atoi(r.Form.Get("active"))
+	edited.Version = version
+
+	validator := h.App.Validator(r)
+	edited.Validate(validator)
+	if validator.Valid() && edited.Email != current.Email {
+		inUse, err := h.emailInUse(r, edited.Email, current.ID)
+		if err != nil {
+			h.App.ErrorLog.Println("error getting user by email:", err)
+			h.App.Error500(w)
+			return
+		}
+		if inUse {
+			validator.AddError("email", emailInUseMessage)
+		}
+	}
+	if !validator.Valid() {
+		h.renderUserEdit(w, r, http.StatusUnprocessableEntity, &edited, validator, "")
+		return
+	}
+
+	err = h.models(r).Users.Update(edited)
+	switch {
+	case errors.Is(err, data.ErrStaleObject):
+		latest, err := h.models(r).Users.Get(current.ID)
+		if err != nil {
+			h.App.ErrorLog.Println("error reloading user:", err)
+			h.App.Error500(w)
+			return
+		}
+		h.renderUserEdit(w, r, http.StatusConflict, latest, h.App.Validator(r), conflictMessage)
+		return
+	case errors.Is(err, db.ErrNoMoreRows):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		h.App.ErrorLog.Println("error updating user:", err)
+		h.App.Error500(w)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// Limits for uploaded import files. Parts beyond maxImportMemory are buffered on disk by the multipart reader.
+const (
+	maxImportSize   = 100 << 20
+	maxImportMemory = 8 << 20
+)
+
+// AdminUsersImport renders the bulk import upload form.
+func (h *Handlers) AdminUsersImport(w http.ResponseWriter, r *http.Request) {
+	h.renderUsersImport(w, r, http.StatusOK, nil, "")
+}
+
+// AdminUsersImportUpload imports users from an uploaded CSV or JSON file, whose format is taken from the
+// format field or else the file name. It renders the per-row report, or responds with it as JSON if the
+// client accepts JSON.
+func (h *Handlers) AdminUsersImportUpload(w http.ResponseWriter, r *http.Request) {
+	wantJSON := wantsJSON(r)
+	fail := func(status int, report *data.ImportReport, message string) {
+		if wantJSON {
+			payload := struct {
+				Error   bool               `json:"error"`
+				Message string             `json:"message"`
+				Report  *data.ImportReport `json:"report,omitempty"`
+			}{true, message, report}
+			_ = h.App.WriteJSON(w, status, payload)
+			return
+		}
+		h.renderUsersImport(w, r, status, report, message)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportMemory); err != nil {
+		fail(http.StatusBadRequest, nil, "invalid upload: "+err.Error())
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		fail(http.StatusBadRequest, nil, "a file is required")
+		return
+	}
+	defer file.Close()
+
+	name := r.FormValue("format")
+	if name == "" {
+		name = header.Filename
+	}
+	format, err := data.ParseImportFormat(name)
+	if err != nil {
+		fail(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	report, err := h.models(r).Users.Import(r.Context(), file, data.ImportOptions{Format: format})
+	if err != nil {
+		h.App.ErrorLog.Println("error importing users:", err)
+		fail(http.StatusUnprocessableEntity, report, "import stopped: "+err.Error())
+		return
+	}
+
+	if wantJSON {
+		_ = h.App.WriteJSON(w, http.StatusOK, report)
+		return
+	}
+	h.renderUsersImport(w, r, http.StatusOK, report, "")
+}
+
+// renderUsersImport renders the import page with the given status code and, after an upload, its report.
+func (h *Handlers) renderUsersImport(w http.ResponseWriter, r *http.Request, status int, report *data.ImportReport, message string) {
+	vars := make(jet.VarMap)
+	vars.Set("report", report)
+	vars.Set("message", message)
+
+	w.WriteHeader(status)
+	err := h.App.Render.Page(w, r, "admin-users-import", nil, vars)
+	if err != nil {
+		h.App.ErrorLog.Println("error rendering:", err)
+	}
+}
+
+// userJSON is the JSON representation of a user. It never includes the password.
+type userJSON struct {
+	ID        data.ID   `json:"id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Email     string    `json:"email"`
+	Active    int       `json:"active"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// newUserJSON converts a user into its JSON representation.
+func newUserJSON(u *data.User) userJSON {
+	return userJSON{
+		ID:        u.ID,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Email:     u.Email,
+		Active:    u.Active,
+		Version:   u.Version,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}
+}
+
+// userInput is the JSON body accepted when updating a user.
+// Version must be the version the client last read.
+type userInput struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Active    int    `json:"active"`
+	Version   int    `json:"version"`
+}
+
+// validator validates the input with the same rules as the HTML form.
+func (in userInput) validator(h *Handlers) *devify.Validation {
+	form := url.Values{}
+	form.Set("first_name", in.FirstName)
+	form.Set("last_name", in.LastName)
+	form.Set("email", in.Email)
+	form.Set("active", strconv.Itoa(in.Active))
+	return h.App.Validator(&http.Request{Form: form})
+}
+
+// AdminUserUpdateJSON updates a single user from a JSON body.
+// It responds with the updated user as stored, or with 409 Conflict and the current user if the given version is out
+// of date. An email address already in use by another user is also a 409 Conflict.
+func (h *Handlers) AdminUserUpdateJSON(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Error   bool              `json:"error"`
+		Message string            `json:"message,omitempty"`
+		Errors  map[string]string `json:"errors,omitempty"`
+		User    *userJSON         `json:"user,omitempty"`
+	}
+
+	current, ok := h.adminUser(w, r)
+	if !ok {
+		return
+	}
+
+	var in userInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		payload.Error = true
+		payload.Message = "invalid JSON body"
+		_ = h.App.WriteJSON(w, http.StatusBadRequest, payload)
+		return
+	}
+	if in.Version <= 0 {
+		payload.Error = true
+		payload.Message = "version is required"
+		_ = h.App.WriteJSON(w, http.StatusBadRequest, payload)
+		return
+	}
+
+	edited := *current
+	edited.FirstName = in.FirstName
+	edited.LastName = in.LastName
+	edited.Email = in.Email
+	edited.Active = in.Active
+	edited.Version = in.Version
+
+	validator := in.validator(h)
+	edited.Validate(validator)
+	if !validator.Valid() {
+		payload.Error = true
+		payload.Message = "validation failed"
+		payload.Errors = validator.Errors
+		_ = h.App.WriteJSON(w, http.StatusUnprocessableEntity, payload)
+		return
+	}
+	if edited.Email != current.Email {
+		if taken, ok := h.emailTaken(w, r, edited.Email, current.ID); !ok || taken {
+			return
+		}
+	}
+
+	err := h.models(r).Users.Update(edited)
+	switch {
+	case errors.Is(err, data.ErrStaleObject):
+		latest, err := h.models(r).Users.Get(current.ID)
+		if err != nil {
+			h.App.ErrorLog.Println("error reloading user:", err)
+			h.App.Error500(w)
+			return
+		}
+		out := newUserJSON(latest)
+		payload.Error = true
+		payload.Message = data.ErrStaleObject.Error()
+		payload.User = &out
+		_ = h.App.WriteJSON(w, http.StatusConflict, payload)
+		return
+	case errors.Is(err, db.ErrNoMoreRows):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		h.App.ErrorLog.Println("error updating user:", err)
+		h.App.Error500(w)
+		return
+	}
+
+	updated, err := h.models(r).Users.Get(current.ID)
+	if err != nil {
+		h.App.ErrorLog.Println("error reloading user:", err)
+		h.App.Error500(w)
+		return
+	}
+	out := newUserJSON(updated)
+	payload.User = &out
+	_ = h.App.WriteJSON(w, http.StatusOK, payload)
+}
+
+// emailInUseMessage is the validation error for an email address that belongs to another user.
+const emailInUseMessage = "Email is already in use"
+
+// emailInUse reports whether a user other than except has the given email address.
+func (h *Handlers) emailInUse(r *http.Request, email string, except data.ID) (bool, error) {
+	existing, err := h.models(r).Users.GetByEmail(email)
+	switch {
+	case errors.Is(err, db.ErrNoMoreRows) || errors.Is(err, db.ErrNilRecord):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return existing.ID != except, nil
+}
+
+// adminUser loads the user named by the id URL parameter.
+// It writes a 404 or 500 response and returns false if the user cannot be loaded.
+func (h *Handlers) adminUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := data.ParseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	user, err := h.models(r).Users.Get(id)
+	if err != nil {
+		if errors.Is(err, db.ErrNoMoreRows) || errors.Is(err, db.ErrNilRecord) {
+			http.NotFound(w, r)
+			return nil, false
+		}
+		h.App.ErrorLog.Println("error getting user:", err)
+		h.App.Error500(w)
+		return nil, false
+	}
+	return user, true
+}
+
+// renderUserEdit renders the user edit form with the given status code.
+func (h *Handlers) renderUserEdit(w http.ResponseWriter, r *http.Request, status int, user *data.User, validator *devify.Validation, conflict string) {
+	vars := make(jet.VarMap)
+	vars.Set("user", user)
+	vars.Set("validator", validator)
+	vars.Set("conflict", conflict)
+
+	w.WriteHeader(status)
+	err := h.App.Render.Page(w, r, "admin-user-edit", nil, vars)
+	if err != nil {
+		h.App.ErrorLog.Println("error rendering:", err)
+	}
+}
+
+// APIUserSearch responds with one page of full-text search results for the q query parameter.
+func (h *Handlers) APIUserSearch(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Error      bool       `json:"error"`
+		Message    string     `json:"message,omitempty"`
+		Users      []userJSON `json:"users"`
+		Total      int        `json:"total"`
+		Page       int        `json:"page"`
+		PerPage    int        `json:"per_page"`
+		TotalPages int        `json:"total_pages"`
+	}
+
+	page := 1
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			payload.Error = true
+			payload.Message = "invalid page: " + strconv.Quote(v)
+			_ = h.App.WriteJSON(w, http.StatusBadRequest, payload)
+			return
+		}
+		page = n
+	}
+
+	result, err := h.models(r).Users.Search(r.URL.Query().Get("q"), page)
+	if err != nil {
+		h.App.ErrorLog.Println("error searching users:", err)
+		h.App.Error500(w)
+		return
+	}
+
+	payload.Users = make([]userJSON, len(result.Users))
+	for i, u := range result.Users {
+		payload.Users[i] = newUserJSON(u)
+	}
+	payload.Total = result.Total
+	payload.Page = result.Page
+	payload.PerPage = result.PerPage
+	payload.TotalPages = result.TotalPages
+	_ = h.App.WriteJSON(w, http.StatusOK, payload)
+}
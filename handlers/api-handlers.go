@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jorgeSader/devify-test-app/data"
+	"github.com/upper/db/v4"
+)
+
+// maxJSONBody is the largest request body the JSON API reads.
+const maxJSONBody = 1 << 20
+
+// apiPayload is the envelope of JSON API responses. Errors maps invalid fields to their messages.
+type apiPayload struct {
+	Error   bool              `json:"error"`
+	Message string            `json:"message,omitempty"`
+	Errors  map[string]string `json:"errors,omitempty"`
+	User    *userJSON         `json:"user,omitempty"`
+}
+
+// newUserRequest is the JSON body accepted when creating a user. Active defaults to 1 when absent.
+type newUserRequest struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Active    *int   `json:"active"`
+	Password  string `json:"password"`
+}
+
+// userPatch is the JSON body accepted when patching a user; absent fields are left alone. If Version is
+// given, the patch is rejected with 409 Conflict when the user has changed since that version was read.
+type userPatch struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Email     *string `json:"email"`
+	Active    *int    `json:"active"`
+	Version   *int    `json:"version"`
+}
+
+// APIUsers responds with one page of users, filtered and sorted by the same query parameters as the admin
+// user list.
+func (h *Handlers) APIUsers(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Error      bool       `json:"error"`
+		Users      []userJSON `json:"users"`
+		Total      int        `json:"total"`
+		Page       int        `json:"page,omitempty"`
+		PerPage    int        `json:"per_page"`
+		TotalPages int        `json:"total_pages"`
+		NextCursor string     `json:"next_cursor,omitempty"`
+	}
+
+	opts, err := h.userListOptions(r)
+	if err != nil {
+		h.apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, err := h.models(r).Users.List(opts)
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidSort) || errors.Is(err, data.ErrInvalidCursor) {
+			h.apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.App.ErrorLog.Println("error listing users:", err)
+		h.App.Error500(w)
+		return
+	}
+
+	payload.Users = make([]userJSON, len(page.Users))
+	for i, u := range page.Users {
+		payload.Users[i] = newUserJSON(u)
+	}
+	payload.Total = page.Total
+	payload.Page = page.Page
+	payload.PerPage = page.PerPage
+	payload.TotalPages = page.TotalPages
+	payload.NextCursor = page.NextCursor
+	_ = h.App.WriteJSON(w, http.StatusOK, payload)
+}
+
+// APIUser responds with a single user.
+func (h *Handlers) APIUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.apiUser(w, r)
+	if !ok {
+		return
+	}
+	out := newUserJSON(user)
+	_ = h.App.WriteJSON(w, http.StatusOK, apiPayload{User: &out})
+}
+
+// APIUserCreate creates a user from a JSON body and responds with 201 Created, the new user and its URL in
+// the Location header. An email address already in use is a 409 Conflict, even if another request claims it
+// between the check and the insert.
+func (h *Handlers) APIUserCreate(w http.ResponseWriter, r *http.Request) {
+	var in newUserRequest
+	if err := decodeJSON(w, r, &in); err != nil {
+		h.apiError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	user := data.User{FirstName: in.FirstName, LastName: in.LastName, Email: in.Email, Active: 1, Password: in.Password}
+	if in.Active != nil {
+		user.Active = *in.Active
+	}
+	validator := userInput{FirstName: user.FirstName, LastName: user.LastName, Email: user.Email, Active: user.Active}.validator(h)
+	user.Validate(validator)
+	if in.Password == "" {
+		validator.AddError("password", "Password is required")
+	}
+	if !validator.Valid() {
+		_ = h.App.WriteJSON(w, http.StatusUnprocessableEntity, apiPayload{Error: true, Message: "validation failed", Errors: validator.Errors})
+		return
+	}
+	if taken, ok := h.emailTaken(w, r, user.Email, ""); !ok || taken {
+		return
+	}
+
+	id, err := h.models(r).Users.Insert(user)
+	if errors.Is(err, data.ErrDuplicateEmail) {
+		h.emailConflict(w) // another request took the address since the check
+		return
+	}
+	if err != nil {
+		h.App.ErrorLog.Println("error inserting user:", err)
+		h.App.Error500(w)
+		return
+	}
+	created, err := h.models(r).Users.Get(id)
+	if err != nil {
+		h.App.ErrorLog.Println("error getting user:", err)
+		h.App.Error500(w)
+		return
+	}
+
+	out := newUserJSON(created)
+	w.Header().Set("Location", "/api/v1/users/"+id.String())
+	_ = h.App.WriteJSON(w, http.StatusCreated, apiPayload{User: &out})
+}
+
+// APIUserPatch updates the fields given in a JSON body and responds with the updated user as stored. The result
+// is validated as a whole, with the same rules as the HTML form.
+func (h *Handlers) APIUserPatch(w http.ResponseWriter, r *http.Request) {
+	current, ok := h.apiUser(w, r)
+	if !ok {
+		return
+	}
+	var in userPatch
+	if err := decodeJSON(w, r, &in); err != nil {
+		h.apiError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	edited := *current
+	if in.FirstName != nil {
+		edited.FirstName = *in.FirstName
+	}
+	if in.LastName != nil {
+		edited.LastName = *in.LastName
+	}
+	if in.Email != nil {
+		edited.Email = *in.Email
+	}
+	if in.Active != nil {
+		edited.Active = *in.Active
+	}
+	if in.Version != nil {
+		edited.Version = *in.Version
+	}
+
+	validator := userInput{FirstName: edited.FirstName, LastName: edited.LastName, Email: edited.Email, Active: edited.Active}.validator(h)
+	edited.Validate(validator)
+	if !validator.Valid() {
+		_ = h.App.WriteJSON(w, http.StatusUnprocessableEntity, apiPayload{Error: true, Message: "validation failed", Errors: validator.Errors})
+		return
+	}
+	if edited.Email != current.Email {
+		if taken, ok := h.emailTaken(w, r, edited.Email, current.ID); !ok || taken {
+			return
+		}
+	}
+
+	err := h.models(r).Users.Update(edited)
+	switch {
+	case errors.Is(err, data.ErrStaleObject):
+		latest, err := h.models(r).Users.Get(current.ID)
+		if err != nil {
+			h.App.ErrorLog.Println("error reloading user:", err)
+			h.App.Error500(w)
+			return
+		}
+		out := newUserJSON(latest)
+		_ = h.App.WriteJSON(w, http.StatusConflict, apiPayload{Error: true, Message: data.ErrStaleObject.Error(), User: &out})
+		return
+	case errors.Is(err, data.ErrDuplicateEmail):
+		h.emailConflict(w)
+		return
+	case errors.Is(err, db.ErrNoMoreRows):
+		h.apiError(w, http.StatusNotFound, "user not found")
+		return
+	case err != nil:
+		h.App.ErrorLog.Println("error updating user:", err)
+		h.App.Error500(w)
+		return
+	}
+
+	updated, err := h.models(r).Users.Get(current.ID)
+	if err != nil {
+		h.App.ErrorLog.Println("error reloading user:", err)
+		h.App.Error500(w)
+		return
+	}
+	out := newUserJSON(updated)
+	_ = h.App.WriteJSON(w, http.StatusOK, apiPayload{User: &out})
+}
+
+// APIUserDelete soft-deletes a user and responds with 204 No Content.
+func (h *Handlers) APIUserDelete(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.apiUser(w, r)
+	if !ok {
+		return
+	}
+	if err := h.models(r).Users.Delete(user.ID); err != nil {
+		h.App.ErrorLog.Println("error deleting user:", err)
+		h.App.Error500(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiUser loads the user named by the id URL parameter.
+// It writes a JSON 404 or a 500 response and returns false if the user cannot be loaded.
+func (h *Handlers) apiUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := data.ParseID(chi.URLParam(r, "id"))
+	if err != nil {
+		h.apiError(w, http.StatusNotFound, "user not found")
+		return nil, false
+	}
+	user, err := h.models(r).Users.Get(id)
+	if err != nil {
+		if errors.Is(err, db.ErrNoMoreRows) || errors.Is(err, db.ErrNilRecord) {
+			h.apiError(w, http.StatusNotFound, "user not found")
+			return nil, false
+		}
+		h.App.ErrorLog.Println("error getting user:", err)
+		h.App.Error500(w)
+		return nil, false
+	}
+	return user, true
+}
+
+// emailTaken reports whether a user other than except has the given email address, writing a 409 response
+// if so. It writes a 500 response and returns false if the lookup fails.
+func (h *Handlers) emailTaken(w http.ResponseWriter, r *http.Request, email string, except data.ID) (taken, ok bool) {
+	inUse, err := h.emailInUse(r, email, except)
+	if err != nil {
+		h.App.ErrorLog.Println("error getting user by email:", err)
+		h.App.Error500(w)
+		return false, false
+	}
+	if inUse {
+		h.emailConflict(w)
+	}
+	return inUse, true
+}
+
+// emailConflict responds with 409 Conflict and an error for the email field, because the address is in use.
+func (h *Handlers) emailConflict(w http.ResponseWriter) {
+	_ = h.App.WriteJSON(w, http.StatusConflict, apiPayload{Error: true, Message: "validation failed", Errors: map[string]string{"email": emailInUseMessage}})
+}
+
+// apiError responds with a JSON error payload carrying message.
+func (h *Handlers) apiError(w http.ResponseWriter, status int, message string) {
+	_ = h.App.WriteJSON(w, status, apiPayload{Error: true, Message: message})
+}
+
+// decodeJSON decodes a JSON request body of at most maxJSONBody bytes into v, rejecting unknown fields so
+// that misspelt ones are not silently ignored.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBody))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jorgeSader/devify-test-app/data"
+	"github.com/upper/db/v4"
+)
+
+// serveAPI calls handler with a JSON body and the given id URL parameter, and decodes the response payload.
+func serveAPI(t *testing.T, handler http.HandlerFunc, method, id, body string) (*httptest.ResponseRecorder, apiPayload) {
+	t.Helper()
+	r := httptest.NewRequest(method, "/api/v1/users/"+id, strings.NewReader(body))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	var payload apiPayload
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &payload); err != nil {
+			t.Fatalf("invalid JSON response %q: %v", w.Body.String(), err)
+		}
+	}
+	return w, payload
+}
+
+// TestAPIUserCreate tests that a valid user is created, and that invalid input and email addresses in use are
+// rejected.
+func TestAPIUserCreate(t *testing.T) {
+	h, _ := newTestHandlers(t)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{"Created", `{"first_name":"Ann","last_name":"Other","email":"ann@example.com","password":"Test@123"}`, http.StatusCreated, ""},
+		{"InvalidJSON", `{"first_name":`, http.StatusBadRequest, ""},
+		{"UnknownField", `{"nickname":"ann"}`, http.StatusBadRequest, ""},
+		{"NoPassword", `{"first_name":"Ann","last_name":"Other","email":"ann2@example.com"}`, http.StatusUnprocessableEntity, "password"},
+		{"EmailInUse", `{"first_name":"Jane","last_name":"Again","email":"jane@fixtures.test","password":"Test@123"}`, http.StatusConflict, "email"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, payload := serveAPI(t, h.APIUserCreate, http.MethodPost, "", tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantError != "" && payload.Errors[tt.wantError] == "" {
+				t.Errorf("expected an error for %s, got %v", tt.wantError, payload.Errors)
+			}
+			if tt.wantStatus != http.StatusCreated {
+				return
+			}
+
+			if payload.User == nil || payload.User.Email != "ann@example.com" || payload.User.Active != 1 {
+				t.Fatalf("got user %+v, want the created user", payload.User)
+			}
+			if got, want := w.Header().Get("Location"), "/api/v1/users/"+payload.User.ID.String(); got != want {
+				t.Errorf("got Location %q, want %q", got, want)
+			}
+			if _, err := h.Models.Users.Get(payload.User.ID); err != nil {
+				t.Errorf("expected the user to be stored: %v", err)
+			}
+		})
+	}
+}
+
+// TestAPIUser_NotFound tests that unknown, malformed and deleted ids are 404 Not Found for every method.
+func TestAPIUser_NotFound(t *testing.T) {
+	h, f := newTestHandlers(t)
+
+	handlers := []struct {
+		method  string
+		handler http.HandlerFunc
+	}{
+		{http.MethodGet, h.APIUser},
+		{http.MethodPatch, h.APIUserPatch},
+		{http.MethodDelete, h.APIUserDelete},
+	}
+	for _, id := range []string{"999999", "not-an-id", f.UserID("gone").String()} {
+		for _, hh := range handlers {
+			w, payload := serveAPI(t, hh.handler, hh.method, id, `{}`)
+			if w.Code != http.StatusNotFound || !payload.Error {
+				t.Errorf("%s %s: got status %d, want 404: %s", hh.method, id, w.Code, w.Body.String())
+			}
+		}
+	}
+}
+
+// TestAPIUserPatch tests that a patch responds with the user as stored, and that invalid input, email addresses
+// in use and out-of-date versions are rejected.
+func TestAPIUserPatch(t *testing.T) {
+	h, f := newTestHandlers(t)
+	id := f.UserID("jane").String()
+	jane, err := h.Models.Users.Get(f.UserID("jane"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, payload := serveAPI(t, h.APIUserPatch, http.MethodPatch, id, `{"email":"bob@fixtures.test"}`)
+	if w.Code != http.StatusConflict || payload.Errors["email"] == "" {
+		t.Errorf("email in use: got status %d, want 409 with an email error: %s", w.Code, w.Body.String())
+	}
+
+	w, payload = serveAPI(t, h.APIUserPatch, http.MethodPatch, id, `{"email":"not an email"}`)
+	if w.Code != http.StatusUnprocessableEntity || payload.Errors["email"] == "" {
+		t.Errorf("invalid email: got status %d, want 422 with an email error: %s", w.Code, w.Body.String())
+	}
+
+	w, payload = serveAPI(t, h.APIUserPatch, http.MethodPatch, id, `{"first_name":"Janet"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", w.Code, w.Body.String())
+	}
+	stored, err := h.Models.Users.Get(jane.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.User == nil || payload.User.FirstName != "Janet" || payload.User.Version != stored.Version ||
+		!payload.User.UpdatedAt.Equal(stored.UpdatedAt) {
+		t.Errorf("got user %+v, want the user as stored (version %d)", payload.User, stored.Version)
+	}
+
+	stale := `{"last_name":"Stale","version":` + strconv.Itoa(jane.Version) + `}`
+	w, payload = serveAPI(t, h.APIUserPatch, http.MethodPatch, id, stale)
+	if w.Code != http.StatusConflict || payload.User == nil || payload.User.Version != stored.Version {
+		t.Errorf("stale version: got status %d, want 409 with the current user: %s", w.Code, w.Body.String())
+	}
+}
+
+// racedUsers finds no user by email, as if the address were taken by another request right after the check.
+type racedUsers struct {
+	data.UserStore
+}
+
+func (racedUsers) GetByEmail(string) (*data.User, error) { return nil, db.ErrNoMoreRows }
+
+// TestAPIUser_EmailRace tests that an email address taken between the check and the write is a 409 Conflict,
+// the same as one the check catches.
+func TestAPIUser_EmailRace(t *testing.T) {
+	h, f := newTestHandlers(t)
+	h.Models.Users = racedUsers{h.Models.Users}
+
+	w, payload := serveAPI(t, h.APIUserCreate, http.MethodPost, "", `{"first_name":"Jane","last_name":"Again","email":"jane@fixtures.test","password":"Test@123"}`)
+	if w.Code != http.StatusConflict || payload.Errors["email"] == "" {
+		t.Errorf("create: got status %d, want 409 with an email error: %s", w.Code, w.Body.String())
+	}
+	w, payload = serveAPI(t, h.APIUserPatch, http.MethodPatch, f.UserID("jane").String(), `{"email":"bob@fixtures.test"}`)
+	if w.Code != http.StatusConflict || payload.Errors["email"] == "" {
+		t.Errorf("patch: got status %d, want 409 with an email error: %s", w.Code, w.Body.String())
+	}
+}
@@ -39,7 +39,7 @@ func (h *Handlers) PostUserLogin(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Invalid password!"))
 	}
 
-	h.App.Session.Put(r.Context(), "userID", user.ID)
+	h.App.Session.Put(r.Context(), "userID", user.ID.String())
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
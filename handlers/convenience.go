@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/jorgeSader/devify"
+	"github.com/jorgeSader/devify-test-app/data"
 )
 
 // renderGo renders a Go template page.
@@ -132,3 +135,57 @@ func (h *Handlers) decrypt(crypto string) (string, error) {
 	}
 	return decrypted, nil
 }
+
+// userListOptions builds user listing options from the request's query string.
+// It understands page, per_page, cursor, sort, active, email, name, created_after and created_before;
+// dates may be given as RFC 3339 timestamps or as YYYY-MM-DD.
+// It returns an error if a parameter is malformed.
+func (h *Handlers) userListOptions(r *http.Request) (data.ListOptions, error) {
+	q := r.URL.Query()
+	opts := data.ListOptions{
+		Sort:   q.Get("sort"),
+		Cursor: q.Get("cursor"),
+		Filter: data.UserFilter{
+			Email:      q.Get("email"),
+			NamePrefix: q.Get("name"),
+		},
+	}
+
+	var err error
+	if v := q.Get("page"); v != "" {
+		if opts.Page, err = strconv.Atoi(v); err != nil {
+			return opts, fmt.Errorf("invalid page: %q", v)
+		}
+	}
+	if v := q.Get("per_page"); v != "" {
+		if opts.PerPage, err = strconv.Atoi(v); err != nil {
+			return opts, fmt.Errorf("invalid per_page: %q", v)
+		}
+	}
+	if v := q.Get("active"); v != "" {
+		active, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid active: %q", v)
+		}
+		opts.Filter.Active = &active
+	}
+	if v := q.Get("created_after"); v != "" {
+		if opts.Filter.CreatedAfter, err = parseQueryTime(v); err != nil {
+			return opts, fmt.Errorf("invalid created_after: %q", v)
+		}
+	}
+	if v := q.Get("created_before"); v != "" {
+		if opts.Filter.CreatedBefore, err = parseQueryTime(v); err != nil {
+			return opts, fmt.Errorf("invalid created_before: %q", v)
+		}
+	}
+	return opts, nil
+}
+
+// parseQueryTime parses a query string timestamp given either as RFC 3339 or as a YYYY-MM-DD date.
+func parseQueryTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
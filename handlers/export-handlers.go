@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CloudyKit/jet/v6"
+	"github.com/go-chi/chi/v5"
+	"github.com/jorgeSader/devify-test-app/data"
+)
+
+// maxStreamedExport is the largest export streamed straight to the client; larger exports are written
+// to a file in the background and downloaded once ready, so no request is held open for long.
+const maxStreamedExport = 10000
+
+// exportJobTTL is how long a finished export file is kept for download.
+const exportJobTTL = time.Hour
+
+// exportPruneInterval is how often expired export files are removed once StartPruning is called.
+const exportPruneInterval = 10 * time.Minute
+
+// Export job states.
+const (
+	exportRunning = "running"
+	exportDone    = "done"
+	exportFailed  = "failed"
+)
+
+// ExportJob is a user export written to a file in the background.
+type ExportJob struct {
+	ID       string            `json:"id"`
+	Format   data.ExportFormat `json:"format"`
+	Status   string            `json:"status"`
+	Rows     int               `json:"rows"`
+	Error    string            `json:"error,omitempty"`
+	Created  time.Time         `json:"created_at"`
+	Finished *time.Time        `json:"finished_at,omitempty"` // nil while running
+	fileName string
+}
+
+// ExportJobs runs background exports and keeps their files in a directory until they expire.
+// It is safe for concurrent use.
+type ExportJobs struct {
+	mu   sync.Mutex
+	dir  string
+	jobs map[string]*ExportJob
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewExportJobs returns an ExportJobs keeping export files in dir, which is created if needed.
+func NewExportJobs(dir string) *ExportJobs {
+	return &ExportJobs{dir: dir, jobs: make(map[string]*ExportJob), stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// StartPruning removes expired export files every exportPruneInterval until Stop is called, so they do not
+// pile up while no new exports start.
+func (j *ExportJobs) StartPruning() {
+	go func() {
+		defer close(j.done)
+		ticker := time.NewTicker(exportPruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.Prune()
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops pruning and waits for a prune in progress to finish. It must only be called after StartPruning.
+func (j *ExportJobs) Stop() {
+	close(j.stop)
+	<-j.done
+}
+
+// Start begins exporting users to a file in the background and returns the job, which can be polled with Get.
+func (j *ExportJobs) Start(users data.UserStore, opts data.ExportOptions) (*ExportJob, error) {
+	if err := os.MkdirAll(j.dir, 0o700); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	job := &ExportJob{ID: hex.EncodeToString(b), Format: opts.Format, Status: exportRunning, Created: time.Now()}
+	job.fileName = "users-" + job.ID + "." + string(opts.Format)
+
+	f, err := os.Create(filepath.Join(j.dir, job.fileName))
+	if err != nil {
+		return nil, err
+	}
+
+	j.mu.Lock()
+	j.jobs[job.ID] = job
+	j.mu.Unlock()
+
+	go func() {
+		n, err := users.Export(context.Background(), f, opts)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		job.Rows = n
+		finished := time.Now()
+		job.Finished = &finished
+		job.Status = exportDone
+		if err != nil {
+			job.Status = exportFailed
+			job.Error = err.Error()
+			_ = os.Remove(filepath.Join(j.dir, job.fileName))
+		}
+	}()
+
+	return job.snapshot(), nil
+}
+
+// Get returns a copy of the job with the given ID.
+func (j *ExportJobs) Get(id string) (*ExportJob, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	return job.snapshot(), true
+}
+
+// Prune forgets jobs that finished more than exportJobTTL ago and removes their files, along with any export
+// file older than that which no job knows of, such as one left behind by an earlier run of the application.
+func (j *ExportJobs) Prune() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	known := make(map[string]bool, len(j.jobs))
+	for id, job := range j.jobs {
+		if job.Finished != nil && time.Since(*job.Finished) > exportJobTTL {
+			_ = os.Remove(filepath.Join(j.dir, job.fileName))
+			delete(j.jobs, id)
+			continue
+		}
+		known[job.fileName] = true
+	}
+
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if known[e.Name()] || !strings.HasPrefix(e.Name(), "users-") {
+			continue
+		}
+		if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > exportJobTTL {
+			_ = os.Remove(filepath.Join(j.dir, e.Name()))
+		}
+	}
+}
+
+// snapshot returns a copy of the job that is safe to read without holding the lock.
+func (job *ExportJob) snapshot() *ExportJob {
+	c := *job
+	return &c
+}
+
+// exportOptions reads the export format, columns and filter from the query string.
+// The format defaults to CSV and the columns, a comma-separated list, to every exportable column.
+func (h *Handlers) exportOptions(r *http.Request) (data.ExportOptions, error) {
+	list, err := h.userListOptions(r)
+	if err != nil {
+		return data.ExportOptions{}, err
+	}
+	opts := data.ExportOptions{Format: data.ExportCSV, Filter: list.Filter}
+	if v := r.URL.Query().Get("format"); v != "" {
+		if opts.Format, err = data.ParseExportFormat(v); err != nil {
+			return opts, err
+		}
+	}
+	if v := r.URL.Query().Get("columns"); v != "" {
+		for _, col := range strings.Split(v, ",") {
+			opts.Columns = append(opts.Columns, strings.TrimSpace(col))
+		}
+	}
+	return opts, opts.Validate()
+}
+
+// AdminUsersExport exports the users matching the list filters, in the format and columns given by the
+// format and columns query parameters. Small exports are streamed as a download; exports of more than
+// maxStreamedExport users, or any export when async=true, run in the background and the client is sent to
+// the job's status page, from which the file can be downloaded once ready.
+func (h *Handlers) AdminUsersExport(w http.ResponseWriter, r *http.Request) {
+	opts, err := h.exportOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	async := r.URL.Query().Get("async") == "true"
+	if !async {
+		page, err := h.models(r).Users.List(data.ListOptions{Filter: opts.Filter, PerPage: 1})
+		if err != nil {
+			h.App.ErrorLog.Println("error counting users:", err)
+			h.App.Error500(w)
+			return
+		}
+		async = page.Total > maxStreamedExport
+	}
+
+	if async {
+		job, err := h.Exports.Start(h.Models.Users, opts)
+		if err != nil {
+			h.App.ErrorLog.Println("error starting export:", err)
+			h.App.Error500(w)
+			return
+		}
+		location := "/admin/users/exports/" + job.ID
+		if wantsJSON(r) {
+			w.Header().Set("Location", location)
+			_ = h.App.WriteJSON(w, http.StatusAccepted, job)
+			return
+		}
+		http.Redirect(w, r, location, http.StatusSeeOther)
+		return
+	}
+
+	w.Header().Set("Content-Type", opts.Format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="users.%s"`, opts.Format))
+	_, err = h.models(r).Users.Export(r.Context(), w, opts)
+	if err != nil {
+		// The response has likely started, so the client sees a truncated file; all we can do is log it.
+		h.App.ErrorLog.Println("error exporting users:", err)
+	}
+}
+
+// AdminUsersExportJob shows the status of a background export, as JSON if the client accepts it.
+func (h *Handlers) AdminUsersExportJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.Exports.Get(chi.URLParam(r, "id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if wantsJSON(r) {
+		_ = h.App.WriteJSON(w, http.StatusOK, job)
+		return
+	}
+
+	vars := make(jet.VarMap)
+	vars.Set("job", job)
+	err := h.App.Render.Page(w, r, "admin-users-export", nil, vars)
+	if err != nil {
+		h.App.ErrorLog.Println("error rendering:", err)
+	}
+}
+
+// AdminUsersExportDownload serves the file of a finished background export.
+func (h *Handlers) AdminUsersExportDownload(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.Exports.Get(chi.URLParam(r, "id"))
+	if !ok || job.Status != exportDone {
+		http.NotFound(w, r)
+		return
+	}
+	err := h.App.DownloadFile(w, r, h.Exports.dir, job.fileName)
+	if err != nil {
+		h.App.ErrorLog.Println("error downloading export:", err)
+	}
+}
+
+// wantsJSON reports whether the client asked for a JSON response.
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExportJobs_Prune tests that expired jobs and orphaned export files are removed, while running jobs,
+// recent files and other files are kept.
+func TestExportJobs_Prune(t *testing.T) {
+	dir := t.TempDir()
+	j := NewExportJobs(dir)
+	old := time.Now().Add(-2 * exportJobTTL)
+	recent := time.Now()
+
+	files := map[string]time.Time{
+		"users-expired.csv": old,
+		"users-running.csv": old,
+		"users-recent.csv":  recent,
+		"users-orphan.csv":  old,
+		"notes.txt":         old,
+	}
+	for name, mtime := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.jobs["expired"] = &ExportJob{ID: "expired", Status: exportDone, Finished: &old, fileName: "users-expired.csv"}
+	j.jobs["running"] = &ExportJob{ID: "running", Status: exportRunning, fileName: "users-running.csv"}
+	j.jobs["recent"] = &ExportJob{ID: "recent", Status: exportDone, Finished: &recent, fileName: "users-recent.csv"}
+
+	j.Prune()
+
+	if _, ok := j.Get("expired"); ok {
+		t.Error("expected the expired job to be forgotten")
+	}
+	for _, id := range []string{"running", "recent"} {
+		if _, ok := j.Get(id); !ok {
+			t.Errorf("expected job %s to be kept", id)
+		}
+	}
+	var left []string
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		left = append(left, e.Name())
+	}
+	if got := strings.Join(left, ","); got != "notes.txt,users-recent.csv,users-running.csv" {
+		t.Errorf("got files %s after pruning", got)
+	}
+}
+
+// TestExportJob_FinishedJSON tests that a running job has no finished_at.
+func TestExportJob_FinishedJSON(t *testing.T) {
+	b, err := json.Marshal(ExportJob{ID: "a", Status: exportRunning})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "finished_at") {
+		t.Errorf("expected no finished_at for a running job, got %s", b)
+	}
+}
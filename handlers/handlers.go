@@ -11,8 +11,15 @@ import (
 )
 
 type Handlers struct {
-	App    *devify.Devify
-	Models data.Models
+	App                  *devify.Devify
+	Models               data.Models
+	Exports              *ExportJobs
+	IntrospectionClients IntrospectionClients
+}
+
+// models returns the models bound to the request's read scope, so the request reads its client's own writes.
+func (h *Handlers) models(r *http.Request) data.Models {
+	return h.Models.WithReadScope(data.ReadScopeFrom(r.Context()))
 }
 
 func (h *Handlers) Home(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/jorgeSader/devify"
+	"github.com/jorgeSader/devify-test-app/data"
+	"github.com/jorgeSader/devify-test-app/data/datatest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestHandlers returns handlers over in-memory models loaded with the data package's fixtures.
+// It skips the test if the devify build in use does not write responses, as a type-checking stub does not.
+func newTestHandlers(t *testing.T) (*Handlers, *data.Fixtures) {
+	t.Helper()
+	app := &devify.Devify{ErrorLog: log.New(io.Discard, "", 0), InfoLog: log.New(io.Discard, "", 0)}
+	probe := httptest.NewRecorder()
+	_ = app.WriteJSON(probe, http.StatusTeapot, struct{}{})
+	if probe.Code != http.StatusTeapot {
+		t.Skip("devify.WriteJSON does not write responses in this build")
+	}
+
+	t.Setenv("BCRYPT_COST", strconv.Itoa(bcrypt.MinCost))
+	m := data.NewMemory()
+	f := datatest.LoadFixtures(t, m, "../data/testdata/fixtures.yml")
+	return &Handlers{App: app, Models: m}, f
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jorgeSader/devify-test-app/data"
+	"github.com/upper/db/v4"
+)
+
+// introspectionMaxAge is how long a client may cache an introspection response. A revoked token can keep
+// introspecting as active for this long, so it is kept short.
+const introspectionMaxAge = 30 * time.Second
+
+// maxIntrospectionBody bounds the size of an introspection request.
+const maxIntrospectionBody = 16 << 10
+
+// IntrospectionClients holds the client credentials allowed to call the token introspection endpoint:
+// the SHA-256 hash of each client's secret, keyed by client ID.
+type IntrospectionClients map[string][sha256.Size]byte
+
+// ParseIntrospectionClients parses a comma-separated list of client_id:secret pairs, as found in the
+// INTROSPECTION_CLIENTS environment variable. An empty list allows no clients.
+func ParseIntrospectionClients(s string) (IntrospectionClients, error) {
+	clients := make(IntrospectionClients)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("invalid INTROSPECTION_CLIENTS entry %q: want client_id:secret", pair)
+		}
+		clients[id] = sha256.Sum256([]byte(secret))
+	}
+	return clients, nil
+}
+
+// authenticate reports whether the request carries the HTTP Basic credentials of a known client.
+func (c IntrospectionClients) authenticate(r *http.Request) bool {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	want, known := c[id]
+	got := sha256.Sum256([]byte(secret))
+	// Compare even for unknown clients, so the response time does not reveal which client IDs exist.
+	return subtle.ConstantTimeCompare(got[:], want[:]) == 1 && known
+}
+
+// introspection is an RFC 7662 introspection response. Only Active is set for inactive tokens.
+type introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Email     string `json:"email,omitempty"`
+	Expires   int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// TokenIntrospect implements RFC 7662 token introspection for other services. Clients authenticate with HTTP
+// Basic credentials listed in INTROSPECTION_CLIENTS and post the token as the token form field. The response
+// says whether the token is active and, if so, whose it is, its scopes and when it expires. Clients may cache
+// it for up to introspectionMaxAge, and never past the token's expiry.
+func (h *Handlers) TokenIntrospect(w http.ResponseWriter, r *http.Request) {
+	if !h.IntrospectionClients.authenticate(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="introspection"`)
+		_ = h.App.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxIntrospectionBody)
+	plainText := ""
+	if err := r.ParseForm(); err == nil {
+		plainText = r.PostForm.Get("token")
+	}
+	if plainText == "" {
+		_ = h.App.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	result, err := h.introspect(plainText)
+	if err != nil {
+		h.App.ErrorLog.Println("error introspecting token:", err)
+		h.App.Error500(w)
+		return
+	}
+
+	maxAge := introspectionMaxAge
+	if result.Active {
+		if untilExpiry := time.Until(time.Unix(result.Expires, 0)); untilExpiry < maxAge {
+			maxAge = untilExpiry
+		}
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(maxAge.Seconds())))
+	w.Header().Set("Vary", "Authorization")
+	_ = h.App.WriteJSON(w, http.StatusOK, result)
+}
+
+// introspect looks up a token. Unknown and expired tokens, and tokens of deleted users, are inactive.
+// Signed tokens are checked against the signing keys and deny list only.
+func (h *Handlers) introspect(plainText string) (*introspection, error) {
+	if data.DetectTokenFormat(plainText) != data.TokenOpaque {
+		claims, err := data.VerifySignedToken(plainText)
+		switch {
+		case errors.Is(err, data.ErrInvalidToken):
+			return &introspection{}, nil
+		case err != nil:
+			return nil, err
+		}
+		return &introspection{
+			Active:    true,
+			Scope:     claims.Scopes,
+			TokenType: "Bearer",
+			Subject:   claims.UserID.String(),
+			Username:  claims.Email,
+			Email:     claims.Email,
+			Expires:   claims.Expires.Unix(),
+			IssuedAt:  claims.IssuedAt.Unix(),
+		}, nil
+	}
+
+	token, err := h.Models.Tokens.GetByToken(plainText)
+	switch {
+	case errors.Is(err, sql.ErrNoRows) || errors.Is(err, db.ErrNoMoreRows):
+		return &introspection{}, nil
+	case err != nil:
+		return nil, err
+	case !token.ExpiresAt().After(time.Now()):
+		return &introspection{}, nil
+	}
+
+	user, err := h.Models.Users.Get(token.UserID)
+	switch {
+	case errors.Is(err, db.ErrNoMoreRows) || errors.Is(err, db.ErrNilRecord):
+		return &introspection{}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	return &introspection{
+		Active:    true,
+		Scope:     token.Scopes,
+		TokenType: "Bearer",
+		Subject:   user.ID.String(),
+		Username:  user.Email,
+		Email:     user.Email,
+		Expires:   token.ExpiresAt().Unix(),
+		IssuedAt:  token.CreatedAt.Unix(),
+	}, nil
+}
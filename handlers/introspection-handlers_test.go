@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jorgeSader/devify-test-app/data"
+)
+
+// TestTokenIntrospect tests client authentication, the request checks, active and inactive responses for
+// opaque and signed tokens, and how long clients may cache them.
+func TestTokenIntrospect(t *testing.T) {
+	h, f := newTestHandlers(t)
+	clients, err := ParseIntrospectionClients("svc:s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.IntrospectionClients = clients
+
+	jane, err := h.Models.Users.Get(f.UserID("jane"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiring, err := h.Models.Tokens.GenerateToken(jane.ID, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Models.Tokens.Add(*expiring, *jane); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := data.NewSigningKey("k1", data.AlgHS256, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := data.NewSigningKeys("", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := data.NewSignedIssuer(data.TokenJWT, keys, data.NewMemoryDenyList())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.SetSignedIssuer(signer)
+	t.Cleanup(func() { data.SetSignedIssuer(nil) })
+	signed, err := signer.Issue(*jane, time.Hour, "users:read users:write")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		user, pass string
+		token      string
+		wantStatus int
+		wantActive bool
+		wantScope  string
+		maxAge     func(int) bool // nil if no Cache-Control is expected
+	}{
+		{"NoCredentials", "", "", f.PlainText("jane-api"), http.StatusUnauthorized, false, "", nil},
+		{"WrongSecret", "svc", "nope", f.PlainText("jane-api"), http.StatusUnauthorized, false, "", nil},
+		{"NoToken", "svc", "s3cret", "", http.StatusBadRequest, false, "", nil},
+		{"Active", "svc", "s3cret", f.PlainText("jane-api"), http.StatusOK, true, "users:read", func(s int) bool { return s == 30 }},
+		{"ActiveExpiringSoon", "svc", "s3cret", expiring.PlainText(), http.StatusOK, true, "", func(s int) bool { return s >= 0 && s <= 10 }},
+		{"Expired", "svc", "s3cret", f.PlainText("jane-expired"), http.StatusOK, false, "", func(s int) bool { return s == 30 }},
+		{"Unknown", "svc", "s3cret", "dvf_" + strings.Repeat("A", 32) + "_000000", http.StatusOK, false, "", func(s int) bool { return s == 30 }},
+		{"Signed", "svc", "s3cret", signed, http.StatusOK, true, "users:read users:write", func(s int) bool { return s == 30 }},
+		{"SignedTampered", "svc", "s3cret", signed[:len(signed)-2] + "xx", http.StatusOK, false, "", func(s int) bool { return s == 30 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.token != "" {
+				form.Set("token", tt.token)
+			}
+			r := httptest.NewRequest("POST", "/api/v1/introspect", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.pass)
+			}
+			w := httptest.NewRecorder()
+			h.TokenIntrospect(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a WWW-Authenticate challenge")
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got introspection
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("invalid response %s: %v", w.Body, err)
+			}
+			if got.Active != tt.wantActive || got.Scope != tt.wantScope {
+				t.Errorf("got active %v, scope %q; want %v, %q", got.Active, got.Scope, tt.wantActive, tt.wantScope)
+			}
+			if got.Active && (got.Subject != jane.ID.String() || got.Email != jane.Email || got.Expires == 0) {
+				t.Errorf("unexpected active response %+v", got)
+			}
+			if !got.Active && (got.Subject != "" || got.Expires != 0) {
+				t.Errorf("expected an inactive response to carry no claims, got %+v", got)
+			}
+
+			var maxAge int
+			cc := w.Header().Get("Cache-Control")
+			if _, err := fmt.Sscanf(cc, "private, max-age=%d", &maxAge); err != nil || !tt.maxAge(maxAge) {
+				t.Errorf("unexpected Cache-Control %q", cc)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CloudyKit/jet/v6"
+	"github.com/go-chi/chi/v5"
+	"github.com/jorgeSader/devify"
+	"github.com/jorgeSader/devify-test-app/data"
+	"github.com/upper/db/v4"
+)
+
+// newTokenKey is the session key holding the plain text of a token just created, until the tokens page shows it.
+const newTokenKey = "newToken"
+
+// personalTokenScopes are the scopes users may grant their personal access tokens.
+var personalTokenScopes = []string{data.ScopeUsersRead, data.ScopeUsersWrite}
+
+// personalTokenTTLs are the lifetimes, in days, offered for new personal access tokens.
+var personalTokenTTLs = []int{7, 30, 90, 365}
+
+// defaultPersonalTokenTTL is the lifetime, in days, selected on the create form.
+const defaultPersonalTokenTTL = 30
+
+// maxTokenNameLength is the length of the tokens.name column.
+const maxTokenNameLength = 255
+
+// tokenForm holds the values of the create token form, so it can be shown again when invalid.
+type tokenForm struct {
+	Name   string
+	TTL    int
+	Scopes []string
+}
+
+// HasScope reports whether scope is selected on the form.
+func (f tokenForm) HasScope(scope string) bool {
+	return slices.Contains(f.Scopes, scope)
+}
+
+// UserTokens lists the personal access tokens of the logged in user, with a form to create another.
+// The plain text of a token just created is shown once, then dropped from the session.
+func (h *Handlers) UserTokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	plainText := h.App.Session.PopString(r.Context(), newTokenKey)
+	form := tokenForm{TTL: defaultPersonalTokenTTL}
+	h.renderUserTokens(w, r, http.StatusOK, user, form, h.App.Validator(r), plainText)
+}
+
+// UserTokenCreate creates a personal access token for the logged in user from the create form, then
+// redirects back to the tokens page, which shows its plain text.
+func (h *Handlers) UserTokenCreate(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	form := tokenForm{
+		Name:   strings.TrimSpace(r.Form.Get("name")),
+		Scopes: r.Form["scopes"],
+	}
+	validator := h.App.Validator(r)
+	validator.Required("name")
+	if len(form.Name) > maxTokenNameLength {
+		validator.AddError("name", "Name must be at most 255 characters")
+	}
+	form.TTL, err = strconv.Atoi(r.Form.Get("ttl"))
+	if err != nil || !slices.Contains(personalTokenTTLs, form.TTL) {
+		validator.AddError("ttl", "Choose one of the listed expirations")
+	}
+	for _, scope := range form.Scopes {
+		if !slices.Contains(personalTokenScopes, scope) {
+			validator.AddError("scopes", "Unknown scope: "+scope)
+		}
+	}
+	if !validator.Valid() {
+		h.renderUserTokens(w, r, http.StatusUnprocessableEntity, user, form, validator, "")
+		return
+	}
+
+	token, err := h.models(r).Tokens.GenerateToken(user.ID, time.Duration(form.TTL)*24*time.Hour)
+	if err != nil {
+		h.App.ErrorLog.Println("error generating token:", err)
+		h.App.Error500(w)
+		return
+	}
+	token.Name = form.Name
+	token.Scopes = strings.Join(form.Scopes, " ")
+	token.Type = data.TokenTypePersonal
+	if err := h.models(r).Tokens.Add(*token, *user); err != nil {
+		h.App.ErrorLog.Println("error saving token:", err)
+		h.App.Error500(w)
+		return
+	}
+
+	h.sessionPut(r.Context(), newTokenKey, token.PlainText())
+	http.Redirect(w, r, "/users/tokens", http.StatusSeeOther)
+}
+
+// UserTokenRevoke deletes one of the logged in user's personal access tokens. Tokens that are not theirs are
+// reported as not found.
+func (h *Handlers) UserTokenRevoke(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := data.ParseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	token, err := h.models(r).Tokens.Get(id)
+	switch {
+	case errors.Is(err, db.ErrNoMoreRows) || errors.Is(err, db.ErrNilRecord):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		h.App.ErrorLog.Println("error getting token:", err)
+		h.App.Error500(w)
+		return
+	case token.UserID != user.ID || token.Type != data.TokenTypePersonal:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := h.models(r).Tokens.Delete(id); err != nil {
+		h.App.ErrorLog.Println("error revoking token:", err)
+		h.App.Error500(w)
+		return
+	}
+	http.Redirect(w, r, "/users/tokens", http.StatusSeeOther)
+}
+
+// sessionUser loads the logged in user.
+// It writes a 401 or 500 response and returns false if there is none.
+func (h *Handlers) sessionUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := data.ParseID(h.App.Session.GetString(r.Context(), "userID"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return nil, false
+	}
+	user, err := h.models(r).Users.Get(id)
+	if err != nil {
+		if errors.Is(err, db.ErrNoMoreRows) || errors.Is(err, db.ErrNilRecord) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return nil, false
+		}
+		h.App.ErrorLog.Println("error getting user:", err)
+		h.App.Error500(w)
+		return nil, false
+	}
+	return user, true
+}
+
+// renderUserTokens renders the tokens page with the given status code. plainText is the token just created,
+// if any.
+func (h *Handlers) renderUserTokens(w http.ResponseWriter, r *http.Request, status int, user *data.User, form tokenForm, validator *devify.Validation, plainText string) {
+	all, err := h.models(r).Tokens.GetTokensForUser(user.ID)
+	if err != nil {
+		h.App.ErrorLog.Println("error listing tokens:", err)
+		h.App.Error500(w)
+		return
+	}
+	var tokens []*data.Token
+	for _, token := range all {
+		if token.Type == data.TokenTypePersonal {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+
+	vars := make(jet.VarMap)
+	vars.Set("tokens", tokens)
+	vars.Set("form", form)
+	vars.Set("validator", validator)
+	vars.Set("newToken", plainText)
+	vars.Set("scopes", personalTokenScopes)
+	vars.Set("ttls", personalTokenTTLs)
+
+	// The page may show a token's plain text, which must not outlive this response.
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	err = h.App.Render.Page(w, r, "user-tokens", nil, vars)
+	if err != nil {
+		h.App.ErrorLog.Println("error rendering:", err)
+	}
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"github.com/jorgeSader/devify-test-app/middleware"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/jorgeSader/devify-test-app/data"
 	"github.com/jorgeSader/devify-test-app/handlers"
+	"github.com/jorgeSader/devify-test-app/migrations"
 
 	"github.com/jorgeSader/devify"
 )
@@ -30,8 +36,15 @@ func InitApplication() *application {
 		App: cel,
 	}
 
+	introspectionClients, err := handlers.ParseIntrospectionClients(os.Getenv("INTROSPECTION_CLIENTS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	myHandlers := &handlers.Handlers{
-		App: cel,
+		App:                  cel,
+		Exports:              handlers.NewExportJobs(filepath.Join(os.TempDir(), cel.AppName+"-exports")),
+		IntrospectionClients: introspectionClients,
 	}
 
 	app := &application{
@@ -42,11 +55,126 @@ func InitApplication() *application {
 
 	app.App.Routes = app.routes()
 
-	app.Models = data.New(app.App.DB.Pool)
+	replicaPools, err := openReplicas()
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Models = data.New(app.App.DB.Pool, replicaPools...)
 
 	myHandlers.Models = app.Models
 
 	app.Middleware.Models = app.Models
 
+	reaperConfig, err := data.ReaperConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	reaperConfig.ErrorLog = cel.ErrorLog
+	app.Reaper = data.NewReaper(reaperConfig)
+
+	usageInterval, err := data.TokenUsageIntervalFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.TokenUsage = data.NewTokenUsage(usageInterval, cel.ErrorLog)
+
 	return app
 }
+
+// openReplicas opens a pool for each read replica listed in DATABASE_REPLICAS, a comma-separated list of DSNs
+// for the same database type as the primary.
+func openReplicas() ([]*sql.DB, error) {
+	dsns := os.Getenv("DATABASE_REPLICAS")
+	if dsns == "" {
+		return nil, nil
+	}
+
+	var driver string
+	switch strings.ToLower(os.Getenv("DATABASE_TYPE")) {
+	case "postgres", "postgresql":
+		driver = "pgx"
+	case "mysql", "mariadb":
+		driver = "mysql"
+	case "sqlite", "turso", "libsql":
+		driver = "sqlite3"
+	default:
+		return nil, fmt.Errorf("read replicas are not supported for DATABASE_TYPE %q", os.Getenv("DATABASE_TYPE"))
+	}
+
+	var pools []*sql.DB
+	for _, dsn := range strings.Split(dsns, ",") {
+		pool, err := sql.Open(driver, strings.TrimSpace(dsn))
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+// checkSchema compares the database schema with the embedded migrations before serving, as configured by
+// DATABASE_MIGRATE: "check" refuses to start while migrations are pending, "auto" applies them, and "off"
+// or unset skips the check. A dirty schema, left by a failed migration, is always refused.
+func (a *application) checkSchema() error {
+	mode := strings.ToLower(os.Getenv("DATABASE_MIGRATE"))
+	switch mode {
+	case "", "off":
+		return nil
+	case "check", "auto":
+	default:
+		return fmt.Errorf("unknown DATABASE_MIGRATE: %s", mode)
+	}
+
+	m, err := migrations.New(a.App.DB.Pool, os.Getenv("DATABASE_TYPE"), os.Getenv("DATABASE_KEY_TYPE"))
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+	switch {
+	case status.Dirty:
+		return fmt.Errorf("%w (version %d)", migrations.ErrDirty, status.Current)
+	case !status.Behind():
+		return nil
+	case mode == "check":
+		return fmt.Errorf("database schema is at version %d but the latest migration is %d; run migrate up",
+			status.Current, status.Latest)
+	}
+
+	a.App.InfoLog.Printf("migrating database schema from version %d to %d", status.Current, status.Latest)
+	return m.Up()
+}
+
+// checkSchemaDrift compares the models' db tags with the live tables before serving, as configured by
+// DATABASE_SCHEMA_CHECK: "warn", the default, logs each difference, "strict" also refuses to start, and
+// "off" skips the check.
+func (a *application) checkSchemaDrift() error {
+	mode := strings.ToLower(os.Getenv("DATABASE_SCHEMA_CHECK"))
+	switch mode {
+	case "off":
+		return nil
+	case "", "warn", "strict":
+	default:
+		return fmt.Errorf("unknown DATABASE_SCHEMA_CHECK: %s", mode)
+	}
+
+	drifts, err := data.CheckSchema(context.Background(), data.SchemaModels()...)
+	if err != nil {
+		if mode == "strict" {
+			return err
+		}
+		a.App.ErrorLog.Println("schema check failed:", err)
+		return nil
+	}
+	for _, d := range drifts {
+		a.App.ErrorLog.Println("schema drift:", d)
+	}
+	if mode == "strict" && len(drifts) > 0 {
+		return fmt.Errorf("%d schema differences found; fix them or set DATABASE_SCHEMA_CHECK=warn", len(drifts))
+	}
+	return nil
+}
@@ -1,6 +1,12 @@
 package main
 
 import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/jorgeSader/devify"
 	"github.com/jorgeSader/devify-test-app/data"
 	"github.com/jorgeSader/devify-test-app/handlers"
@@ -12,9 +18,42 @@ type application struct {
 	Handlers   *handlers.Handlers
 	Models     data.Models
 	Middleware *middleware.Middleware
+	Reaper     *data.Reaper
+	TokenUsage *data.TokenUsage
 }
 
 func main() {
 	c := InitApplication()
+	if len(os.Args) > 1 {
+		os.Exit(c.runCommand(os.Args[1:]))
+	}
+	if err := c.checkSchema(); err != nil {
+		log.Fatal(err)
+	}
+	if err := c.checkSchemaDrift(); err != nil {
+		log.Fatal(err)
+	}
+	c.startWorkers()
+	go c.shutdownOnSignal()
 	c.App.ListenAndServe()
 }
+
+// startWorkers starts the background workers the server needs. Commands run without them.
+func (a *application) startWorkers() {
+	a.Reaper.Start()
+	a.TokenUsage.Start()
+	a.Handlers.Exports.StartPruning()
+}
+
+// shutdownOnSignal waits for SIGINT or SIGTERM, then stops the background workers and exits.
+// A second signal exits immediately.
+func (a *application) shutdownOnSignal() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	a.App.InfoLog.Println("shutting down")
+	a.Reaper.Stop()
+	a.TokenUsage.Stop()
+	a.Handlers.Exports.Stop()
+	os.Exit(0)
+}
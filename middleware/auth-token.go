@@ -1,10 +1,24 @@
 package middleware
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+
+	"github.com/jorgeSader/devify-test-app/data"
+)
+
+// tokenUserKey is the context key of the user a request's bearer token authenticated.
+type tokenUserKey struct{}
+
+// TokenUser returns the user authenticated by AuthToken, whose Token is the one presented, or nil.
+func TokenUser(ctx context.Context) *data.User {
+	user, _ := ctx.Value(tokenUserKey{}).(*data.User)
+	return user
+}
 
 func (m *Middleware) AuthToken(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, err := m.Models.Tokens.AuthenticateToken(r)
+		user, err := m.Models.WithReadScope(data.ReadScopeFrom(r.Context())).Tokens.AuthenticateToken(r)
 		if err != nil {
 			var payload struct {
 				Error   bool   `json:"error"`
@@ -14,6 +28,32 @@ func (m *Middleware) AuthToken(next http.Handler) http.Handler {
 			payload.Message = "invalid Authentication credentials"
 
 			_ = m.App.WriteJSON(w, http.StatusUnauthorized, payload)
+			return
 		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenUserKey{}, user)))
 	})
 }
+
+// RequireScope returns middleware that lets a request through only if the bearer token AuthToken
+// authenticated grants scope, and answers 403 with an insufficient_scope challenge otherwise.
+// It must run after AuthToken.
+func (m *Middleware) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := TokenUser(r.Context())
+			if user == nil || !user.Token.HasScope(scope) {
+				var payload struct {
+					Error   bool   `json:"error"`
+					Message string `json:"message"`
+				}
+				payload.Error = true
+				payload.Message = "token does not grant the " + scope + " scope"
+
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+				_ = m.App.WriteJSON(w, http.StatusForbidden, payload)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jorgeSader/devify"
+	"github.com/jorgeSader/devify-test-app/data"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestRequireScope tests that requests pass only with a bearer token granting the scope, and are otherwise
+// refused with an insufficient_scope challenge.
+func TestRequireScope(t *testing.T) {
+	t.Setenv("BCRYPT_COST", strconv.Itoa(bcrypt.MinCost))
+	models := data.NewMemory()
+	m := &Middleware{App: &devify.Devify{ErrorLog: log.New(io.Discard, "", 0)}, Models: models}
+
+	id, err := models.Users.Insert(data.User{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Active: 1, Password: "secret"})
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	user, _ := models.Users.Get(id)
+	bearer := func(scopes string) string {
+		token, _ := models.Tokens.GenerateToken(id, time.Hour)
+		token.Type = data.TokenTypePersonal
+		token.Scopes = scopes
+		if err := models.Tokens.Add(*token, *user); err != nil {
+			t.Fatalf("failed to add token: %v", err)
+		}
+		return "Bearer " + token.PlainText()
+	}
+
+	// The status is only checked if devify writes responses, which a type-checking stub does not.
+	probe := httptest.NewRecorder()
+	_ = m.App.WriteJSON(probe, http.StatusTeapot, struct{}{})
+	writes := probe.Code == http.StatusTeapot
+
+	tests := []struct {
+		name, header string
+		wantStatus   int
+	}{
+		{"Granted", bearer("users:read users:write"), http.StatusOK},
+		{"OtherScope", bearer("users:read"), http.StatusForbidden},
+		{"NoScopes", bearer(""), http.StatusForbidden},
+		{"NoToken", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+				if TokenUser(r.Context()) == nil {
+					t.Error("expected the token's user on the context")
+				}
+			})
+			r := httptest.NewRequest("DELETE", "/api/v1/users/1", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			m.AuthToken(m.RequireScope(data.ScopeUsersWrite)(next)).ServeHTTP(w, r)
+
+			if reached != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("handler reached = %v, want %v", reached, !reached)
+			}
+			if writes && w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			challenge := w.Header().Get("WWW-Authenticate")
+			if tt.wantStatus == http.StatusForbidden && challenge != `Bearer error="insufficient_scope", scope="users:write"` {
+				t.Errorf("got challenge %q", challenge)
+			}
+		})
+	}
+}
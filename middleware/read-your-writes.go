@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jorgeSader/devify-test-app/data"
+)
+
+// readPrimaryCookie carries, in Unix nanoseconds, until when a client's reads go to the primary database
+// because it wrote recently.
+const readPrimaryCookie = "read_primary_until"
+
+// ReadYourWrites gives each request a data.ReadScope in its context, so that handlers using models bound to it
+// read the client's own writes despite replica lag. A scope pinned to the primary by a write outlives the
+// request in a cookie, so the client's next requests read from the primary too, until the pin expires.
+func (m *Middleware) ReadYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := data.NewReadScope(carriedPin(r))
+		w = &readScopeWriter{ResponseWriter: w, scope: scope, carried: scope.PinnedUntil()}
+		next.ServeHTTP(w, r.WithContext(data.WithReadScope(r.Context(), scope)))
+	})
+}
+
+// carriedPin returns until when the read-primary cookie pins the client's reads to the primary. The cookie comes
+// from the client, so a pin further ahead than a write could have set is ignored rather than trusted to keep the
+// client's reads off the replicas indefinitely.
+func carriedPin(r *http.Request) time.Time {
+	c, err := r.Cookie(readPrimaryCookie)
+	if err != nil {
+		return time.Time{}
+	}
+	ns, err := strconv.ParseInt(c.Value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	until := time.Unix(0, ns)
+	if until.After(time.Now().Add(data.ReplicaSticky())) {
+		return time.Time{}
+	}
+	return until
+}
+
+// readScopeWriter sets the read-primary cookie when the response starts, if the request's writes moved the
+// pin of its scope.
+type readScopeWriter struct {
+	http.ResponseWriter
+	scope       *data.ReadScope
+	carried     time.Time
+	wroteHeader bool
+}
+
+func (w *readScopeWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if until := w.scope.PinnedUntil(); until.After(w.carried) {
+			http.SetCookie(w.ResponseWriter, &http.Cookie{
+				Name:     readPrimaryCookie,
+				Value:    strconv.FormatInt(until.UnixNano(), 10),
+				Path:     "/",
+				Expires:  until,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *readScopeWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, for flushing streamed responses.
+func (w *readScopeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
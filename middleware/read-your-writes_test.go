@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestCarriedPin tests that the read-primary cookie is honoured only up to the sticky window from now.
+func TestCarriedPin(t *testing.T) {
+	past := time.Now().Add(-time.Second)
+
+	tests := []struct {
+		name   string
+		cookie string
+		want   time.Time
+	}{
+		{"NoCookie", "", time.Time{}},
+		{"Malformed", "soon", time.Time{}},
+		{"Expired", strconv.FormatInt(past.UnixNano(), 10), past},
+		{"FarFuture", strconv.FormatInt(time.Now().Add(24*time.Hour).UnixNano(), 10), time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/v1/users", nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: readPrimaryCookie, Value: tt.cookie})
+			}
+			if got := carriedPin(r); !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+// Package migrations embeds the SQL migrations in the binary and applies them with golang-migrate.
+//
+// Each dialect and key type has its own directory: the top level holds the PostgreSQL migrations for serial
+// keys, uuid and ulid hold the PostgreSQL migrations for those key types, and mysql and sqlite hold the
+// migrations for those databases. MySQL connections must allow multiple statements (multiStatements=true in
+// the DSN), and SQLite builds need the sqlite_fts5 build tag for the full-text search migration.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/mysql"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+//go:embed *.sql mysql sqlite uuid ulid
+var files embed.FS
+
+// ErrDirty is returned when a previous migration failed halfway. The schema must be repaired by hand and the
+// version set with Force before migrating again.
+var ErrDirty = errors.New("database schema is dirty: a migration failed and must be fixed, then forced")
+
+// Dir returns the migrations directory for a DATABASE_TYPE and DATABASE_KEY_TYPE.
+func Dir(dbType, keyType string) (string, error) {
+	keyType = strings.ToLower(keyType)
+	if keyType == "" {
+		keyType = "serial"
+	}
+	switch strings.ToLower(dbType) {
+	case "postgres", "postgresql":
+		switch keyType {
+		case "serial":
+			return ".", nil
+		case "uuid", "ulid":
+			return keyType, nil
+		}
+	case "mysql", "mariadb":
+		if keyType == "serial" {
+			return "mysql", nil
+		}
+	case "sqlite", "sqlite3", "turso", "libsql":
+		if keyType == "serial" {
+			return "sqlite", nil
+		}
+	default:
+		return "", fmt.Errorf("migrations are not available for DATABASE_TYPE %q", dbType)
+	}
+	return "", fmt.Errorf("migrations are not available for %s with %s keys: uuid and ulid keys need PostgreSQL", dbType, keyType)
+}
+
+// Migration is one embedded migration.
+type Migration struct {
+	Version uint
+	Name    string
+}
+
+// Status describes how far the database schema is migrated.
+type Status struct {
+	Current uint // version of the last applied migration, or 0 if none has been applied
+	Dirty   bool // the current migration failed halfway
+	Latest  uint // version of the newest embedded migration
+	Applied []Migration
+	Pending []Migration
+}
+
+// Behind reports whether there are migrations left to apply.
+func (s *Status) Behind() bool {
+	return len(s.Pending) > 0
+}
+
+// Migrator applies the embedded migrations for one database.
+type Migrator struct {
+	m          *migrate.Migrate
+	src        source.Driver
+	db         database.Driver
+	closeDB    bool
+	migrations []Migration
+}
+
+// New returns a Migrator for the database behind pool, using the migrations directory for dbType and keyType.
+// For PostgreSQL and MySQL it holds one connection from the pool until Close; the pool itself stays open.
+func New(pool *sql.DB, dbType, keyType string) (*Migrator, error) {
+	dir, err := Dir(dbType, keyType)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := list(dir)
+	if err != nil {
+		return nil, err
+	}
+	src, err := iofs.New(files, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	mg := &Migrator{src: src, migrations: migrations}
+	var name string
+	switch dir {
+	case "mysql":
+		name = "mysql"
+		conn, err := pool.Conn(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		if mg.db, err = mysql.WithConnection(context.Background(), conn, &mysql.Config{}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		mg.closeDB = true
+	case "sqlite":
+		// The sqlite3 driver closes the whole pool on Close, so it is left open; it holds no connection of its own.
+		name = "sqlite3"
+		if mg.db, err = sqlite3.WithInstance(pool, &sqlite3.Config{}); err != nil {
+			return nil, err
+		}
+	default:
+		name = "postgres"
+		conn, err := pool.Conn(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		if mg.db, err = postgres.WithConnection(context.Background(), conn, &postgres.Config{}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		mg.closeDB = true
+	}
+
+	mg.m, err = migrate.NewWithInstance("iofs", src, name, mg.db)
+	if err != nil {
+		mg.Close()
+		return nil, err
+	}
+	return mg, nil
+}
+
+// list returns the migrations in dir, oldest first.
+func list(dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".up.sql")
+		if !ok {
+			continue
+		}
+		v, title, _ := strings.Cut(name, "_")
+		version, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %s", e.Name())
+		}
+		migrations = append(migrations, Migration{Version: uint(version), Name: title})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Close releases the migrator's connection.
+func (mg *Migrator) Close() error {
+	err := mg.src.Close()
+	if mg.closeDB {
+		if dbErr := mg.db.Close(); err == nil {
+			err = dbErr
+		}
+	}
+	return err
+}
+
+// Up applies all pending migrations.
+func (mg *Migrator) Up() error {
+	return ignoreNoChange(mg.m.Up())
+}
+
+// Down reverts the last n applied migrations.
+func (mg *Migrator) Down(n int) error {
+	return ignoreNoChange(mg.m.Steps(-n))
+}
+
+// DownAll reverts every applied migration.
+func (mg *Migrator) DownAll() error {
+	return ignoreNoChange(mg.m.Down())
+}
+
+// To migrates up or down to the given version, which must be an embedded migration.
+func (mg *Migrator) To(version uint) error {
+	return ignoreNoChange(mg.m.Migrate(version))
+}
+
+// Force records version as the current, clean schema version without running any migration.
+// It is used to recover from a failed migration after repairing the schema by hand; -1 means no version.
+func (mg *Migrator) Force(version int) error {
+	return mg.m.Force(version)
+}
+
+// Status reports the current schema version and the applied and pending migrations.
+func (mg *Migrator) Status() (*Status, error) {
+	current, dirty, err := mg.m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return nil, err
+	}
+	s := &Status{Current: current, Dirty: dirty}
+	for _, m := range mg.migrations {
+		if m.Version <= current {
+			s.Applied = append(s.Applied, m)
+		} else {
+			s.Pending = append(s.Pending, m)
+		}
+	}
+	if n := len(mg.migrations); n > 0 {
+		s.Latest = mg.migrations[n-1].Version
+	}
+	return s, nil
+}
+
+// ignoreNoChange treats migrate.ErrNoChange as success, and reports a dirty schema as ErrDirty.
+func ignoreNoChange(err error) error {
+	var dirty migrate.ErrDirty
+	switch {
+	case errors.Is(err, migrate.ErrNoChange):
+		return nil
+	case errors.As(err, &dirty):
+		return fmt.Errorf("%w (version %d)", ErrDirty, dirty.Version)
+	}
+	return err
+}
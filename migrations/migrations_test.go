@@ -0,0 +1,176 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// TestDir tests that each supported database and key type maps to its migrations directory.
+func TestDir(t *testing.T) {
+	tests := []struct {
+		dbType, keyType, want string
+	}{
+		{"postgres", "", "."},
+		{"postgresql", "serial", "."},
+		{"postgres", "UUID", "uuid"},
+		{"postgres", "ulid", "ulid"},
+		{"mariadb", "", "mysql"},
+		{"sqlite", "", "sqlite"},
+		{"turso", "serial", "sqlite"},
+	}
+	for _, tt := range tests {
+		got, err := Dir(tt.dbType, tt.keyType)
+		if err != nil || got != tt.want {
+			t.Errorf("Dir(%q, %q) = %q, %v; want %q", tt.dbType, tt.keyType, got, err, tt.want)
+		}
+	}
+	for _, bad := range [][2]string{{"mysql", "uuid"}, {"sqlite", "ulid"}, {"oracle", ""}, {"", ""}} {
+		if _, err := Dir(bad[0], bad[1]); err == nil {
+			t.Errorf("Dir(%q, %q) returned no error", bad[0], bad[1])
+		}
+	}
+}
+
+// TestEmbedded tests that every directory embeds the same migrations, each with an up and a down file.
+func TestEmbedded(t *testing.T) {
+	want, err := list(".")
+	if err != nil || len(want) == 0 {
+		t.Fatalf("list(.) = %v, %v", want, err)
+	}
+	for _, dir := range []string{".", "uuid", "ulid", "mysql", "sqlite"} {
+		got, err := list(dir)
+		if err != nil {
+			t.Fatalf("list(%s): %v", dir, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s has %d migrations, want %d", dir, len(got), len(want))
+		}
+		for i, m := range got {
+			if m != want[i] {
+				t.Errorf("%s migration %d is %v, want %v", dir, i, m, want[i])
+			}
+			down := fmt.Sprintf("%d_%s.down.sql", m.Version, m.Name)
+			if _, err := fs.Stat(files, path.Join(dir, down)); err != nil {
+				t.Errorf("%s: %v", dir, err)
+			}
+		}
+	}
+}
+
+// TestMigrator tests migrating a SQLite database up, down and to a version, and the reported status.
+// It stops short of the full-text search migration, which needs SQLite built with FTS5.
+func TestMigrator(t *testing.T) {
+	pool, err := sql.Open("sqlite3", "file:"+path.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	m, err := New(pool, "sqlite", "")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer m.Close()
+
+	all := m.migrations
+	s, err := m.Status()
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if s.Current != 0 || !s.Behind() || len(s.Pending) != len(all) || s.Latest != all[len(all)-1].Version {
+		t.Fatalf("Status() of an empty database = %+v", s)
+	}
+
+	fts := 0
+	for fts < len(all) && all[fts].Name != "add_search_to_users" {
+		fts++
+	}
+	if fts < 3 || fts == len(all) {
+		t.Fatalf("full-text search migration not found in %v", all)
+	}
+	target := all[fts-1].Version
+	if err := m.To(target); err != nil {
+		t.Fatalf("To(%d) error = %v", target, err)
+	}
+	if s, _ = m.Status(); s.Current != target || len(s.Applied) != fts || len(s.Pending) != len(all)-fts {
+		t.Fatalf("Status() after To(%d) = %+v", target, s)
+	}
+	if _, err := pool.Exec("SELECT version FROM users"); err != nil {
+		t.Errorf("users table not migrated: %v", err)
+	}
+
+	if err := m.Down(2); err != nil {
+		t.Fatalf("Down(2) error = %v", err)
+	}
+	if s, _ = m.Status(); s.Current != all[fts-3].Version {
+		t.Errorf("Status() after Down(2) = %+v", s)
+	}
+
+	if err := m.DownAll(); err != nil {
+		t.Fatalf("DownAll() error = %v", err)
+	}
+	if s, _ = m.Status(); s.Current != 0 || len(s.Applied) != 0 {
+		t.Errorf("Status() after DownAll() = %+v", s)
+	}
+	if err := m.DownAll(); err != nil {
+		t.Errorf("DownAll() with nothing applied: %v", err)
+	}
+
+	if err := m.Force(int(all[0].Version)); err != nil {
+		t.Fatalf("Force() error = %v", err)
+	}
+	if s, _ = m.Status(); s.Current != all[0].Version || s.Dirty {
+		t.Errorf("Status() after Force() = %+v", s)
+	}
+	if err := pool.Ping(); err != nil {
+		t.Errorf("pool closed by migrator: %v", err)
+	}
+}
+
+// TestMigrator_UpAll tests migrating a SQLite database all the way up and back down. It needs the sqlite_fts5
+// build tag, which make test sets, for the full-text search migration.
+func TestMigrator_UpAll(t *testing.T) {
+	pool, err := sql.Open("sqlite3", "file:"+path.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if _, err := pool.Exec("CREATE VIRTUAL TABLE fts5_probe USING fts5(body)"); err != nil {
+		t.Skipf("SQLite built without FTS5, run with -tags sqlite_fts5: %v", err)
+	}
+	if _, err := pool.Exec("DROP TABLE fts5_probe"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(pool, "sqlite", "")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+	s, err := m.Status()
+	if err != nil || s.Behind() || s.Dirty || s.Current != s.Latest {
+		t.Fatalf("Status() after Up() = %+v, %v", s, err)
+	}
+	if _, err := pool.Exec("SELECT rowid FROM users_fts WHERE users_fts MATCH 'jane'"); err != nil {
+		t.Errorf("full-text search index not migrated: %v", err)
+	}
+	if _, err := pool.Exec("SELECT token_hash FROM one_time_tokens"); err != nil {
+		t.Errorf("one_time_tokens table not migrated: %v", err)
+	}
+
+	if err := m.DownAll(); err != nil {
+		t.Fatalf("DownAll() error = %v", err)
+	}
+	if s, _ = m.Status(); s.Current != 0 || len(s.Applied) != 0 {
+		t.Errorf("Status() after DownAll() = %+v", s)
+	}
+}
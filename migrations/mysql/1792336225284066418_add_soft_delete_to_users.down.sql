@@ -0,0 +1,8 @@
+-- Soft-deleted rows cannot be told apart once deleted_at is gone, so they are purged first.
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+
+DROP INDEX users_deleted_at_idx ON users;
+DROP INDEX users_email_live_idx ON users;
+ALTER TABLE users DROP COLUMN live_email;
+ALTER TABLE users ADD UNIQUE INDEX email (email);
+ALTER TABLE users DROP COLUMN deleted_at;
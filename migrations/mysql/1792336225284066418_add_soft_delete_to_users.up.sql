@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN deleted_at timestamp NULL DEFAULT NULL;
+
+-- MySQL has no partial indexes, so email uniqueness among live users is enforced on a generated column
+-- that is NULL for soft-deleted users; a unique index allows any number of NULLs.
+ALTER TABLE users DROP INDEX email;
+ALTER TABLE users ADD COLUMN live_email varchar(255) AS (IF(deleted_at IS NULL, email, NULL)) STORED;
+CREATE UNIQUE INDEX users_email_live_idx ON users (live_email);
+CREATE INDEX users_deleted_at_idx ON users (deleted_at);
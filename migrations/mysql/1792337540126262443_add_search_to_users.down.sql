@@ -0,0 +1 @@
+DROP INDEX users_search_idx ON users;
@@ -0,0 +1,2 @@
+-- Used by User.Search. InnoDB splits email addresses on @ and . so each part is indexed as a word.
+CREATE FULLTEXT INDEX users_search_idx ON users (first_name, last_name, email);
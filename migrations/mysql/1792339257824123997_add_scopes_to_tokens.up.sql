@@ -0,0 +1,2 @@
+-- scopes is a space-separated list of OAuth-style scopes the token grants, reported by token introspection.
+ALTER TABLE tokens ADD COLUMN scopes varchar(1024) NOT NULL DEFAULT '';
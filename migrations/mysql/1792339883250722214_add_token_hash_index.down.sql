@@ -0,0 +1 @@
+DROP INDEX tokens_token_hash_idx ON tokens;
@@ -0,0 +1,2 @@
+-- Bearer tokens are looked up by hash on every authenticated request.
+CREATE UNIQUE INDEX tokens_token_hash_idx ON tokens (token_hash);
@@ -0,0 +1,15 @@
+-- Single-use secrets sent in email links, such as password resets. They live apart from tokens so that
+-- one can never be presented as the other; see data.OneTimeToken.
+CREATE TABLE one_time_tokens (
+    id bigint unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id bigint unsigned NOT NULL,
+    email varchar(255) NOT NULL,
+    purpose varchar(32) NOT NULL,
+    token_hash varbinary(32) NOT NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expiry timestamp NOT NULL,
+    used_at timestamp NULL,
+    CONSTRAINT one_time_tokens_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE UNIQUE INDEX one_time_tokens_token_hash_idx ON one_time_tokens (token_hash);
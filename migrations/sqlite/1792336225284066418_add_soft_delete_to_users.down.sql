@@ -0,0 +1,7 @@
+-- Soft-deleted rows cannot be told apart once deleted_at is gone, so they are purged first.
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS users_deleted_at_idx;
+DROP INDEX IF EXISTS users_email_live_idx;
+CREATE UNIQUE INDEX users_email_key ON users (email);
+ALTER TABLE users DROP COLUMN deleted_at;
@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN deleted_at DATETIME;
+
+-- Soft-deleted users keep their row, so email only has to be unique among live users.
+DROP INDEX IF EXISTS users_email_key;
+CREATE UNIQUE INDEX users_email_live_idx ON users (email) WHERE deleted_at IS NULL;
+CREATE INDEX users_deleted_at_idx ON users (deleted_at);
@@ -0,0 +1,27 @@
+-- users_fts is an external-content FTS5 index over users, used by User.Search.
+-- FTS5 must be compiled in; with github.com/mattn/go-sqlite3 build with -tags sqlite_fts5.
+-- The unicode61 tokenizer splits email addresses on @ and . so each part is indexed as a word.
+CREATE VIRTUAL TABLE users_fts USING fts5(
+    first_name, last_name, email,
+    content='users', content_rowid='id'
+);
+
+INSERT INTO users_fts (rowid, first_name, last_name, email)
+    SELECT id, first_name, last_name, email FROM users;
+
+CREATE TRIGGER users_fts_insert AFTER INSERT ON users BEGIN
+    INSERT INTO users_fts (rowid, first_name, last_name, email)
+        VALUES (new.id, new.first_name, new.last_name, new.email);
+END;
+
+CREATE TRIGGER users_fts_delete AFTER DELETE ON users BEGIN
+    INSERT INTO users_fts (users_fts, rowid, first_name, last_name, email)
+        VALUES ('delete', old.id, old.first_name, old.last_name, old.email);
+END;
+
+CREATE TRIGGER users_fts_update AFTER UPDATE OF first_name, last_name, email ON users BEGIN
+    INSERT INTO users_fts (users_fts, rowid, first_name, last_name, email)
+        VALUES ('delete', old.id, old.first_name, old.last_name, old.email);
+    INSERT INTO users_fts (rowid, first_name, last_name, email)
+        VALUES (new.id, new.first_name, new.last_name, new.email);
+END;
@@ -0,0 +1,3 @@
+ALTER TABLE tokens DROP COLUMN use_count;
+ALTER TABLE tokens DROP COLUMN last_used_ip;
+ALTER TABLE tokens DROP COLUMN last_used_at;
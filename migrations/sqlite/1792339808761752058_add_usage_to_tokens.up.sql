@@ -0,0 +1,5 @@
+-- Token usage, written in batches by the token usage tracker: when and from where a token last authenticated,
+-- and how many times it has.
+ALTER TABLE tokens ADD COLUMN last_used_at DATETIME;
+ALTER TABLE tokens ADD COLUMN last_used_ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE tokens ADD COLUMN use_count INTEGER NOT NULL DEFAULT 0;
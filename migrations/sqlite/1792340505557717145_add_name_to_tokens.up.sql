@@ -0,0 +1,2 @@
+-- name labels a personal access token on the tokens page; other tokens leave it empty.
+ALTER TABLE tokens ADD COLUMN name TEXT NOT NULL DEFAULT '';
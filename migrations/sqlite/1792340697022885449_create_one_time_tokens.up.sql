@@ -0,0 +1,14 @@
+-- Single-use secrets sent in email links, such as password resets. They live apart from tokens so that
+-- one can never be presented as the other; see data.OneTimeToken.
+CREATE TABLE one_time_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    email TEXT NOT NULL,
+    purpose TEXT NOT NULL,
+    token_hash BLOB NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expiry DATETIME NOT NULL,
+    used_at DATETIME
+);
+
+CREATE UNIQUE INDEX one_time_tokens_token_hash_idx ON one_time_tokens (token_hash);
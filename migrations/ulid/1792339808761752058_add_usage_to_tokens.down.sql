@@ -0,0 +1,3 @@
+ALTER TABLE tokens DROP COLUMN IF EXISTS use_count;
+ALTER TABLE tokens DROP COLUMN IF EXISTS last_used_ip;
+ALTER TABLE tokens DROP COLUMN IF EXISTS last_used_at;
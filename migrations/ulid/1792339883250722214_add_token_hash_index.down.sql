@@ -0,0 +1 @@
+DROP INDEX IF EXISTS tokens_token_hash_idx;
@@ -0,0 +1,14 @@
+-- Single-use secrets sent in email links, such as password resets. They live apart from tokens so that
+-- one can never be presented as the other; see data.OneTimeToken.
+CREATE TABLE one_time_tokens (
+    id character(26) PRIMARY KEY,
+    user_id character(26) NOT NULL REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    email character varying(255) NOT NULL,
+    purpose character varying(32) NOT NULL,
+    token_hash bytea NOT NULL,
+    created_at timestamp without time zone NOT NULL DEFAULT now(),
+    expiry timestamp without time zone NOT NULL,
+    used_at timestamp without time zone
+);
+
+CREATE UNIQUE INDEX one_time_tokens_token_hash_idx ON one_time_tokens (token_hash);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS users CASCADE;
+DROP TABLE IF EXISTS tokens CASCADE;
+DROP TABLE IF EXISTS remember_tokens;
\ No newline at end of file
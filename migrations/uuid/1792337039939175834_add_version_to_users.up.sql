@@ -0,0 +1,2 @@
+-- version is bumped by every update, so concurrent edits can detect that the row moved on.
+ALTER TABLE users ADD COLUMN version integer NOT NULL DEFAULT 1;
@@ -0,0 +1,9 @@
+-- search is a weighted full-text document over the user's name and email, used by User.Search.
+-- Email addresses are split on @ and . so that each part can be searched for on its own.
+ALTER TABLE users ADD COLUMN search tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', coalesce(first_name, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(last_name, '')), 'A') ||
+    setweight(to_tsvector('simple', regexp_replace(coalesce(email, ''), '[@.]', ' ', 'g')), 'B')
+) STORED;
+
+CREATE INDEX users_search_idx ON users USING GIN (search);
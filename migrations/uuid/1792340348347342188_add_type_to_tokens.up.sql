@@ -0,0 +1,2 @@
+-- token_type selects the expiry policy of a token, such as sliding expiration; see data.ExpiryPolicy.
+ALTER TABLE tokens ADD COLUMN token_type varchar(32) NOT NULL DEFAULT 'api';
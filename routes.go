@@ -1,9 +1,8 @@
 package main
 
 import (
-	"fmt"
+	"expvar"
 	"net/http"
-	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -30,6 +29,14 @@ func (a *application) routes() *chi.Mux {
 	a.post("/users/login", a.Handlers.PostUserLogin)
 	a.get("/users/logout", a.Handlers.Logout)
 
+	a.App.Routes.Route("/users/tokens", func(r chi.Router) {
+		r.Use(a.Middleware.ReadYourWrites)
+		r.Use(a.Middleware.Auth)
+		r.Get("/", a.Handlers.UserTokens)
+		r.Post("/", a.Handlers.UserTokenCreate)
+		r.Post("/{id}/revoke", a.Handlers.UserTokenRevoke)
+	})
+
 	a.get("/form", a.Handlers.Form)
 	a.post("/form", a.Handlers.PostForm)
 
@@ -39,82 +46,42 @@ func (a *application) routes() *chi.Mux {
 
 	a.get("/crypto", a.Handlers.TestCrypto)
 
-	a.get("/create-user", func(w http.ResponseWriter, r *http.Request) {
-		u := data.User{
-			FirstName: "Jorge",
-			LastName:  "Sader",
-			Email:     "test@email.com",
-			Active:    1,
-			Password:  "Test@123",
-		}
-
-		id, err := a.Models.Users.Insert(u)
-		if err != nil {
-			a.App.ErrorLog.Println(err)
-		}
-		fmt.Fprintf(w, "%d: %s", id, u.FirstName)
-	})
-
-	a.get("/get-all-users", func(w http.ResponseWriter, r *http.Request) {
-		users, err := a.Models.Users.GetAll()
-		if err != nil {
-			a.App.ErrorLog.Println(err)
-			return
-		}
-		for _, user := range users {
-			fmt.Fprintln(w, user.LastName)
-		}
-
-	})
-
-	a.get("/get-user/{id}", func(w http.ResponseWriter, r *http.Request) {
-		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-
-		user, err := a.Models.Users.Get(id)
-		if err != nil {
-			a.App.ErrorLog.Println(err)
-			return
-		}
-		fmt.Fprintf(w, "id %d belongs to user %s %s", id, user.FirstName, user.LastName)
-
+	a.App.Routes.Route("/admin", func(r chi.Router) {
+		r.Use(a.Middleware.ReadYourWrites)
+		r.Use(a.Middleware.Auth)
+		r.Get("/users", a.Handlers.AdminUsers)
+		r.Get("/users/import", a.Handlers.AdminUsersImport)
+		r.Post("/users/import", a.Handlers.AdminUsersImportUpload)
+		r.Get("/users/export", a.Handlers.AdminUsersExport)
+		r.Get("/users/exports/{id}", a.Handlers.AdminUsersExportJob)
+		r.Get("/users/exports/{id}/download", a.Handlers.AdminUsersExportDownload)
+		r.Get("/users/{id}/edit", a.Handlers.AdminUserEdit)
+		r.Post("/users/{id}", a.Handlers.AdminUserUpdate)
+		r.Put("/users/{id}", a.Handlers.AdminUserUpdateJSON)
+		r.Handle("/debug/vars", expvar.Handler())
 	})
 
-	a.get("/update-user/{id}", func(w http.ResponseWriter, r *http.Request) {
-		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-
-		user, err := a.Models.Users.Get(id)
-		if err != nil {
-			a.App.ErrorLog.Println(err)
-			return
-		}
-
-		user.LastName = a.App.RandomString(11)
-
-		///////////////////////////////////
-		// Testing new validator package //
-		///////////////////////////////////
-		validator := a.App.Validator(r) // r.Form ignored since we overwrite Data
-
-		user.Validate(validator)
-
-		// Output results
-		if !validator.Valid() {
-			w.Write([]byte("Validation failed:\n"))
-			for field, err := range validator.Errors {
-				fmt.Fprintf(w, "%s: %s\n", field, err)
-			}
-			return
-		}
-
-		// If we reach here, update the user (though this is just a test)
-		err = a.Models.Users.Update(*user)
-		if err != nil {
-			a.App.ErrorLog.Println("Update failed:", err)
-			http.Error(w, "Failed to update user", http.StatusInternalServerError)
-			return
-		}
-
-		fmt.Fprintf(w, "User with id %d updated to %s %s (all validations passed!)", id, user.FirstName, user.LastName)
+	a.App.Routes.Route("/api/v1", func(r chi.Router) {
+		// Introspection clients authenticate with their own credentials rather than a bearer token.
+		r.Post("/introspect", a.Handlers.TokenIntrospect)
+
+		r.Group(func(r chi.Router) {
+			r.Use(a.Middleware.ReadYourWrites)
+			r.Use(a.Middleware.AuthToken)
+
+			r.Group(func(r chi.Router) {
+				r.Use(a.Middleware.RequireScope(data.ScopeUsersRead))
+				r.Get("/users", a.Handlers.APIUsers)
+				r.Get("/users/search", a.Handlers.APIUserSearch)
+				r.Get("/users/{id}", a.Handlers.APIUser)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(a.Middleware.RequireScope(data.ScopeUsersWrite))
+				r.Post("/users", a.Handlers.APIUserCreate)
+				r.Patch("/users/{id}", a.Handlers.APIUserPatch)
+				r.Delete("/users/{id}", a.Handlers.APIUserDelete)
+			})
+		})
 	})
 
 	//static routes